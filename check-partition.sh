@@ -162,11 +162,16 @@ for port in 8080 8082 8083 8084 18080 18082 18083; do
         node_id=$(echo "$health" | jq -r '.node_id // "unknown"' 2>/dev/null || echo "unknown")
         is_leader=$(echo "$health" | jq -r '.is_leader // false' 2>/dev/null || echo "false")
         leader=$(echo "$health" | jq -r '.leader // "unknown"' 2>/dev/null || echo "unknown")
+        strict=$(echo "$health" | jq -r '.strict_quorum // false' 2>/dev/null || echo "false")
+        mode=""
+        if [ "$strict" = "true" ]; then
+            mode=" [quórum estricto]"
+        fi
         
         if [ "$is_leader" = "true" ]; then
-            echo -e "${GREEN}✓${NC} $node_name ($node_id) - ${YELLOW}LÍDER${NC}"
+            echo -e "${GREEN}✓${NC} $node_name ($node_id) - ${YELLOW}LÍDER${NC}$mode"
         else
-            echo -e "  $node_name ($node_id) - líder: $leader"
+            echo -e "  $node_name ($node_id) - líder: $leader$mode"
         fi
     else
         echo -e "${RED}✗${NC} $node_name - no responde"
@@ -0,0 +1,52 @@
+package agendadistribuida
+
+import (
+	"net/http"
+	"time"
+)
+
+// Clock drives the timers of the consensus loop (heartbeats, election
+// timeouts, the periodic digest check) and the time CheckQuorum measures
+// leader contact with. Nodes use
+// SystemClock; tests swap in a manual clock (see raftest) with SetClock to
+// decide when elections and heartbeats happen. Network waits and replication
+// retries still use real time.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the part of time.Ticker the consensus loop uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// SystemClock is the real clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time   { return t.t.C }
+func (t systemTicker) Reset(d time.Duration) { t.t.Reset(d) }
+func (t systemTicker) Stop()                 { t.t.Stop() }
+
+// SetClock replaces the clock of the consensus loop. It must be called before
+// Start.
+func (c *ConsensusImpl) SetClock(clk Clock) {
+	c.mu.Lock()
+	c.clock = clk
+	c.mu.Unlock()
+}
+
+// SetTransport replaces the transport under the fault injector, through which
+// every node-to-node request goes (e.g. an in-memory network in tests). It must
+// be called before Start.
+func (c *ConsensusImpl) SetTransport(rt http.RoundTripper) {
+	c.faults.base = rt
+}
@@ -8,9 +8,10 @@ import (
 	"github.com/gorilla/mux"
 )
 
-func RegisterClusterHTTP(r *mux.Router, store *Storage, peers *EnvPeerStore) {
-	r.HandleFunc("/cluster/join", clusterJoinHandler(store, peers)).Methods(http.MethodPost)
-	r.HandleFunc("/cluster/leave", clusterLeaveHandler(store, peers)).Methods(http.MethodPost)
+func RegisterClusterHTTP(r *mux.Router, store *Storage, peers *EnvPeerStore, cons Consensus) {
+	r.HandleFunc("/cluster/join", clusterJoinHandler(store, peers, cons)).Methods(http.MethodPost)
+	r.HandleFunc("/cluster/leave", clusterLeaveHandler(store, peers, cons)).Methods(http.MethodPost)
+	r.HandleFunc("/cluster/promote", clusterPromoteHandler(store, peers, cons)).Methods(http.MethodPost)
 	r.HandleFunc("/cluster/nodes", clusterNodesHandler(store)).Methods(http.MethodGet)
 	r.HandleFunc("/cluster/local-audit/users", clusterLocalUserAuditHandler(store)).Methods(http.MethodGet)
 	r.HandleFunc("/cluster/local-events/appointments", clusterLocalAppointmentsHandler(store)).Methods(http.MethodGet)
@@ -20,11 +21,48 @@ func RegisterClusterHTTP(r *mux.Router, store *Storage, peers *EnvPeerStore) {
 	r.HandleFunc("/cluster/local-events/notifications", clusterLocalNotificationsHandler(store)).Methods(http.MethodGet)
 }
 
-func clusterJoinHandler(store *Storage, peers *EnvPeerStore) http.HandlerFunc {
+// membershipChange applies a join/leave to the replicated configuration when
+// this node is the leader. Followers relay the request to the leader they know
+// about (once: relayed requests are marked as forwarded) so discovery can hit
+// any node. It returns a short status for the HTTP response.
+func membershipChange(cons Consensus, path string, body any, forwarded bool, apply func(*ConsensusImpl) error) string {
+	if m, ok := cons.(*MultiRaft); ok {
+		return m.membershipChange(path, body, forwarded, apply)
+	}
+	impl, ok := cons.(*ConsensusImpl)
+	if !ok || impl == nil {
+		return "disabled"
+	}
+	if impl.IsLeader() {
+		if err := apply(impl); err != nil {
+			return "error: " + err.Error()
+		}
+		return "committed"
+	}
+	leader := impl.LeaderID()
+	if forwarded || leader == "" || leader == impl.nodeID {
+		return "no_leader"
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	go impl.postJSON("http://"+impl.peerAddr(leader)+path, payload)
+	return "forwarded"
+}
+
+// clusterJoinHandler records a peer announced by discovery gossip or an
+// operator. A node unknown to the configuration is added as a learner; members
+// are left alone (voters are only made through /cluster/promote) and removed
+// nodes stay out unless the request sets readmit.
+func clusterJoinHandler(store *Storage, peers *EnvPeerStore, cons Consensus) http.HandlerFunc {
 	type joinReq struct {
-		NodeID  string `json:"node_id"`
-		Address string `json:"address"`
-		Source  string `json:"source"`
+		NodeID    string `json:"node_id"`
+		Address   string `json:"address"`
+		Source    string `json:"source"`
+		Learner   bool   `json:"learner,omitempty"`
+		Readmit   bool   `json:"readmit,omitempty"`
+		Forwarded bool   `json:"forwarded,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !validateClusterHMAC(w, r) {
@@ -47,24 +85,43 @@ func clusterJoinHandler(store *Storage, peers *EnvPeerStore) http.HandlerFunc {
 			Address:  req.Address,
 			Source:   fallback(req.Source, "gossip"),
 			LastSeen: time.Now(),
+			Learner:  req.Learner,
 		}
 		if err := store.UpsertClusterNode(node); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if req.Learner {
+			_ = store.SetClusterNodeLearner(node.NodeID, true)
+		}
 		peers.UpsertPeer(node.NodeID, node.Address)
+		if req.Learner {
+			peers.SetLearner(node.NodeID, true)
+		}
 		nodes, err := store.ListClusterNodes()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		fwd := req
+		fwd.Forwarded = true
+		membership := membershipChange(groupConsensus(cons, r), "/cluster/join", fwd, req.Forwarded, func(c *ConsensusImpl) error {
+			if req.Readmit {
+				return c.ReadmitLearner(node.NodeID, node.Address)
+			}
+			return c.AddLearner(node.NodeID, node.Address)
+		})
 		RecordAudit(r.Context(), AuditLevelInfo, "cluster", "join", "peer joined cluster", map[string]any{
-			"node_id": node.NodeID,
-			"address": node.Address,
+			"node_id":    node.NodeID,
+			"address":    node.Address,
+			"learner":    req.Learner,
+			"readmit":    req.Readmit,
+			"membership": membership,
 		})
 		json.NewEncoder(w).Encode(map[string]any{
-			"status": "joined",
-			"nodes":  nodes,
+			"status":     "joined",
+			"nodes":      nodes,
+			"membership": membership,
 		})
 	}
 }
@@ -191,9 +248,10 @@ func clusterLocalAppointmentsHandler(store *Storage) http.HandlerFunc {
 	}
 }
 
-func clusterLeaveHandler(store *Storage, peers *EnvPeerStore) http.HandlerFunc {
+func clusterLeaveHandler(store *Storage, peers *EnvPeerStore, cons Consensus) http.HandlerFunc {
 	type leaveReq struct {
-		NodeID string `json:"node_id"`
+		NodeID    string `json:"node_id"`
+		Forwarded bool   `json:"forwarded,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !validateClusterHMAC(w, r) {
@@ -212,11 +270,54 @@ func clusterLeaveHandler(store *Storage, peers *EnvPeerStore) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		fwd := req
+		fwd.Forwarded = true
+		membership := membershipChange(groupConsensus(cons, r), "/cluster/leave", fwd, req.Forwarded, func(c *ConsensusImpl) error {
+			return c.RemoveServer(req.NodeID)
+		})
 		peers.RemovePeer(req.NodeID)
 		RecordAudit(r.Context(), AuditLevelInfo, "cluster", "leave", "peer left cluster", map[string]any{
-			"node_id": req.NodeID,
+			"node_id":    req.NodeID,
+			"membership": membership,
+		})
+		json.NewEncoder(w).Encode(map[string]string{"status": "removed", "membership": membership})
+	}
+}
+
+// clusterPromoteHandler turns a caught-up learner into a voter. Like join and
+// leave it can be sent to any node; followers relay it to the leader.
+func clusterPromoteHandler(store *Storage, peers *EnvPeerStore, cons Consensus) http.HandlerFunc {
+	type promoteReq struct {
+		NodeID    string `json:"node_id"`
+		Forwarded bool   `json:"forwarded,omitempty"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req promoteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.NodeID == "" {
+			http.Error(w, "node_id is required", http.StatusBadRequest)
+			return
+		}
+		fwd := req
+		fwd.Forwarded = true
+		membership := membershipChange(groupConsensus(cons, r), "/cluster/promote", fwd, req.Forwarded, func(c *ConsensusImpl) error {
+			return c.PromoteLearner(req.NodeID)
+		})
+		if membership == "committed" {
+			_ = store.SetClusterNodeLearner(req.NodeID, false)
+			peers.SetLearner(req.NodeID, false)
+		}
+		RecordAudit(r.Context(), AuditLevelInfo, "cluster", "promote", "learner promotion requested", map[string]any{
+			"node_id":    req.NodeID,
+			"membership": membership,
 		})
-		json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+		json.NewEncoder(w).Encode(map[string]string{"status": "promote", "membership": membership})
 	}
 }
 
@@ -0,0 +1,418 @@
+// raftctl talks to the cluster-signed /raft/* admin endpoints of one node.
+//
+//	raftctl [-node URL] [-secret S] [-group G] <command> [flags]
+//
+// The secret defaults to CLUSTER_HMAC_SECRET and the node to RAFTCTL_NODE or
+// http://localhost:8080. On nodes running shards, -group picks the Raft group
+// (meta, s0, s1...; the meta group by default).
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	ad "distributed-agenda"
+)
+
+type client struct {
+	node   string
+	secret string
+	group  string
+	http   *http.Client
+}
+
+type command struct {
+	usage string
+	run   func(c *client, args []string) error
+}
+
+var commands = map[string]command{
+	"health":   {"show /raft/health", runHealth},
+	"log":      {"page through the Raft log", runLog},
+	"poisoned": {"show the entry this node cannot apply", runPoisoned},
+	"retry":    {"apply the poisoned entry again on this node", runRetry},
+	"skip":     {"skip a poisoned entry on every node (leader only)", runSkip},
+	"repair":   {"propose a compensating entry (leader only)", runRepair},
+	"faults":   {"list or change the fault injection rules of this node", runFaults},
+	"digest":   {"show the state machine digest, or compare replicas (-check, leader only)", runDigest},
+	"changes":  {"print the applied changes after an index, one JSON per line (-follow waits for more)", runChanges},
+	"backup":   {"back up the node's database on the node, or download it (-o)", runBackup},
+}
+
+func main() {
+	node := flag.String("node", envOr("RAFTCTL_NODE", "http://localhost:8080"), "base URL of the node")
+	secret := flag.String("secret", os.Getenv("CLUSTER_HMAC_SECRET"), "cluster HMAC secret")
+	group := flag.String("group", "", "Raft group on nodes running shards (default: meta)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "raftctl: unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	base := strings.TrimRight(*node, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	c := &client{node: base, secret: strings.TrimSpace(*secret), group: strings.TrimSpace(*group), http: &http.Client{Timeout: 30 * time.Second}}
+	if err := cmd.run(c, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "raftctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: raftctl [-node URL] [-secret S] [-group G] <command> [flags]")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range sortedCommands() {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func sortedCommands() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// post sends a signed JSON request and decodes the JSON answer into out.
+func (c *client) post(path string, in, out any) error {
+	if c.secret == "" {
+		return errors.New("no cluster secret: set CLUSTER_HMAC_SECRET or -secret")
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.node+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(c.secret))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cluster-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return c.do(req, out)
+}
+
+func (c *client) get(path string, out any) error {
+	req, err := http.NewRequest(http.MethodGet, c.node+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+func (c *client) do(req *http.Request, out any) error {
+	if c.group != "" {
+		req.Header.Set(ad.RaftGroupHeader, c.group)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		// A writer gets the raw answer (e.g. a backup file).
+		if w, ok := out.(io.Writer); ok {
+			_, err := io.Copy(w, resp.Body)
+			return err
+		}
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runHealth(c *client, args []string) error {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	fs.Parse(args)
+	var health map[string]any
+	if err := c.get("/raft/health", &health); err != nil {
+		return err
+	}
+	return printJSON(health)
+}
+
+func runLog(c *client, args []string) error {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	var q ad.LogQuery
+	fs.Int64Var(&q.From, "from", 0, "first index")
+	fs.Int64Var(&q.To, "to", 0, "last index")
+	fs.StringVar(&q.Op, "op", "", "only entries with this op")
+	fs.StringVar(&q.AggregateID, "aggregate", "", "only entries with this aggregate_id")
+	fs.IntVar(&q.Limit, "limit", 50, "entries per page")
+	all := fs.Bool("all", false, "follow pages until the end")
+	asJSON := fs.Bool("json", false, "print raw JSON pages")
+	verbose := fs.Bool("v", false, "print decoded payloads")
+	fs.Parse(args)
+
+	for {
+		var page ad.LogPage
+		if err := c.post("/raft/log", q, &page); err != nil {
+			return err
+		}
+		if *asJSON {
+			if err := printJSON(page); err != nil {
+				return err
+			}
+		} else {
+			printLogPage(page, *verbose)
+		}
+		if !*all || page.Next == 0 {
+			if page.Next != 0 && !*asJSON {
+				fmt.Printf("more entries: -from %d\n", page.Next)
+			}
+			return nil
+		}
+		q.From = page.Next
+	}
+}
+
+func printLogPage(page ad.LogPage, verbose bool) {
+	fmt.Printf("node %s: log %d..%d, commit %d, applied %d, snapshot %d\n",
+		page.NodeID, page.FirstIndex, page.LastIndex, page.CommitIndex, page.LastApplied, page.SnapshotIndex)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tTERM\tSTATUS\tOP\tAGGREGATE_ID\tTIMESTAMP")
+	for _, e := range page.Entries {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n", e.Index, e.Term, e.Status, e.Op, e.AggregateID, e.Timestamp.Format(time.RFC3339))
+		if e.ApplyError != "" {
+			fmt.Fprintf(tw, "\t\t\tapply error: %s\t\t\n", e.ApplyError)
+		}
+		if e.DecodeError != "" {
+			fmt.Fprintf(tw, "\t\t\tdecode error: %s\t\t\n", e.DecodeError)
+		}
+		if verbose {
+			p := any(e.Payload)
+			if e.Payload == nil {
+				p = e.RawPayload
+			}
+			if b, err := json.Marshal(p); err == nil && string(b) != "null" {
+				fmt.Fprintf(tw, "\t\t\t%s\t\t\n", b)
+			}
+		}
+	}
+	tw.Flush()
+}
+
+func runPoisoned(c *client, args []string) error {
+	fs := flag.NewFlagSet("poisoned", flag.ExitOnError)
+	fs.Parse(args)
+	var pe ad.PoisonedEntry
+	if err := c.post("/raft/poisoned", struct{}{}, &pe); err != nil {
+		return err
+	}
+	if pe.Index == 0 {
+		fmt.Printf("node %s: no poisoned entry\n", pe.NodeID)
+		return nil
+	}
+	return printJSON(pe)
+}
+
+func runRetry(c *client, args []string) error {
+	fs := flag.NewFlagSet("retry", flag.ExitOnError)
+	fs.Parse(args)
+	var resp map[string]any
+	if err := c.post("/raft/poisoned/retry", struct{}{}, &resp); err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+func runSkip(c *client, args []string) error {
+	fs := flag.NewFlagSet("skip", flag.ExitOnError)
+	index := fs.Int64("index", 0, "index of the entry to skip")
+	reason := fs.String("reason", "", "why the entry is skipped (recorded in the audit log)")
+	fs.Parse(args)
+	if *index <= 0 || strings.TrimSpace(*reason) == "" {
+		return errors.New("skip needs -index and -reason")
+	}
+	var resp map[string]any
+	if err := c.post("/raft/skip", map[string]any{"index": *index, "reason": *reason}, &resp); err != nil {
+		return err
+	}
+	return printJSON(resp)
+}
+
+func runRepair(c *client, args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	op := fs.String("op", "", "state machine op, e.g. repair.user.ensure")
+	aggregate := fs.String("aggregate", "", "aggregate name (default repair)")
+	aggregateID := fs.String("aggregate-id", "", "aggregate id")
+	payload := fs.String("payload", "", "JSON payload for the op")
+	fs.Parse(args)
+	if *op == "" || !json.Valid([]byte(*payload)) {
+		return errors.New("repair needs -op and a JSON -payload")
+	}
+	req := map[string]any{
+		"op":           *op,
+		"aggregate":    *aggregate,
+		"aggregate_id": *aggregateID,
+		"payload":      json.RawMessage(*payload),
+	}
+	var res ad.ApplyResult
+	if err := c.post("/raft/repair", req, &res); err != nil {
+		return err
+	}
+	return printJSON(res)
+}
+
+func runDigest(c *client, args []string) error {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	var req ad.DigestRequest
+	fs.Int64Var(&req.Index, "index", 0, "applied index to take the digest at (default: current)")
+	tables := fs.String("tables", "", "comma-separated tables (default: all)")
+	fs.BoolVar(&req.Rows, "rows", false, "include per-row hashes")
+	check := fs.Bool("check", false, "compare every follower with the leader")
+	fs.Parse(args)
+	if *check {
+		var report ad.DivergenceReport
+		if err := c.post("/raft/digest/check", struct{}{}, &report); err != nil {
+			return err
+		}
+		return printJSON(report)
+	}
+	if *tables != "" {
+		req.Tables = strings.Split(*tables, ",")
+	}
+	var d ad.StateDigest
+	if err := c.post("/raft/digest", req, &d); err != nil {
+		return err
+	}
+	if req.Rows {
+		return printJSON(d)
+	}
+	fmt.Printf("node %s: digest at index %d\n", d.NodeID, d.Index)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tROWS\tHASH")
+	for _, t := range d.Tables {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", t.Table, t.Rows, t.Hash)
+	}
+	return tw.Flush()
+}
+
+func runFaults(c *client, args []string) error {
+	fs := flag.NewFlagSet("faults", flag.ExitOnError)
+	var rule ad.FaultRule
+	fs.StringVar(&rule.Peer, "peer", "", `peer id or address ("*": every peer)`)
+	fs.Float64Var(&rule.Drop, "drop", 0, "probability a request to the peer is dropped")
+	fs.Int64Var(&rule.DelayMS, "delay", 0, "delay added to each request, in ms")
+	fs.Int64Var(&rule.JitterMS, "jitter", 0, "random extra delay, in ms")
+	fs.Float64Var(&rule.Duplicate, "duplicate", 0, "probability a request is delivered twice")
+	fs.BoolVar(&rule.Partition, "partition", false, "cut the peer off in both directions")
+	reset := fs.Bool("reset", false, "remove every rule first")
+	fs.Parse(args)
+	req := ad.FaultRequest{Reset: *reset}
+	if rule.Peer != "" {
+		// A rule without faults removes the peer's rule.
+		req.Rules = []ad.FaultRule{rule}
+	}
+	var state ad.FaultState
+	if err := c.post("/raft/faults", req, &state); err != nil {
+		return err
+	}
+	return printJSON(state)
+}
+
+func runChanges(c *client, args []string) error {
+	fs := flag.NewFlagSet("changes", flag.ExitOnError)
+	var q ad.ChangeQuery
+	fs.Int64Var(&q.After, "after", 0, "print the changes after this index (a consumer checkpoint)")
+	fs.IntVar(&q.Limit, "limit", 0, "changes per request (default 100)")
+	follow := fs.Bool("follow", false, "keep waiting for new changes")
+	fs.Parse(args)
+	if *follow {
+		q.WaitMS = 25000
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		var page ad.ChangePage
+		if err := c.post("/raft/changes", q, &page); err != nil {
+			return err
+		}
+		for _, ev := range page.Changes {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+		q.After = page.Next
+		if !*follow && len(page.Changes) == 0 {
+			return nil
+		}
+	}
+}
+
+func runBackup(c *client, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", "", "download the backup to this file instead of keeping it on the node")
+	fs.Parse(args)
+	if *out == "" {
+		var info ad.BackupInfo
+		if err := c.post("/raft/backup", ad.BackupRequest{}, &info); err != nil {
+			return err
+		}
+		return printJSON(info)
+	}
+	tmp := *out + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	c.http.Timeout = 0 // large databases take a while
+	err = c.post("/raft/backup", ad.BackupRequest{Download: true}, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+	info, err := ad.ReadBackupInfo(*out)
+	if err != nil {
+		return err
+	}
+	return printJSON(info)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -16,6 +17,28 @@ func main() {
 	if dsn == "" {
 		dsn = "file:agenda.db?cache=shared&_fk=1"
 	}
+	// RAFT_CONFIG_FILE (optional JSON) and RAFT_* variables; see docs/networking.md
+	raftCfg, err := ad.LoadConsensusConfig(strings.TrimSpace(os.Getenv("RAFT_CONFIG_FILE")))
+	if err != nil {
+		log.Fatalf("consensus config: %v", err)
+	}
+	// A new node can start from a backup of another node instead of replaying
+	// the whole log; an existing database is never replaced. Backups do not
+	// include the logs of the shards.
+	if seed := strings.TrimSpace(os.Getenv("RAFT_SEED_FILE")); seed != "" {
+		if raftCfg.Shards > 0 {
+			log.Fatal("RAFT_SEED_FILE cannot be used with RAFT_SHARDS")
+		}
+		info, err := ad.SeedFromBackup(context.Background(), seed, dsn)
+		switch {
+		case errors.Is(err, ad.ErrDatabaseExists):
+			log.Printf("RAFT_SEED_FILE ignored: %v", err)
+		case err != nil:
+			log.Fatalf("seed from %s: %v", seed, err)
+		default:
+			log.Printf("database seeded from %s (node %s, index %d, term %d)", seed, info.NodeID, info.Index, info.Term)
+		}
+	}
 	storage, err := ad.NewStorage(dsn)
 	if err != nil {
 		log.Fatalf("storage init: %v", err)
@@ -52,10 +75,35 @@ func main() {
 		peerIDs = strings.Split(peersEnv, ",")
 	}
 	ps := ad.NewEnvPeerStore(nodeID, peerIDs)
-	discovery := ad.NewDiscoveryManager(storage, ps, nodeID, advertiseAddr)
+	discovery := ad.NewDiscoveryManager(storage, ps, nodeID, advertiseAddr, raftCfg.Learner)
+	// RAFT_SHARDS splits the agenda into several Raft groups; the meta group
+	// answers the Raft endpoints without a group header.
+	var (
+		cons       ad.Consensus
+		meta       *ad.ConsensusImpl
+		multi      *ad.MultiRaft
+		background ad.Consensus
+	)
+	if raftCfg.Shards > 0 {
+		multi, err = ad.NewMultiRaft(nodeID, storage, dsn, ps, raftCfg)
+		if err != nil {
+			log.Fatalf("consensus: %v", err)
+		}
+		cons, meta, background = multi, multi.Meta(), multi.Background()
+	} else {
+		meta = ad.NewConsensus(nodeID, storage, ps, raftCfg)
+		meta.SetStateMachine(ad.NewSQLiteStateMachine(storage))
+		cons, background = meta, meta
+	}
+	// The address this node records for itself in the Raft configuration.
+	if multi != nil {
+		multi.SetAdvertiseAddr(advertiseAddr)
+	} else {
+		meta.SetAdvertiseAddr(advertiseAddr)
+	}
+	// Discovery and health polling go through the fault injector.
+	discovery.SetConsensus(cons)
 	discovery.Start()
-	cons := ad.NewConsensus(nodeID, storage, ps)
-	cons.SetApplier(ad.NewRaftApplier(storage))
 	if err := cons.Start(); err != nil {
 		log.Fatalf("consensus: %v", err)
 	}
@@ -64,9 +112,9 @@ func main() {
 		"peers":   peerIDs,
 		"addr":    advertiseAddr,
 	})
-	// best-effort leader discovery via health polling
+	// peer liveness polling (plus term-stamped leader hints)
 	stopHB := make(chan struct{})
-	ad.StartHeartbeats(ps, storage, stopHB)
+	ad.StartHeartbeats(ps, storage, cons, stopHB)
 
 	// Pass storage as UserRepository, GroupRepository, and AppointmentRepository and wire consensus into API
 	api := ad.NewAPI(auth, groups, apps, agenda, notes, storage, storage, storage, storage, cons)
@@ -74,18 +122,23 @@ func main() {
 	// inject consensus into appointment service for write proposals
 	apps.SetConsensus(cons)
 
+	// Cluster requests from peers partitioned off by fault injection
+	r.Use(ad.FaultMiddleware(meta))
 	// Leader redirect middleware for writes
 	r.Use(ad.LeaderWriteMiddleware(cons, ps.ResolveAddr))
 
 	// Register Raft HTTP endpoints
-	ad.RegisterRaftHTTP(r, cons, storage)
-	ad.RegisterClusterHTTP(r, storage, ps)
+	ad.RegisterRaftHTTP(r, meta, storage)
+	if multi != nil {
+		ad.RegisterShardHTTP(r, multi)
+	}
+	ad.RegisterClusterHTTP(r, storage, ps, cons)
 	// Start background reconcilers (leader-only behavior inside each service).
-	ad.StartUserReconciler(storage, cons, ps)
-	ad.StartAppointmentReconciler(storage, cons, ps)
-	ad.StartGroupReconciler(storage, cons, ps)
-	ad.StartInvitationReconciler(storage, cons, ps)
-	ad.StartNotificationReconciler(storage, cons, ps)
+	ad.StartUserReconciler(storage, background, ps)
+	ad.StartAppointmentReconciler(storage, background, ps)
+	ad.StartGroupReconciler(storage, background, ps)
+	ad.StartInvitationReconciler(storage, background, ps)
+	ad.StartNotificationReconciler(storage, background, ps)
 
 	// Serve static UI under /ui/
 	r.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", http.FileServer(http.Dir("web"))))
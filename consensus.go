@@ -31,6 +31,10 @@ type ConsensusImpl struct {
 	peers   PeerStore
 	nodeID  string
 	logger  *slog.Logger
+	cfg     ConsensusConfig
+	// group names the Raft group of a shard ("" for the meta or only group);
+	// it is sent with every request to peers (raft_shards.go).
+	group string
 
 	// persistent/volatile
 	state RaftState
@@ -42,38 +46,136 @@ type ConsensusImpl struct {
 	heartbeatFailures  int           // consecutive heartbeat failures (leader demotion)
 	failedElections    int           // consecutive failed elections (for backoff)
 
-	// apply callback to mutate the state machine (SQLite)
-	applier func(LogEntry) error
-
-	// networking
+	// replicated state machine (SQLite in production, see raft_statemachine.go)
+	sm StateMachine
+	// applyMu serializes applyCommitted with snapshot creation/installation so a
+	// snapshot always reflects exactly state.LastApplied.
+	applyMu sync.Mutex
+	// applyHold, when set, stops applyCommitted at that index while a state
+	// digest is taken (raft_digest.go); digestMu allows one digest at a time.
+	applyHold int64
+	digestMu  sync.Mutex
+	// applyStarted is set once this process applied an entry; guarded by
+	// applyMu (see beginEntry).
+	applyStarted bool
+	// changesReady is closed when a change is recorded, waking the change
+	// data capture consumers waiting for one (raft_changes.go).
+	changesMu    sync.Mutex
+	changesReady chan struct{}
+
+	// log compaction: snapshot once this many entries were applied since the last
+	// snapshot, keeping snapshotTrailing entries below it for slightly lagging followers.
+	snapshotThreshold int64
+	snapshotTrailing  int64
+
+	// clock of the consensus loop timers (clock.go)
+	clock Clock
+
+	// networking; every request goes through faults (raft_faults.go)
 	httpClient *http.Client
+	faults     *FaultInjector
 	hmacSecret string
 
-	// Per-peer replication state (Raft-like): maintained on the leader
-	nextIdx  map[string]int64 // For each follower, index of the next log entry to send
-	matchIdx map[string]int64 // For each follower, index of highest log entry known to be replicated
+	// Per-peer replication state (Raft-like): maintained on the leader by the
+	// replicator goroutine of each follower (raft_replication.go)
+	nextIdx     map[string]int64 // For each follower, index of the next log entry to send
+	matchIdx    map[string]int64 // For each follower, index of highest log entry known to be replicated
+	replicators map[string]*replicator
+	// maxBatch caps the entries per AppendEntries and pipelineDepth the
+	// number of batches in flight per follower.
+	maxBatch      int64
+	pipelineDepth int64
+	// applyCh wakes the main loop to apply entries as soon as the commit index
+	// advances, instead of waiting for the next heartbeat tick.
+	applyCh chan struct{}
+	// proposals queues Propose calls for the proposer goroutine (group commit).
+	proposals chan *proposal
+	// pendingProposals maps log indexes appended by this leader to the
+	// proposal waiting for their apply result.
+	pendingProposals map[int64]*proposal
 
 	applyErr      error
 	applyErrIndex int64
+
+	// bounded-staleness reads (raft_staleness.go): when a follower last heard
+	// from the leader, the leader's commit index then, and the latest such
+	// contact whose commit index this node has applied.
+	contactAt     time.Time
+	contactCommit int64
+	freshAt       time.Time
+
+	// committed cluster configuration (nil until bootstrapped); configMu
+	// serializes membership changes on the leader. advertiseAddr is the
+	// address this node records for itself (SetAdvertiseAddr).
+	config        *ClusterConfig
+	configMu      sync.Mutex
+	advertiseAddr string
+
+	// transferTarget is set while leadership is being handed to that node;
+	// proposals are rejected meanwhile.
+	transferTarget string
+
+	// learner is set (RAFT_LEARNER) on read replicas: they receive the log
+	// but never vote nor start elections until promoted.
+	learner bool
+	// learnerCatchUpLag is how far behind the commit index a learner may be
+	// when promoted to voter.
+	learnerCatchUpLag int64
+
+	// strictQuorum (RAFT_STRICT_QUORUM) computes majorities over every voter
+	// (the initial voters before a configuration) instead of the reachable
+	// ones, and enables CheckQuorum (raft_quorum.go).
+	strictQuorum bool
+	lastContact  map[string]time.Time // last answer of each peer to this leader
+	leaderTerm   int64                // term leaderSince refers to
+	leaderSince  time.Time
+
+	// peerSeen is when each peer was last heard from in any role (see
+	// NotePeerSeen); activePeers drops peers older than PeerStaleness. It has
+	// its own lock because activePeers runs under mu.
+	seenMu   sync.Mutex
+	peerSeen map[string]time.Time
 }
 
-func NewConsensus(nodeID string, storage *Storage, peers PeerStore) *ConsensusImpl {
-	return &ConsensusImpl{
+// NewConsensus creates the Raft node nodeID. cfg must be valid (see
+// ConsensusConfig.Validate).
+func NewConsensus(nodeID string, storage *Storage, peers PeerStore, cfg ConsensusConfig) *ConsensusImpl {
+	c := &ConsensusImpl{
 		storage:            storage,
 		peers:              peers,
 		nodeID:             nodeID,
+		cfg:                cfg,
+		clock:              SystemClock{},
 		role:               roleFollower,
-		httpClient:         &http.Client{Timeout: 5 * time.Second},
 		hmacSecret:         os.Getenv("CLUSTER_HMAC_SECRET"),
 		logger:             Logger(),
 		resetElectionTimer: make(chan struct{}, 1),
 		nextIdx:            make(map[string]int64),
 		matchIdx:           make(map[string]int64),
-	}
+		replicators:        make(map[string]*replicator),
+		maxBatch:           cfg.MaxBatch,
+		pipelineDepth:      cfg.PipelineDepth,
+		applyCh:            make(chan struct{}, 1),
+		proposals:          make(chan *proposal, 1024),
+		pendingProposals:   make(map[int64]*proposal),
+		learner:            cfg.Learner,
+		learnerCatchUpLag:  cfg.LearnerCatchUpLag,
+		snapshotThreshold:  cfg.SnapshotThreshold,
+		snapshotTrailing:   cfg.SnapshotTrailing,
+		strictQuorum:       cfg.StrictQuorum,
+		lastContact:        make(map[string]time.Time),
+		peerSeen:           make(map[string]time.Time),
+	}
+	c.faults = newFaultInjector(nodeID, http.DefaultTransport, c.peerAddr)
+	c.httpClient = &http.Client{Timeout: cfg.HTTPTimeout, Transport: c.faults}
+	return c
 }
 
 func (c *ConsensusImpl) log(level slog.Level, msg string, attrs ...any) {
 	attrs = append(attrs, "node_id", c.nodeID)
+	if c.group != "" {
+		attrs = append(attrs, "raft_group", c.group)
+	}
 	switch level {
 	case slog.LevelDebug:
 		c.logger.Debug(msg, attrs...)
@@ -93,6 +195,9 @@ func (c *ConsensusImpl) audit(action, message string, fields map[string]any) {
 	if _, exists := fields["node_id"]; !exists {
 		fields["node_id"] = c.nodeID
 	}
+	if c.group != "" {
+		fields["raft_group"] = c.group
+	}
 	RecordAudit(context.Background(), AuditLevelInfo, "consensus", action, message, fields)
 }
 
@@ -104,8 +209,31 @@ func (c *ConsensusImpl) IsLeader() bool {
 	return c.role == roleLeader
 }
 
-func (c *ConsensusImpl) LeaderID() string {
-	return c.peers.GetLeader()
+// RaftStatus is a snapshot of a node's Raft progress.
+type RaftStatus struct {
+	NodeID      string `json:"node_id"`
+	IsLeader    bool   `json:"is_leader"`
+	Term        int64  `json:"term"`
+	CommitIndex int64  `json:"commit_index"`
+	LastApplied int64  `json:"last_applied"`
+	ApplyError  string `json:"apply_error,omitempty"`
+}
+
+// Status returns this node's term and commit/apply progress.
+func (c *ConsensusImpl) Status() RaftStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	st := RaftStatus{
+		NodeID:      c.nodeID,
+		IsLeader:    c.role == roleLeader,
+		Term:        c.state.CurrentTerm,
+		CommitIndex: c.state.CommitIndex,
+		LastApplied: c.state.LastApplied,
+	}
+	if c.applyErr != nil {
+		st.ApplyError = c.applyErr.Error()
+	}
+	return st
 }
 
 func (c *ConsensusImpl) Start() error {
@@ -117,10 +245,23 @@ func (c *ConsensusImpl) Start() error {
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	c.cancel = cancel
+	sm := c.sm
+	lastApplied := c.state.LastApplied
 	c.mu.Unlock()
+	if sm == nil {
+		c.log(slog.LevelWarn, "state_machine_missing")
+	} else if smIdx := sm.LastAppliedIndex(); smIdx < lastApplied {
+		// Entries recorded as applied are not reflected in the state machine
+		// (e.g. a fresh in-memory one over a persisted log).
+		c.log(slog.LevelWarn, "state_machine_behind_log", "state_machine_index", smIdx, "last_applied", lastApplied)
+	}
 
 	// main loop: heartbeats/election (placeholder) and apply committed entries
 	go c.loop(ctx)
+	go c.runProposer(ctx)
+	if c.cfg.DigestInterval > 0 {
+		go c.runDigestChecker(ctx)
+	}
 	c.log(slog.LevelInfo, "consensus_started", "term", c.state.CurrentTerm)
 	c.audit("start", "consensus loop started", map[string]any{"term": c.state.CurrentTerm})
 	return nil
@@ -132,29 +273,33 @@ func (c *ConsensusImpl) Stop() error {
 		c.cancel()
 	}
 	c.mu.Unlock()
+	c.stopReplicators()
 	c.log(slog.LevelInfo, "consensus_stopped")
 	c.audit("stop", "consensus loop stopped", nil)
 	return nil
 }
 
 func (c *ConsensusImpl) loop(ctx context.Context) {
-	hb := time.NewTicker(1 * time.Second) // Increased heartbeat interval for stability
-	elect := time.NewTicker(c.electionTimeout())
+	hb := c.clock.NewTicker(c.cfg.HeartbeatInterval)
+	elect := c.clock.NewTicker(c.electionTimeout())
 	defer hb.Stop()
 	defer elect.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-hb.C:
+		case <-hb.C():
 			// leader heartbeats and apply committed entries
 			c.mu.RLock()
 			isLeader := c.role == roleLeader
 			term := c.state.CurrentTerm
 			commit := c.state.CommitIndex
 			c.mu.RUnlock()
+			if isLeader && !c.checkQuorum() {
+				isLeader = false
+			}
 			if isLeader {
-				err := c.broadcastAppendEntries(term, nil, commit)
+				err := c.broadcastAppendEntries(term, commit)
 				c.mu.Lock()
 				if err != nil {
 					c.heartbeatFailures++
@@ -162,7 +307,7 @@ func (c *ConsensusImpl) loop(ctx context.Context) {
 					if c.heartbeatFailures >= 3 {
 						c.log(slog.LevelWarn, "leader_demoted_no_majority", "failures", c.heartbeatFailures)
 						c.role = roleFollower
-						c.peers.SetLeader("")
+						c.peers.SetLeader("", c.state.CurrentTerm)
 						c.heartbeatFailures = 0
 						c.audit("demotion", "leader demoted due to repeated heartbeat failures", map[string]any{"failures": c.heartbeatFailures})
 					}
@@ -170,9 +315,17 @@ func (c *ConsensusImpl) loop(ctx context.Context) {
 					c.heartbeatFailures = 0 // Reset on success
 				}
 				c.mu.Unlock()
+				// Starts replicators after an election and retries idle ones.
+				c.ensureReplicators()
+				c.kickReplicators()
+				go c.maintainConfig()
 			}
 			_ = c.applyCommitted()
-		case <-elect.C:
+			c.maybeSnapshot()
+		case <-c.applyCh:
+			_ = c.applyCommitted()
+			c.maybeSnapshot()
+		case <-elect.C():
 			// start election if not leader
 			c.mu.RLock()
 			curRole := c.role
@@ -189,8 +342,9 @@ func (c *ConsensusImpl) loop(ctx context.Context) {
 }
 
 func (c *ConsensusImpl) electionTimeout() time.Duration {
-	// Base 5s + jitter (0-2s) + small backoff based on failed elections.
-	// This prevents premature or overly aggressive elections in noisy clusters.
+	// Base + jitter + small backoff based on failed elections (5s, 0-2s and 2s
+	// per failure by default). This prevents premature or overly aggressive
+	// elections in noisy clusters.
 	c.mu.RLock()
 	fe := c.failedElections
 	c.mu.RUnlock()
@@ -200,60 +354,64 @@ func (c *ConsensusImpl) electionTimeout() time.Duration {
 	if fe > 3 {
 		fe = 3 // cap backoff
 	}
-	base := 5 * time.Second
-	backoff := time.Duration(fe) * 2 * time.Second
-	jitter := time.Duration(time.Now().UnixNano() % 2_000_000_000) // up to 2s
-	return base + backoff + jitter
+	backoff := time.Duration(fe) * c.cfg.ElectionBackoff
+	var jitter time.Duration
+	if c.cfg.ElectionJitter > 0 {
+		jitter = time.Duration(c.clock.Now().UnixNano() % int64(c.cfg.ElectionJitter))
+	}
+	return c.cfg.ElectionTimeout + backoff + jitter
+}
+
+func (c *ConsensusImpl) Propose(entry LogEntry) (ApplyResult, error) {
+	return c.propose(entry, false)
 }
 
-func (c *ConsensusImpl) Propose(entry LogEntry) error {
+// propose replicates entry. Operator entries (admin) are accepted while an
+// apply error blocks the state machine, since they are what unblocks it
+// (raft_poison.go).
+func (c *ConsensusImpl) propose(entry LogEntry, admin bool) (ApplyResult, error) {
 	c.mu.RLock()
 	if c.role != roleLeader {
 		c.mu.RUnlock()
 		c.log(slog.LevelWarn, "propose_rejected_not_leader", "leader", c.peers.GetLeader())
-		return errors.New("not leader")
+		return ApplyResult{}, errors.New("not leader")
 	}
-	if c.applyErr != nil {
+	if c.applyErr != nil && !admin {
 		err := c.applyErr
 		idx := c.applyErrIndex
 		c.mu.RUnlock()
 		c.log(slog.LevelError, "propose_rejected_apply_error", "apply_err", err.Error(), "apply_err_index", idx)
-		return errors.New("node has unapplied committed entries (apply error)")
+		return ApplyResult{}, errors.New("node has unapplied committed entries (apply error)")
+	}
+	if c.transferTarget != "" {
+		target := c.transferTarget
+		c.mu.RUnlock()
+		c.log(slog.LevelWarn, "propose_rejected_leadership_transfer", "target", target)
+		return ApplyResult{}, ErrLeadershipTransfer
 	}
-	term := c.state.CurrentTerm
 	c.mu.RUnlock()
 
-	// persist to raft_log with next index
-	nextIdx, err := c.nextIndex()
-	if err != nil {
-		return err
-	}
-	entry.Term = term
-	entry.Index = nextIdx
-	// if err := c.appendLog(entry); err != nil {
-	// 	c.log(slog.LevelError, "propose_append_failed", "err", err)
-	// 	return err
-	// }
-	// replicate to followers and wait for majority to commit
-	if err := c.replicateAndCommit(entry); err != nil {
-		c.log(slog.LevelError, "propose_replicate_failed", "err", err)
-		return err
+	if entry.Version == 0 {
+		entry.Version = currentPayloadVersion(entry.Op)
 	}
-
-	// Phase 3: wait until this entry is actually applied on the leader state machine
-	// (LastApplied >= entry.Index). This strengthens the guarantee that any
-	// subsequent read on the leader will observe the effects of this write.
-	if err := c.waitForApplied(entry.Index, 5*time.Second); err != nil {
-		c.log(slog.LevelError, "propose_wait_applied_failed", "index", entry.Index, "err", err)
-		return err
+	// queue for the proposer goroutine, which appends concurrent proposals as
+	// one batch and waits for a majority to commit it (raft_proposals.go).
+	// The result arrives once the entry is applied on the leader state
+	// machine, so any subsequent read on the leader observes this write.
+	idx, res, err := c.submitProposal(entry, admin)
+	if err != nil {
+		c.log(slog.LevelError, "propose_failed", "index", idx, "op", entry.Op, "err", err)
+		return res, err
 	}
+	entry.Index = idx
 
 	c.log(slog.LevelInfo, "propose_committed_and_applied", "index", entry.Index, "op", entry.Op)
 	c.audit("propose", "log entry committed and applied", map[string]any{"index": entry.Index, "op": entry.Op})
-	return nil
+	return res, nil
 }
 
 func (c *ConsensusImpl) HandleAppendEntries(req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	c.NotePeerSeen(req.LeaderID)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -266,10 +424,11 @@ func (c *ConsensusImpl) HandleAppendEntries(req AppendEntriesRequest) (AppendEnt
 	// If we receive AppendEntries from a higher term, become follower
 	wasLeader := c.role == roleLeader
 	if req.Term > c.state.CurrentTerm {
-		c.state.CurrentTerm = req.Term
-		_ = c.persistMeta("currentTerm", c.state.CurrentTerm)
+		if err := c.setTermLocked(req.Term, ""); err != nil {
+			return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: c.state.LastApplied}, err
+		}
 		c.role = roleFollower
-		c.peers.SetLeader(req.LeaderID)
+		c.peers.SetLeader(req.LeaderID, req.Term)
 		c.heartbeatFailures = 0 // Reset failure counter
 		if wasLeader {
 			c.log(slog.LevelWarn, "leader_demoted_higher_term", "leader_id", req.LeaderID, "term", req.Term)
@@ -281,13 +440,13 @@ func (c *ConsensusImpl) HandleAppendEntries(req AppendEntriesRequest) (AppendEnt
 		// Same term: if we're leader and receive from another leader, demote ourselves (split-brain)
 		if wasLeader && req.LeaderID != c.nodeID {
 			c.role = roleFollower
-			c.peers.SetLeader(req.LeaderID)
+			c.peers.SetLeader(req.LeaderID, req.Term)
 			c.heartbeatFailures = 0
 			c.log(slog.LevelWarn, "leader_demoted_same_term", "leader_id", req.LeaderID, "term", req.Term)
 			c.audit("demotion", "leader demoted by same-term leader", map[string]any{"new_leader": req.LeaderID, "term": req.Term})
 		} else if !wasLeader {
 			// We're a follower, accept this leader
-			c.peers.SetLeader(req.LeaderID)
+			c.peers.SetLeader(req.LeaderID, req.Term)
 		}
 	}
 
@@ -301,59 +460,95 @@ func (c *ConsensusImpl) HandleAppendEntries(req AppendEntriesRequest) (AppendEnt
 		}
 	}
 
-	if req.PrevLogIndex > 0 {
+	// Entries up to the snapshot are committed and already part of our state
+	// machine, so they cannot conflict with the leader's log.
+	snapIdx, _, err := c.snapshotMeta()
+	if err != nil {
+		return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: c.state.LastApplied}, err
+	}
+	if req.PrevLogIndex > snapIdx {
 		term, err := c.logTermAt(req.PrevLogIndex)
 		if err != nil {
 			c.log(slog.LevelWarn, "append_entries_prev_lookup_failed", "err", err, "prev_index", req.PrevLogIndex)
 			return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: c.state.LastApplied}, err
 		}
 		if term != req.PrevLogTerm {
-			_ = c.truncateLogFrom(req.PrevLogIndex)
+			repair := logRepair{truncateFrom: req.PrevLogIndex, commitIndex: -1, lastApplied: -1}
 			if c.state.LastApplied >= req.PrevLogIndex {
-				c.state.LastApplied = req.PrevLogIndex - 1
-				if c.state.LastApplied < 0 {
-					c.state.LastApplied = 0
-				}
-				_ = c.persistMeta("lastApplied", c.state.LastApplied)
+				repair.lastApplied = max(req.PrevLogIndex-1, 0)
 			}
 			if c.state.CommitIndex >= req.PrevLogIndex {
-				c.state.CommitIndex = req.PrevLogIndex - 1
-				if c.state.CommitIndex < 0 {
-					c.state.CommitIndex = 0
-				}
-				_ = c.persistMeta("commitIndex", c.state.CommitIndex)
+				repair.commitIndex = max(req.PrevLogIndex-1, 0)
+			}
+			if err := c.repairLogLocked(repair); err != nil {
+				return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: c.state.LastApplied}, err
 			}
 			c.log(slog.LevelDebug, "append_entries_prev_mismatch", "expected_term", req.PrevLogTerm, "found_term", term)
 			return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: c.state.LastApplied}, nil
 		}
 	}
 	var lastIdx int64 = req.PrevLogIndex
-	for _, e := range req.Entries {
-		if err := c.truncateLogFrom(e.Index); err != nil {
-			c.log(slog.LevelWarn, "append_entries_truncate_failed", "err", err, "index", e.Index)
+	repair := logRepair{commitIndex: -1, lastApplied: -1}
+	for i, e := range req.Entries {
+		if e.Index <= snapIdx {
+			lastIdx = e.Index
+			continue
+		}
+		// An entry we already hold with the same term is identical (Log
+		// Matching), so keep it: a retransmitted batch must not truncate
+		// entries appended by a later pipelined one.
+		t, err := c.logTermAt(e.Index)
+		if err != nil {
+			c.log(slog.LevelWarn, "append_entries_term_lookup_failed", "err", err, "index", e.Index)
 			return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: lastIdx}, err
 		}
-		if err := c.appendLog(e); err != nil {
-			c.log(slog.LevelWarn, "append_entries_append_failed", "err", err)
-			return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: lastIdx}, nil
+		if t != 0 && t == e.Term {
+			lastIdx = e.Index
+			continue
+		}
+		// Everything from the first conflicting or missing entry on is
+		// replaced by the rest of the batch.
+		repair.truncateFrom = e.Index
+		repair.entries = req.Entries[i:]
+		lastIdx = req.Entries[len(req.Entries)-1].Index
+		break
+	}
+	// Only entries known to match the leader can be marked committed; the rest
+	// of the commit index arrives with the batches still in flight.
+	if commit := min(req.LeaderCommit, lastIdx); commit > c.state.CommitIndex {
+		repair.commitIndex = commit
+	}
+	if repair.truncateFrom > 0 || repair.commitIndex >= 0 {
+		if err := c.repairLogLocked(repair); err != nil {
+			return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: false, MatchIndex: req.PrevLogIndex}, err
+		}
+		if repair.commitIndex >= 0 {
+			// apply now rather than on the next heartbeat tick
+			c.signalApply()
 		}
-		lastIdx = e.Index
 	}
-	if req.LeaderCommit > c.state.CommitIndex {
-		c.state.CommitIndex = req.LeaderCommit
-		_ = c.persistMeta("commitIndex", c.state.CommitIndex)
+	if req.LeaderID != c.nodeID {
+		c.noteLeaderContactLocked(req.LeaderCommit)
 	}
 	return AppendEntriesResponse{Term: c.state.CurrentTerm, Success: true, MatchIndex: lastIdx}, nil
 }
 
-func (c *ConsensusImpl) HandleRequestVote(req RequestVoteRequest) (RequestVoteResponse, error) {
+func (c *ConsensusImpl) HandleRequestVote(req RequestVoteRequest) (resp RequestVoteResponse, err error) {
+	c.NotePeerSeen(req.CandidateID)
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	defer func() { resp.LeaderID = c.leaderHintLocked(resp.Term) }()
 	if req.Term < c.state.CurrentTerm {
 		c.log(slog.LevelDebug, "request_vote_old_term", "candidate", req.CandidateID, "term", req.Term, "prevote", req.PreVote)
 		return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: false}, nil
 	}
 
+	// Learners never vote.
+	if c.isLearnerLocked() {
+		c.log(slog.LevelDebug, "request_vote_denied_learner", "candidate", req.CandidateID, "prevote", req.PreVote)
+		return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: false}, nil
+	}
+
 	// Pre-vote requests do not mutate currentTerm or votedFor; they are only a probe.
 	if req.PreVote {
 		lastIdx, lastTerm, err := c.lastIndexTerm()
@@ -369,12 +564,18 @@ func (c *ConsensusImpl) HandleRequestVote(req RequestVoteRequest) (RequestVoteRe
 		return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: true}, nil
 	}
 
-	// Regular RequestVote: may advance term and record votedFor.
+	// Regular RequestVote: may advance term and record votedFor. A higher term
+	// always turns us into a follower, otherwise a leader that handed off
+	// leadership would keep heartbeating in the new term.
 	if req.Term > c.state.CurrentTerm {
-		c.state.CurrentTerm = req.Term
-		_ = c.persistMeta("currentTerm", c.state.CurrentTerm)
-		c.state.VotedFor = ""
-		_ = c.persistMetaString("votedFor", "")
+		if err := c.setTermLocked(req.Term, ""); err != nil {
+			return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: false}, err
+		}
+		if c.role != roleFollower {
+			c.log(slog.LevelInfo, "stepped_down_higher_term_vote", "candidate", req.CandidateID, "term", req.Term)
+			c.role = roleFollower
+			c.peers.SetLeader("", c.state.CurrentTerm)
+		}
 	}
 	if c.state.VotedFor != "" && c.state.VotedFor != req.CandidateID {
 		c.log(slog.LevelDebug, "request_vote_already_voted", "candidate", req.CandidateID, "voted_for", c.state.VotedFor)
@@ -388,8 +589,9 @@ func (c *ConsensusImpl) HandleRequestVote(req RequestVoteRequest) (RequestVoteRe
 		c.log(slog.LevelDebug, "request_vote_log_outdated", "candidate", req.CandidateID)
 		return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: false}, nil
 	}
-	c.state.VotedFor = req.CandidateID
-	_ = c.persistMetaString("votedFor", req.CandidateID)
+	if err := c.setTermLocked(c.state.CurrentTerm, req.CandidateID); err != nil {
+		return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: false}, err
+	}
 	c.log(slog.LevelInfo, "request_vote_granted", "candidate", req.CandidateID, "term", req.Term)
 	return RequestVoteResponse{Term: c.state.CurrentTerm, VoteGranted: true}, nil
 }
@@ -418,6 +620,18 @@ func (c *ConsensusImpl) loadState() error {
 			st.CommitIndex = parseInt64Default(v, 0)
 		case "lastApplied":
 			st.LastApplied = parseInt64Default(v, 0)
+		case "snapshotIndex":
+			st.SnapshotIndex = parseInt64Default(v, 0)
+		case "snapshotTerm":
+			st.SnapshotTerm = parseInt64Default(v, 0)
+		case "config":
+			if v != "" {
+				var cfg ClusterConfig
+				if err := json.Unmarshal([]byte(v), &cfg); err != nil {
+					return err
+				}
+				c.config = &cfg
+			}
 		}
 	}
 	c.state = st
@@ -425,34 +639,81 @@ func (c *ConsensusImpl) loadState() error {
 }
 
 // activePeers returns the list of peer node IDs that are considered "active"
-// based on actual connectivity, not just LastSeen timestamps. This is critical
-// for network partitions: we only count peers that we can actually communicate with.
+// based on actual connectivity. This is critical for network partitions: we
+// only count peers that we can actually communicate with.
 //
 // The function uses PeerStore as the source of truth, which is updated by
 // DiscoveryManager based on successful communication. During partitions,
 // unreachable peers will eventually be removed from PeerStore when their
-// LastSeen expires (maxPeerAge = 2 minutes).
+// LastSeen expires (maxPeerAge = 2 minutes). Before that, a peer last heard
+// from (see NotePeerSeen) more than maxStale ago on the node clock no longer
+// counts; a peer never heard from yet still does.
 func (c *ConsensusImpl) activePeers(maxStale time.Duration) []string {
-	// Use only PeerStore - it represents nodes that DiscoveryManager considers
-	// contactable. During partitions, unreachable nodes will have stale LastSeen
-	// and will be removed from PeerStore by updatePeersFromStorage() after maxPeerAge.
 	knownPeers := c.peers.ListPeers()
+	now := c.clock.Now()
 	out := make([]string, 0, len(knownPeers))
+	var stale []string
+	c.seenMu.Lock()
 	for _, id := range knownPeers {
 		if id == "" || id == c.nodeID || id == "node-unknown" {
 			continue
 		}
+		if at, ok := c.peerSeen[id]; ok && maxStale > 0 && now.Sub(at) > maxStale {
+			stale = append(stale, id)
+			continue
+		}
 		out = append(out, id)
 	}
+	c.seenMu.Unlock()
 
 	// Log for debugging partition scenarios
-	if len(out) > 0 {
-		c.log(slog.LevelDebug, "active_peers_computed", "count", len(out), "peers", out)
+	if len(out) > 0 || len(stale) > 0 {
+		c.log(slog.LevelDebug, "active_peers_computed", "count", len(out), "peers", out, "stale", stale)
 	}
 
 	return out
 }
 
+// NotePeerSeen records that peer id answered or contacted this node. Raft
+// traffic records it on its own; the health poller reports the rest.
+func (c *ConsensusImpl) NotePeerSeen(id string) {
+	if id == "" || id == c.nodeID {
+		return
+	}
+	c.seenMu.Lock()
+	c.peerSeen[id] = c.clock.Now()
+	c.seenMu.Unlock()
+}
+
+// votingPeers returns the active peers that vote, i.e. without learners. It is
+// the legacy (no committed configuration) counterpart of ClusterConfig.Voters.
+func (c *ConsensusImpl) votingPeers() []string {
+	peers := c.activePeers(c.cfg.PeerStaleness)
+	out := peers[:0]
+	for _, id := range peers {
+		if !c.peers.IsLearner(id) {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// isLearner reports whether this node is a non-voting learner.
+func (c *ConsensusImpl) isLearner() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isLearnerLocked()
+}
+
+// isLearnerLocked is isLearner for callers holding c.mu. The committed
+// configuration wins over RAFT_LEARNER once it exists.
+func (c *ConsensusImpl) isLearnerLocked() bool {
+	if c.config != nil {
+		return c.config.IsLearner(c.nodeID)
+	}
+	return c.learner || c.peers.IsLearner(c.nodeID)
+}
+
 func (c *ConsensusImpl) persistMeta(key string, val int64) error {
 	_, err := c.storage.db.Exec(`INSERT INTO raft_meta(key,value) VALUES(?,?)
         ON CONFLICT(key) DO UPDATE SET value=excluded.value`, key, intToString(val))
@@ -466,29 +727,11 @@ func (c *ConsensusImpl) persistMetaString(key string, val string) error {
 }
 
 func (c *ConsensusImpl) nextIndex() (int64, error) {
-	var idx sql.NullInt64
-	err := c.storage.db.QueryRow(`SELECT COALESCE(MAX(idx),0) FROM raft_log`).Scan(&idx)
+	last, _, err := c.lastIndexTerm()
 	if err != nil {
 		return 0, err
 	}
-	if idx.Valid {
-		return idx.Int64 + 1, nil
-	}
-	return 1, nil
-}
-
-func (c *ConsensusImpl) appendLog(e LogEntry) error {
-	_, err := c.storage.db.Exec(`INSERT OR REPLACE INTO raft_log(term, idx, event_id, aggregate, aggregate_id, op, payload, ts)
-        VALUES(?,?,?,?,?,?,?,?)`, e.Term, e.Index, e.EventID, e.Aggregate, e.AggregateID, e.Op, e.Payload, e.Timestamp)
-	return err
-}
-
-func (c *ConsensusImpl) truncateLogFrom(idx int64) error {
-	if idx <= 0 {
-		return nil
-	}
-	_, err := c.storage.db.Exec(`DELETE FROM raft_log WHERE idx >= ?`, idx)
-	return err
+	return last + 1, nil
 }
 
 func (c *ConsensusImpl) logTermAt(idx int64) (int64, error) {
@@ -498,6 +741,14 @@ func (c *ConsensusImpl) logTermAt(idx int64) (int64, error) {
 	var term sql.NullInt64
 	err := c.storage.db.QueryRow(`SELECT term FROM raft_log WHERE idx=?`, idx).Scan(&term)
 	if err == sql.ErrNoRows {
+		// The entry may have been compacted into the snapshot.
+		snapIdx, snapTerm, serr := c.snapshotMeta()
+		if serr != nil {
+			return 0, serr
+		}
+		if idx == snapIdx {
+			return snapTerm, nil
+		}
 		return 0, nil
 	}
 	if err != nil {
@@ -506,9 +757,13 @@ func (c *ConsensusImpl) logTermAt(idx int64) (int64, error) {
 	return term.Int64, nil
 }
 
-// loadLogEntriesFrom returns all log entries starting at index >= startIdx, ordered by idx ASC.
-func (c *ConsensusImpl) loadLogEntriesFrom(startIdx int64) ([]LogEntry, error) {
-	rows, err := c.storage.db.Query(`SELECT term, idx, event_id, aggregate, aggregate_id, op, payload, ts FROM raft_log WHERE idx>=? ORDER BY idx ASC`, startIdx)
+// loadLogEntriesFrom returns up to limit log entries (all if limit <= 0)
+// starting at index >= startIdx, ordered by idx ASC.
+func (c *ConsensusImpl) loadLogEntriesFrom(startIdx, limit int64) ([]LogEntry, error) {
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	rows, err := c.storage.db.Query(`SELECT term, idx, event_id, aggregate, aggregate_id, op, payload, ts, client_id, seq, payload_version FROM raft_log WHERE idx>=? ORDER BY idx ASC LIMIT ?`, startIdx, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -517,7 +772,7 @@ func (c *ConsensusImpl) loadLogEntriesFrom(startIdx int64) ([]LogEntry, error) {
 	for rows.Next() {
 		var e LogEntry
 		var ts time.Time
-		if err := rows.Scan(&e.Term, &e.Index, &e.EventID, &e.Aggregate, &e.AggregateID, &e.Op, &e.Payload, &ts); err != nil {
+		if err := rows.Scan(&e.Term, &e.Index, &e.EventID, &e.Aggregate, &e.AggregateID, &e.Op, &e.Payload, &ts, &e.ClientID, &e.Seq, &e.Version); err != nil {
 			return nil, err
 		}
 		e.Timestamp = ts
@@ -541,8 +796,22 @@ func (c *ConsensusImpl) recalculateCommitIndex() {
 		return
 	}
 
-	// Use dynamically detected active peers (recently seen) for majority
-	peers := c.activePeers(15 * time.Second)
+	// With a committed configuration, the commit index is the highest index
+	// held by a quorum of it (of both voter sets while joint).
+	if cfg := c.committedConfigLocked(); cfg != nil {
+		candidate := cfg.CommitIndex(func(id string) int64 {
+			if id == c.nodeID {
+				return lastIdx
+			}
+			return c.matchIdx[id]
+		})
+		c.advanceCommitLocked(candidate)
+		return
+	}
+
+	// Use dynamically detected active voting peers (recently seen) for majority,
+	// or every known voter in strict mode.
+	peers := c.quorumVotersLocked()
 	// Collect match indexes: leader's own last index plus followers' matchIdx.
 	// Ensure we have a value for every peer to keep majority math consistent.
 	idxs := make([]int64, 0, len(peers)+1)
@@ -569,11 +838,33 @@ func (c *ConsensusImpl) recalculateCommitIndex() {
 		return
 	}
 	candidate := idxs[len(idxs)-majority]
+	c.advanceCommitLocked(candidate)
+}
 
-	if candidate > c.state.CommitIndex {
-		c.state.CommitIndex = candidate
-		_ = c.persistMeta("commitIndex", c.state.CommitIndex)
-		c.log(slog.LevelInfo, "commit_index_advanced", "commit_index", c.state.CommitIndex)
+// advanceCommitLocked moves the commit index up to candidate, an index held
+// by a quorum. Only an entry of the current term is committed by counting
+// replicas; earlier entries are committed with it (Raft §5.4.2), since a
+// majority holding an entry of an older term may still be overwritten by a
+// leader that never saw it. Callers must hold c.mu.
+func (c *ConsensusImpl) advanceCommitLocked(candidate int64) {
+	if candidate <= c.state.CommitIndex {
+		return
+	}
+	term, err := c.logTermAt(candidate)
+	if err != nil || term != c.state.CurrentTerm {
+		return
+	}
+	c.state.CommitIndex = candidate
+	c.saveIndex("commitIndex", c.state.CommitIndex)
+	c.log(slog.LevelInfo, "commit_index_advanced", "commit_index", c.state.CommitIndex)
+	c.signalApply()
+}
+
+// signalApply wakes the main loop to apply newly committed entries.
+func (c *ConsensusImpl) signalApply() {
+	select {
+	case c.applyCh <- struct{}{}:
+	default:
 	}
 }
 
@@ -594,28 +885,36 @@ func parseInt64Default(s string, def int64) int64 {
 func parseInt64(s string) (int64, error) { return strconv.ParseInt(s, 10, 64) }
 func fmtInt(v int64) string              { return strconv.FormatInt(v, 10) }
 
-// --- applier integration ---
+// --- state machine integration ---
 
-func (c *ConsensusImpl) SetApplier(f func(LogEntry) error) {
+// SetStateMachine plugs in the state machine committed entries are applied to.
+// It must be called before Start.
+func (c *ConsensusImpl) SetStateMachine(sm StateMachine) {
 	c.mu.Lock()
-	c.applier = f
+	c.sm = sm
 	c.mu.Unlock()
 }
 
 func (c *ConsensusImpl) applyCommitted() error {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
 	c.mu.Lock()
 	lastApplied := c.state.LastApplied
 	commitIndex := c.state.CommitIndex
-	applier := c.applier
+	limit := commitIndex
+	if c.applyHold > 0 && c.applyHold < limit {
+		limit = c.applyHold
+	}
+	sm := c.sm
 	c.mu.Unlock()
-	if applier == nil {
+	if sm == nil {
 		return nil
 	}
-	if commitIndex <= lastApplied {
+	if limit <= lastApplied {
 		return nil
 	}
-	// apply [lastApplied+1, commitIndex]
-	rows, err := c.storage.db.Query(`SELECT term, idx, event_id, aggregate, aggregate_id, op, payload, ts FROM raft_log WHERE idx>? AND idx<=? ORDER BY idx ASC`, lastApplied, commitIndex)
+	// apply [lastApplied+1, limit]
+	rows, err := c.storage.db.Query(`SELECT term, idx, event_id, aggregate, aggregate_id, op, payload, ts, client_id, seq, payload_version FROM raft_log WHERE idx>? AND idx<=? ORDER BY idx ASC`, lastApplied, limit)
 	if err != nil {
 		return err
 	}
@@ -623,7 +922,7 @@ func (c *ConsensusImpl) applyCommitted() error {
 	for rows.Next() {
 		var e LogEntry
 		var ts time.Time
-		if err := rows.Scan(&e.Term, &e.Index, &e.EventID, &e.Aggregate, &e.AggregateID, &e.Op, &e.Payload, &ts); err != nil {
+		if err := rows.Scan(&e.Term, &e.Index, &e.EventID, &e.Aggregate, &e.AggregateID, &e.Op, &e.Payload, &ts, &e.ClientID, &e.Seq, &e.Version); err != nil {
 			return err
 		}
 		e.Timestamp = ts
@@ -631,57 +930,131 @@ func (c *ConsensusImpl) applyCommitted() error {
 		if err != nil {
 			return err
 		}
-		if !applied {
-			if err := applier(e); err != nil {
-				// Conservative auto-repair: for some ops, an error can be treated as a
-				// benign no-op (already applied/duplicate) to prevent the state machine
-				// from getting permanently stuck.
-				if isIgnorableApplyError(e, err) {
-					c.log(slog.LevelWarn, "apply_committed_ignored_error", "index", e.Index, "op", e.Op, "err", err.Error())
-					c.audit("raft_apply", "ignored apply error", map[string]any{"index": e.Index, "op": e.Op, "err": err.Error()})
-					if err := c.storage.RecordAppliedEvent(e.EventID, e.Index); err != nil {
-						return err
-					}
-					// advance lastApplied even though we treated it as no-op
-					lastApplied = e.Index
-					_ = c.persistMeta("lastApplied", lastApplied)
-					c.mu.Lock()
-					c.state.LastApplied = lastApplied
-					if c.applyErr != nil && c.applyErrIndex <= lastApplied {
-						c.applyErr = nil
-						c.applyErrIndex = 0
-					}
-					c.mu.Unlock()
-					continue
-				}
-				c.mu.Lock()
-				c.applyErr = err
-				c.applyErrIndex = e.Index
-				c.mu.Unlock()
+		res := ApplyResult{Index: e.Index, Op: e.Op}
+		if applied {
+			// Committed again at another index, or applied before a crash
+			// that lost lastApplied (then its change is already recorded).
+			if err := c.recordChange(c.storage, e, ChangeRejected, res, errEventAlreadyApplied); err != nil {
 				return err
 			}
-			if err := c.storage.RecordAppliedEvent(e.EventID, e.Index); err != nil {
+			c.notifyChanges()
+			lastApplied = e.Index
+			c.advanceApplied(lastApplied)
+			c.resolveProposal(e, res, nil)
+			continue
+		}
+		// A client entry, its change and raft_applied are written in one
+		// transaction where the state machine allows it (see beginEntry).
+		t := &entryTx{store: c.storage}
+		switch e.Op {
+		case OpRaftConfig:
+			err = c.applyConfigEntry(e)
+		case OpRaftNoop, OpRaftSkip:
+		default:
+			if t, err = c.beginEntry(sm); err != nil {
 				return err
 			}
+			res, err = c.applyClientEntry(t, e)
+		}
+		c.applyStarted = true
+		status := ChangeApplied
+		if err != nil {
+			if !isIgnorableApplyError(e, err) {
+				t.rollback()
+				if ferr := c.failEntry(e, res, err, commitIndex); ferr != nil {
+					return ferr
+				}
+				lastApplied = e.Index
+				continue
+			}
+			// Conservative auto-repair: for some ops, an error can be treated as a
+			// benign no-op (already applied/duplicate) to prevent the state machine
+			// from getting permanently stuck.
+			c.log(slog.LevelWarn, "apply_committed_ignored_error", "index", e.Index, "op", e.Op, "err", err.Error())
+			c.audit("raft_apply", "ignored apply error", map[string]any{"index": e.Index, "op": e.Op, "err": err.Error()})
+			status = ChangeRejected
+		}
+		if ferr := c.finishEntry(t, e, status, res, err); ferr != nil {
+			return ferr
 		}
-		// advance lastApplied
 		lastApplied = e.Index
-		_ = c.persistMeta("lastApplied", lastApplied)
+		c.advanceApplied(lastApplied)
+		// with an ignored error the log moves on, but the proposer learns its
+		// command had no effect
+		c.resolveProposal(e, res, err)
+	}
+	return nil
+}
+
+// finishEntry records the change of e and marks it applied, in t, and
+// commits t.
+func (c *ConsensusImpl) finishEntry(t *entryTx, e LogEntry, status string, res ApplyResult, applyErr error) error {
+	if err := c.recordChange(t.store, e, status, res, applyErr); err != nil {
+		t.rollback()
+		return err
+	}
+	if err := t.store.RecordAppliedEvent(e.EventID, e.Index); err != nil {
+		t.rollback()
+		return err
+	}
+	if err := t.commit(); err != nil {
+		return err
+	}
+	c.notifyChanges()
+	return nil
+}
+
+// failEntry handles e failing to apply with err: it is skipped if an operator
+// committed a raft.skip for it, otherwise applying stops there and err is
+// returned.
+func (c *ConsensusImpl) failEntry(e LogEntry, res ApplyResult, err error, commitIndex int64) error {
+	skip, found, serr := c.findSkip(e, commitIndex)
+	if serr != nil {
+		return serr
+	}
+	if !found {
 		c.mu.Lock()
-		c.state.LastApplied = lastApplied
-		if c.applyErr != nil && c.applyErrIndex <= lastApplied {
-			c.applyErr = nil
-			c.applyErrIndex = 0
-		}
+		c.applyErr = err
+		c.applyErrIndex = e.Index
 		c.mu.Unlock()
+		c.resolveProposal(e, res, err)
+		c.failPendingProposals(e.Index+1, errors.New("apply error while waiting for entry to be applied"))
+		return err
+	}
+	c.recordSkip(e, err, skip)
+	if err := c.finishEntry(&entryTx{store: c.storage}, e, ChangeSkipped, res, err); err != nil {
+		return err
 	}
+	c.advanceApplied(e.Index)
+	c.resolveProposal(e, ApplyResult{Index: e.Index, Op: e.Op}, ErrEntrySkipped)
 	return nil
 }
 
+// advanceApplied records idx as the last applied index and clears an apply
+// error it resolves.
+func (c *ConsensusImpl) advanceApplied(idx int64) {
+	c.saveIndex("lastApplied", idx)
+	c.mu.Lock()
+	c.state.LastApplied = idx
+	if idx >= c.contactCommit && c.contactAt.After(c.freshAt) {
+		c.freshAt = c.contactAt
+	}
+	if c.applyErr != nil && c.applyErrIndex <= idx {
+		c.applyErr = nil
+		c.applyErrIndex = 0
+	}
+	c.mu.Unlock()
+}
+
 func isIgnorableApplyError(e LogEntry, err error) bool {
 	if err == nil {
 		return true
 	}
+	// Outcome cached by a client session: already accepted once.
+	var replayed *replayedError
+	if errors.As(err, &replayed) {
+		return true
+	}
 	msg := err.Error()
 	// Errors we explicitly generate to represent benign idempotent replays.
 	if strings.Contains(msg, "apply conflict") {
@@ -710,125 +1083,42 @@ func isIgnorableApplyError(e LogEntry, err error) bool {
 	return false
 }
 
-// waitForApplied blocks until the leader has applied at least up to targetIdx
-// (state.LastApplied >= targetIdx), or until timeout elapses, or the node stops
-// being leader. It is only meaningful to call this on the leader.
-func (c *ConsensusImpl) waitForApplied(targetIdx int64, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-	for {
-		c.mu.RLock()
-		lastApplied := c.state.LastApplied
-		role := c.role
-		applyErr := c.applyErr
-		applyErrIndex := c.applyErrIndex
-		c.mu.RUnlock()
-
-		if role != roleLeader {
-			return errors.New("lost leadership while waiting for apply")
-		}
-		if applyErr != nil && applyErrIndex > 0 && applyErrIndex <= targetIdx {
-			return errors.New("apply error while waiting for entry to be applied")
-		}
-		if lastApplied >= targetIdx {
-			return nil
-		}
-		if time.Now().After(deadline) {
-			return errors.New("timeout waiting for entry to be applied")
-		}
-		// Small sleep to avoid busy-waiting; applyCommitted is fast in practice.
-		time.Sleep(10 * time.Millisecond)
-	}
-}
-
 // --- networking / majority replication ---
 
-func (c *ConsensusImpl) broadcastAppendEntries(term int64, entries []LogEntry, leaderCommit int64) error {
-	// Use dynamically detected active peers (recently seen) to determine
-	// the effective cluster size for this broadcast.
-	peers := c.activePeers(15 * time.Second)
-	successes := 1               // leader counts self
-	totalNodes := len(peers) + 1 // include self
+// broadcastAppendEntries sends one heartbeat round and reports whether a quorum
+// acknowledged it. Log entries are shipped by the per-peer replicators
+// (raft_replication.go); heartbeats only assert leadership and carry the
+// commit index.
+func (c *ConsensusImpl) broadcastAppendEntries(term int64, leaderCommit int64) error {
+	// With a committed configuration, replicate to every member and require a
+	// quorum of that configuration. Otherwise fall back to dynamically detected
+	// active peers (recently seen) to determine the effective cluster size.
+	cfg := c.committedConfig()
+	peers := c.replicationPeers()
+	voters := c.quorumVoters()
+	successes := 1                // leader counts self
+	totalNodes := len(voters) + 1 // include self; learners are not counted
 	majority := (totalNodes / 2) + 1
-
-	// Heartbeat-only path: keep simple behavior using last index/term
-	if len(entries) == 0 {
-		prevIdx, prevTerm, _ := c.lastIndexTerm()
-		req := AppendEntriesRequest{
-			Term:         term,
-			LeaderID:     c.nodeID,
-			PrevLogIndex: prevIdx,
-			PrevLogTerm:  prevTerm,
-			Entries:      nil,
-			LeaderCommit: leaderCommit,
-		}
-		payload, _ := json.Marshal(req)
-		type result struct {
-			success bool
-			term    int64
+	acked := map[string]bool{c.nodeID: true}
+	quorum := func() bool {
+		if cfg != nil {
+			return cfg.HasQuorum(func(id string) bool { return acked[id] })
 		}
-		ch := make(chan result, len(peers))
-		for _, id := range peers {
-			if id == c.nodeID {
-				continue
-			}
-			go func(pid string) {
-				respBody, err := c.postJSONWithResponse("http://"+c.peers.ResolveAddr(pid)+"/raft/append-entries", payload)
-				if err != nil {
-					ch <- result{success: false, term: 0}
-					return
-				}
-				var resp AppendEntriesResponse
-				if err := json.Unmarshal(respBody, &resp); err != nil {
-					ch <- result{success: false, term: 0}
-					return
-				}
-				// If follower has higher term, we need to become follower
-				if resp.Term > term {
-					c.mu.Lock()
-					if resp.Term > c.state.CurrentTerm {
-						c.state.CurrentTerm = resp.Term
-						_ = c.persistMeta("currentTerm", c.state.CurrentTerm)
-						c.role = roleFollower
-						c.peers.SetLeader("")
-						c.log(slog.LevelWarn, "leader_demoted_higher_term", "follower_term", resp.Term, "our_term", term)
-						c.audit("demotion", "leader demoted due to higher term from follower", map[string]any{"follower_term": resp.Term, "our_term": term})
-					}
-					c.mu.Unlock()
-					ch <- result{success: false, term: resp.Term}
-					return
-				}
-				ch <- result{success: resp.Success, term: resp.Term}
-			}(id)
-		}
-		timeout := time.After(3 * time.Second)
-		for pending := len(peers); pending > 0; pending-- {
-			select {
-			case res := <-ch:
-				if res.success {
-					successes++
-					c.log(slog.LevelDebug, "append_entries_success", "successes", successes, "majority", majority)
-				}
-				if successes >= majority {
-					c.log(slog.LevelInfo, "append_entries_majority_achieved", "successes", successes)
-					return nil
-				}
-			case <-timeout:
-				c.log(slog.LevelWarn, "append_entries_timeout", "successes", successes, "majority", majority)
-				return errors.New("append majority failed")
-			}
-		}
-		if successes >= majority {
-			return nil
-		}
-		c.log(slog.LevelError, "append_entries_no_majority", "successes", successes, "needed", majority)
-		return errors.New("append majority failed")
+		return successes >= majority
 	}
 
-	// Replication path with new entries: use per-peer nextIndex/matchIndex to catch up followers.
-	// We ignore the specific entries slice and instead send the suffix of our log needed by each peer.
-	lastIdx, _, _ := c.lastIndexTerm()
-
+	prevIdx, prevTerm, _ := c.lastIndexTerm()
+	req := AppendEntriesRequest{
+		Term:         term,
+		LeaderID:     c.nodeID,
+		PrevLogIndex: prevIdx,
+		PrevLogTerm:  prevTerm,
+		Entries:      nil,
+		LeaderCommit: leaderCommit,
+	}
+	payload, _ := json.Marshal(req)
 	type result struct {
+		pid     string
 		success bool
 		term    int64
 	}
@@ -838,173 +1128,47 @@ func (c *ConsensusImpl) broadcastAppendEntries(term int64, entries []LogEntry, l
 			continue
 		}
 		go func(pid string) {
-			// Try to catch up this follower with a small number of internal retries,
-			// adjusting nextIdx on each failure. This improves robustness without
-			// changing the external majority/timeout behavior.
-			const perPeerMaxRetries = 5
-			for attempt := 0; attempt < perPeerMaxRetries; attempt++ {
-				// Determine from which index this follower currently needs entries
-				c.mu.RLock()
-				ni, ok := c.nextIdx[pid]
-				if !ok || ni <= 0 {
-					ni = lastIdx + 1 // nothing to send yet
-				}
-				c.mu.RUnlock()
-
-				// Case 1: leader thinks follower is up-to-date (heartbeat-style AppendEntries)
-				if ni > lastIdx {
-					prevIdx := lastIdx
-					prevTerm, _ := c.logTermAt(prevIdx)
-					req := AppendEntriesRequest{
-						Term:         term,
-						LeaderID:     c.nodeID,
-						PrevLogIndex: prevIdx,
-						PrevLogTerm:  prevTerm,
-						Entries:      nil,
-						LeaderCommit: leaderCommit,
-					}
-					payload, _ := json.Marshal(req)
-					respBody, err := c.postJSONWithResponse("http://"+c.peers.ResolveAddr(pid)+"/raft/append-entries", payload)
-					if err != nil {
-						// Network/HTTP error: give this follower a chance in next heartbeat/broadcast
-						ch <- result{success: false, term: 0}
-						return
-					}
-					var resp AppendEntriesResponse
-					if err := json.Unmarshal(respBody, &resp); err != nil {
-						ch <- result{success: false, term: 0}
-						return
-					}
-					if resp.Term > term {
-						c.mu.Lock()
-						if resp.Term > c.state.CurrentTerm {
-							c.state.CurrentTerm = resp.Term
-							_ = c.persistMeta("currentTerm", c.state.CurrentTerm)
-							c.role = roleFollower
-							c.peers.SetLeader("")
-							c.log(slog.LevelWarn, "leader_demoted_higher_term", "follower_term", resp.Term, "our_term", term)
-							c.audit("demotion", "leader demoted due to higher term from follower", map[string]any{"follower_term": resp.Term, "our_term": term})
-						}
-						c.mu.Unlock()
-						ch <- result{success: false, term: resp.Term}
-						return
-					}
-					if resp.Success {
-						// Update matchIdx/nextIdx if follower reports progress
-						c.mu.Lock()
-						if resp.MatchIndex > 0 {
-							c.matchIdx[pid] = resp.MatchIndex
-							c.nextIdx[pid] = resp.MatchIndex + 1
-						} else {
-							c.matchIdx[pid] = lastIdx
-							c.nextIdx[pid] = lastIdx + 1
-						}
-						c.mu.Unlock()
-						ch <- result{success: true, term: resp.Term}
-						return
-					}
-					// Rechazo por inconsistencia: retroceder nextIdx para este follower y reintentar
-					c.mu.Lock()
-					curNext := c.nextIdx[pid]
-					if curNext <= 1 {
-						c.nextIdx[pid] = 1
-					} else {
-						c.nextIdx[pid] = curNext - 1
-					}
-					c.mu.Unlock()
-					continue
-				}
-
-				// Case 2: follower is behind; send log suffix starting at nextIdx
-				ents, err := c.loadLogEntriesFrom(ni)
-				if err != nil {
-					ch <- result{success: false, term: 0}
-					return
-				}
-				if len(ents) == 0 {
-					// Nothing to send; treat as success (follower already caught up)
-					c.mu.Lock()
-					c.matchIdx[pid] = lastIdx
-					c.nextIdx[pid] = lastIdx + 1
-					c.mu.Unlock()
-					ch <- result{success: true, term: term}
-					return
-				}
-				prevIdx := ni - 1
-				prevTerm, _ := c.logTermAt(prevIdx)
-				req := AppendEntriesRequest{
-					Term:         term,
-					LeaderID:     c.nodeID,
-					PrevLogIndex: prevIdx,
-					PrevLogTerm:  prevTerm,
-					Entries:      ents,
-					LeaderCommit: leaderCommit,
-				}
-				payload, _ := json.Marshal(req)
-				respBody, err := c.postJSONWithResponse("http://"+c.peers.ResolveAddr(pid)+"/raft/append-entries", payload)
-				if err != nil {
-					// Network or HTTP error, do not advance nextIdx; let outer timeout handle it
-					ch <- result{success: false, term: 0}
-					return
-				}
-				var resp AppendEntriesResponse
-				if err := json.Unmarshal(respBody, &resp); err != nil {
-					ch <- result{success: false, term: 0}
-					return
-				}
-				if resp.Term > term {
-					c.mu.Lock()
-					if resp.Term > c.state.CurrentTerm {
-						c.state.CurrentTerm = resp.Term
-						_ = c.persistMeta("currentTerm", c.state.CurrentTerm)
-						c.role = roleFollower
-						c.peers.SetLeader("")
-						c.log(slog.LevelWarn, "leader_demoted_higher_term", "follower_term", resp.Term, "our_term", term)
-						c.audit("demotion", "leader demoted due to higher term from follower", map[string]any{"follower_term": resp.Term, "our_term": term})
-					}
-					c.mu.Unlock()
-					ch <- result{success: false, term: resp.Term}
-					return
-				}
-				if resp.Success {
-					// Follower accepted entries: advance nextIdx and matchIdx
-					c.mu.Lock()
-					if resp.MatchIndex > 0 {
-						c.matchIdx[pid] = resp.MatchIndex
-						c.nextIdx[pid] = resp.MatchIndex + 1
-					} else {
-						// Fallback: assume we synced up to lastIdx
-						c.matchIdx[pid] = lastIdx
-						c.nextIdx[pid] = lastIdx + 1
-					}
-					c.mu.Unlock()
-					ch <- result{success: true, term: resp.Term}
-					return
-				}
-				// Rejection due to log inconsistency: decrement nextIdx and retry
+			respBody, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/append-entries", payload)
+			if err != nil {
+				ch <- result{pid: pid, success: false, term: 0}
+				return
+			}
+			var resp AppendEntriesResponse
+			if err := json.Unmarshal(respBody, &resp); err != nil {
+				ch <- result{pid: pid, success: false, term: 0}
+				return
+			}
+			if resp.Term <= term {
+				c.recordContact(pid)
+			}
+			// If follower has higher term, we need to become follower
+			if resp.Term > term {
 				c.mu.Lock()
-				curNext := c.nextIdx[pid]
-				if curNext <= 1 {
-					c.nextIdx[pid] = 1
-				} else {
-					c.nextIdx[pid] = curNext - 1
+				if resp.Term > c.state.CurrentTerm {
+					// step down even if the new term could not be stored
+					_ = c.setTermLocked(resp.Term, "")
+					c.role = roleFollower
+					c.peers.SetLeader("", c.state.CurrentTerm)
+					c.log(slog.LevelWarn, "leader_demoted_higher_term", "follower_term", resp.Term, "our_term", term)
+					c.audit("demotion", "leader demoted due to higher term from follower", map[string]any{"follower_term": resp.Term, "our_term": term})
 				}
 				c.mu.Unlock()
-				// loop will retry with updated nextIdx
+				ch <- result{pid: pid, success: false, term: resp.Term}
+				return
 			}
-			// Exhausted retries for this follower
-			ch <- result{success: false, term: 0}
+			ch <- result{pid: pid, success: resp.Success, term: resp.Term}
 		}(id)
 	}
-	timeout := time.After(3 * time.Second)
+	timeout := time.After(c.cfg.ElectionWait)
 	for pending := len(peers); pending > 0; pending-- {
 		select {
 		case res := <-ch:
-			if res.success {
+			if res.success && (cfg != nil || !c.peers.IsLearner(res.pid)) {
 				successes++
+				acked[res.pid] = true
 				c.log(slog.LevelDebug, "append_entries_success", "successes", successes, "majority", majority)
 			}
-			if successes >= majority {
+			if quorum() {
 				c.log(slog.LevelInfo, "append_entries_majority_achieved", "successes", successes)
 				return nil
 			}
@@ -1013,57 +1177,33 @@ func (c *ConsensusImpl) broadcastAppendEntries(term int64, entries []LogEntry, l
 			return errors.New("append majority failed")
 		}
 	}
-	if successes >= majority {
+	if quorum() {
 		return nil
 	}
 	c.log(slog.LevelError, "append_entries_no_majority", "successes", successes, "needed", majority)
 	return errors.New("append majority failed")
 }
 
-func (c *ConsensusImpl) replicateAndCommit(entry LogEntry) error {
-	// First, append to local log
-	if err := c.appendLog(entry); err != nil {
-		return err
-	}
-
-	// Then replicate to followers and wait for majority
-	maxRetries := 5
-	backoff := 100 * time.Millisecond
-
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			c.log(slog.LevelWarn, "replicate_retry", "attempt", attempt+1, "max_retries", maxRetries)
-			time.Sleep(backoff)
-			backoff *= 2 // Exponential backoff
-		}
+func (c *ConsensusImpl) startElection() error {
+	return c.campaign(true)
+}
 
-		if err := c.broadcastAppendEntries(entry.Term, []LogEntry{entry}, c.state.CommitIndex); err != nil {
-			if err.Error() == "append majority failed" {
-				c.log(slog.LevelWarn, "replicate_no_majority", "attempt", attempt+1)
-				continue // Retry
-			}
-			return err
-		}
-		// Success - break out of retry loop
-		break
+// campaign runs an election. preVote is false only when the current leader
+// asked us to take over (TimeoutNow), since the pre-vote round would only
+// delay an election the cluster already agreed to.
+func (c *ConsensusImpl) campaign(preVote bool) error {
+	if c.isLearner() {
+		c.log(slog.LevelDebug, "election_skipped_learner")
+		return ErrNotVoter
 	}
-
-	// Recalculate commit index on the leader after majority has acknowledged.
-	// This uses matchIdx and follows the Raft majority rule.
-	c.recalculateCommitIndex()
-
-	// Apply committed entries to state machine
-	if err := c.applyCommitted(); err != nil {
-		c.log(slog.LevelError, "apply_committed_failed", "err", err)
+	c.log(slog.LevelInfo, "election_started", "prevote", preVote)
+	// Once a configuration is committed, elections count votes against it
+	// instead of the reachable-peers heuristics below.
+	if cfg := c.committedConfig(); cfg != nil {
+		return c.startConfiguredElection(cfg, preVote)
 	}
-
-	return nil
-}
-
-func (c *ConsensusImpl) startElection() error {
-	c.log(slog.LevelInfo, "election_started")
 	// First run a pre-vote to check if it is likely we can win an election.
-	if !c.runPreVote() {
+	if preVote && !c.runPreVote() {
 		c.mu.Lock()
 		if c.failedElections < 10 {
 			c.failedElections++
@@ -1074,10 +1214,11 @@ func (c *ConsensusImpl) startElection() error {
 	}
 
 	c.mu.Lock()
-	c.state.CurrentTerm++
-	term := c.state.CurrentTerm
-	_ = c.persistMeta("currentTerm", term)
-	_ = c.persistMetaString("votedFor", c.nodeID)
+	term := c.state.CurrentTerm + 1
+	if err := c.setTermLocked(term, c.nodeID); err != nil {
+		c.mu.Unlock()
+		return err
+	}
 	c.role = roleCandidate
 	c.mu.Unlock()
 
@@ -1085,34 +1226,53 @@ func (c *ConsensusImpl) startElection() error {
 	req := RequestVoteRequest{Term: term, CandidateID: c.nodeID, LastLogIndex: lastIdx, LastLogTerm: lastTerm}
 	payload, _ := json.Marshal(req)
 	votes := 1 // candidate votes for itself
-	// Use dynamically detected active peers for election majority.
-	peers := c.activePeers(15 * time.Second)
+	// Use dynamically detected active voting peers for election majority
+	// (every known voter in strict mode).
+	peers := c.quorumVoters()
 	// Track reachable peers dynamically during election to handle partitions correctly
 	reachablePeers := 1 // Start with self
-	ch := make(chan bool, len(peers))
+	type ballot struct {
+		reached bool // the peer answered
+		granted bool
+	}
+	ch := make(chan ballot, len(peers))
 	for _, id := range peers {
 		if id == c.nodeID {
 			continue
 		}
 		go func(pid string) {
-			ok := c.postJSON("http://"+c.peers.ResolveAddr(pid)+"/raft/request-vote", payload)
-			ch <- ok
+			body, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/request-vote", payload)
+			if err != nil {
+				ch <- ballot{}
+				return
+			}
+			var resp RequestVoteResponse
+			if err := json.Unmarshal(body, &resp); err != nil {
+				ch <- ballot{}
+				return
+			}
+			c.NotePeerSeen(pid)
+			c.noteLeaderHint(pid, resp.LeaderID, resp.Term)
+			ch <- ballot{reached: true, granted: resp.VoteGranted}
 		}(id)
 	}
 	// Initial majority based on all known peers (will be recalculated dynamically)
-	totalNodes := len(peers) + 1           // Include self in total count
-	timeout := time.After(3 * time.Second) // Increased timeout for network latency
+	totalNodes := len(peers) + 1 // Include self in total count
+	timeout := time.After(c.cfg.ElectionWait)
 	for pending := len(peers); pending > 0; pending-- {
 		select {
-		case ok := <-ch:
+		case b := <-ch:
 			// Count this peer as reachable (responded, even if vote was denied)
-			reachablePeers++
+			if b.reached {
+				reachablePeers++
+			}
 			// Recalculate majority based on actually reachable peers
 			// This adapts to network partitions: if only 3 nodes respond, majority = 2
+			// (strict mode always needs a majority of all voters)
 			reachableTotal := reachablePeers
-			reachableMajority := (reachableTotal / 2) + 1
+			reachableMajority := c.voteMajority(reachableTotal, totalNodes)
 
-			if ok {
+			if b.granted {
 				votes++
 			}
 			// Check if we have majority or can use degraded mode
@@ -1121,42 +1281,16 @@ func (c *ConsensusImpl) startElection() error {
 			if votes >= reachableMajority {
 				// Normal case: we have majority
 				c.mu.Lock()
-				c.role = roleLeader
-				c.peers.SetLeader(c.nodeID)
-				c.heartbeatFailures = 0
-				c.failedElections = 0
-				lastIdx, _, _ := c.lastIndexTerm()
-				c.nextIdx = make(map[string]int64)
-				c.matchIdx = make(map[string]int64)
-				for _, id := range peers {
-					if id == c.nodeID {
-						continue
-					}
-					c.nextIdx[id] = lastIdx + 1
-					c.matchIdx[id] = 0
-				}
+				c.becomeLeaderLocked(peers)
 				c.mu.Unlock()
 				c.log(slog.LevelInfo, "election_won", "term", term, "votes", votes, "majority", reachableMajority, "reachable_peers", reachableTotal, "total_known", totalNodes)
 				c.audit("election", "node became leader", map[string]any{"term": term, "votes": votes, "majority": reachableMajority, "reachable_peers": reachableTotal, "total_known": totalNodes})
 				return nil
-			} else if votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
+			} else if !c.strictQuorum && votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
 				// Degraded mode: only for very small clusters where we tried to contact others
 				// but they're unreachable. This prevents split-brain during partitions.
 				c.mu.Lock()
-				c.role = roleLeader
-				c.peers.SetLeader(c.nodeID)
-				c.heartbeatFailures = 0
-				c.failedElections = 0
-				lastIdx, _, _ := c.lastIndexTerm()
-				c.nextIdx = make(map[string]int64)
-				c.matchIdx = make(map[string]int64)
-				for _, id := range peers {
-					if id == c.nodeID {
-						continue
-					}
-					c.nextIdx[id] = lastIdx + 1
-					c.matchIdx[id] = 0
-				}
+				c.becomeLeaderLocked(peers)
 				c.mu.Unlock()
 				c.log(slog.LevelWarn, "election_won_degraded", "term", term, "votes", votes, "reachable_peers", reachableTotal, "total_known", totalNodes, "attempted", attemptedContacts)
 				c.audit("election", "node became leader in degraded mode", map[string]any{"term": term, "votes": votes, "reachable_peers": reachableTotal, "total_known": totalNodes})
@@ -1166,44 +1300,18 @@ func (c *ConsensusImpl) startElection() error {
 		case <-timeout:
 			// On timeout, recalculate based on peers that responded
 			reachableTotal := reachablePeers
-			reachableMajority := (reachableTotal / 2) + 1
+			reachableMajority := c.voteMajority(reachableTotal, totalNodes)
 			attemptedContacts := len(peers)
 			if votes >= reachableMajority {
 				c.mu.Lock()
-				c.role = roleLeader
-				c.peers.SetLeader(c.nodeID)
-				c.heartbeatFailures = 0
-				c.failedElections = 0
-				lastIdx, _, _ := c.lastIndexTerm()
-				c.nextIdx = make(map[string]int64)
-				c.matchIdx = make(map[string]int64)
-				for _, id := range peers {
-					if id == c.nodeID {
-						continue
-					}
-					c.nextIdx[id] = lastIdx + 1
-					c.matchIdx[id] = 0
-				}
+				c.becomeLeaderLocked(peers)
 				c.mu.Unlock()
 				c.log(slog.LevelInfo, "election_won_timeout", "term", term, "votes", votes, "majority", reachableMajority, "reachable_peers", reachableTotal, "total_known", totalNodes)
 				c.audit("election", "node became leader after timeout", map[string]any{"term": term, "votes": votes, "majority": reachableMajority, "reachable_peers": reachableTotal, "total_known": totalNodes})
 				return nil
-			} else if votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
+			} else if !c.strictQuorum && votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
 				c.mu.Lock()
-				c.role = roleLeader
-				c.peers.SetLeader(c.nodeID)
-				c.heartbeatFailures = 0
-				c.failedElections = 0
-				lastIdx, _, _ := c.lastIndexTerm()
-				c.nextIdx = make(map[string]int64)
-				c.matchIdx = make(map[string]int64)
-				for _, id := range peers {
-					if id == c.nodeID {
-						continue
-					}
-					c.nextIdx[id] = lastIdx + 1
-					c.matchIdx[id] = 0
-				}
+				c.becomeLeaderLocked(peers)
 				c.mu.Unlock()
 				c.log(slog.LevelWarn, "election_won_degraded_timeout", "term", term, "votes", votes, "reachable_peers", reachableTotal, "total_known", totalNodes, "attempted", attemptedContacts)
 				c.audit("election", "node became leader in degraded mode after timeout", map[string]any{"term": term, "votes": votes, "reachable_peers": reachableTotal, "total_known": totalNodes})
@@ -1220,47 +1328,21 @@ func (c *ConsensusImpl) startElection() error {
 	}
 	// All peers responded, recalculate based on reachable peers
 	reachableTotal := reachablePeers
-	reachableMajority := (reachableTotal / 2) + 1
+	reachableMajority := c.voteMajority(reachableTotal, totalNodes)
 	attemptedContacts := len(peers) // peers excludes self
 	if votes >= reachableMajority {
 		// Normal case: we have majority
 		c.mu.Lock()
-		c.role = roleLeader
-		c.peers.SetLeader(c.nodeID)
-		c.heartbeatFailures = 0
-		c.failedElections = 0
-		lastIdx, _, _ := c.lastIndexTerm()
-		c.nextIdx = make(map[string]int64)
-		c.matchIdx = make(map[string]int64)
-		for _, id := range peers {
-			if id == c.nodeID {
-				continue
-			}
-			c.nextIdx[id] = lastIdx + 1
-			c.matchIdx[id] = 0
-		}
+		c.becomeLeaderLocked(peers)
 		c.mu.Unlock()
 		c.log(slog.LevelInfo, "election_won", "term", term, "votes", votes, "majority", reachableMajority, "reachable_peers", reachableTotal, "total_known", totalNodes)
 		c.audit("election", "node became leader", map[string]any{"term": term, "votes": votes, "majority": reachableMajority, "reachable_peers": reachableTotal, "total_known": totalNodes})
 		return nil
-	} else if votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
+	} else if !c.strictQuorum && votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
 		// Degraded mode: only for very small clusters where we tried to contact others
 		// but they're unreachable. This prevents split-brain during partitions.
 		c.mu.Lock()
-		c.role = roleLeader
-		c.peers.SetLeader(c.nodeID)
-		c.heartbeatFailures = 0
-		c.failedElections = 0
-		lastIdx, _, _ := c.lastIndexTerm()
-		c.nextIdx = make(map[string]int64)
-		c.matchIdx = make(map[string]int64)
-		for _, id := range peers {
-			if id == c.nodeID {
-				continue
-			}
-			c.nextIdx[id] = lastIdx + 1
-			c.matchIdx[id] = 0
-		}
+		c.becomeLeaderLocked(peers)
 		c.mu.Unlock()
 		c.log(slog.LevelWarn, "election_won_degraded", "term", term, "votes", votes, "reachable_peers", reachableTotal, "total_known", totalNodes, "attempted", attemptedContacts)
 		c.audit("election", "node became leader in degraded mode", map[string]any{"term": term, "votes": votes, "reachable_peers": reachableTotal, "total_known": totalNodes})
@@ -1286,15 +1368,17 @@ func (c *ConsensusImpl) runPreVote() bool {
 	lastIdx, lastTerm, _ := c.lastIndexTerm()
 	req := RequestVoteRequest{Term: term, CandidateID: c.nodeID, LastLogIndex: lastIdx, LastLogTerm: lastTerm, PreVote: true}
 	payload, _ := json.Marshal(req)
-	// Use dynamically detected active peers for pre-vote majority.
-	peers := c.activePeers(15 * time.Second)
+	// Use dynamically detected active voting peers for pre-vote majority
+	// (every known voter in strict mode).
+	peers := c.quorumVoters()
 	// Track reachable peers dynamically during pre-vote to handle partitions correctly
 	reachablePeers := 1 // Start with self
 	totalNodes := len(peers) + 1
 	votes := 1 // local node is implicitly willing to vote for itself
 
 	type res struct {
-		ok bool
+		reached bool // the peer answered
+		ok      bool
 	}
 	ch := make(chan res, len(peers))
 	for _, id := range peers {
@@ -1302,7 +1386,7 @@ func (c *ConsensusImpl) runPreVote() bool {
 			continue
 		}
 		go func(pid string) {
-			body, err := c.postJSONWithResponse("http://"+c.peers.ResolveAddr(pid)+"/raft/request-vote", payload)
+			body, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/request-vote", payload)
 			if err != nil {
 				ch <- res{ok: false}
 				return
@@ -1312,18 +1396,23 @@ func (c *ConsensusImpl) runPreVote() bool {
 				ch <- res{ok: false}
 				return
 			}
-			ch <- res{ok: resp.VoteGranted}
+			c.NotePeerSeen(pid)
+			c.noteLeaderHint(pid, resp.LeaderID, resp.Term)
+			ch <- res{reached: true, ok: resp.VoteGranted}
 		}(id)
 	}
-	timeout := time.After(3 * time.Second)
+	timeout := time.After(c.cfg.ElectionWait)
 	for pending := len(peers); pending > 0; pending-- {
 		select {
 		case r := <-ch:
 			// Count this peer as reachable (responded, even if vote was denied)
-			reachablePeers++
+			if r.reached {
+				reachablePeers++
+			}
 			// Recalculate majority based on actually reachable peers
+			// (strict mode always needs a majority of all voters)
 			reachableTotal := reachablePeers
-			reachableMajority := (reachableTotal / 2) + 1
+			reachableMajority := c.voteMajority(reachableTotal, totalNodes)
 
 			if r.ok {
 				votes++
@@ -1333,7 +1422,7 @@ func (c *ConsensusImpl) runPreVote() bool {
 			if votes >= reachableMajority {
 				c.log(slog.LevelDebug, "prevote_majority_achieved", "votes", votes, "majority", reachableMajority, "reachable_peers", reachableTotal, "total_known", totalNodes)
 				return true
-			} else if votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
+			} else if !c.strictQuorum && votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
 				// Degraded mode: only if we tried to contact others (prevents split-brain)
 				c.log(slog.LevelDebug, "prevote_degraded_mode", "votes", votes, "reachable_peers", reachableTotal, "total_known", totalNodes, "attempted", attemptedContacts)
 				return true
@@ -1342,12 +1431,12 @@ func (c *ConsensusImpl) runPreVote() bool {
 		case <-timeout:
 			// On timeout, recalculate based on peers that responded
 			reachableTotal := reachablePeers
-			reachableMajority := (reachableTotal / 2) + 1
+			reachableMajority := c.voteMajority(reachableTotal, totalNodes)
 			attemptedContacts := len(peers)
 			if votes >= reachableMajority {
 				c.log(slog.LevelDebug, "prevote_majority_achieved_timeout", "votes", votes, "majority", reachableMajority, "reachable_peers", reachableTotal, "total_known", totalNodes)
 				return true
-			} else if votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
+			} else if !c.strictQuorum && votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
 				c.log(slog.LevelDebug, "prevote_degraded_mode_timeout", "votes", votes, "reachable_peers", reachableTotal, "total_known", totalNodes, "attempted", attemptedContacts)
 				return true
 			}
@@ -1357,12 +1446,12 @@ func (c *ConsensusImpl) runPreVote() bool {
 	}
 	// All peers responded, recalculate based on reachable peers
 	reachableTotal := reachablePeers
-	reachableMajority := (reachableTotal / 2) + 1
+	reachableMajority := c.voteMajority(reachableTotal, totalNodes)
 	attemptedContacts := len(peers) // peers excludes self
 	if votes >= reachableMajority {
 		c.log(slog.LevelDebug, "prevote_majority_achieved", "votes", votes, "majority", reachableMajority, "reachable_peers", reachableTotal, "total_known", totalNodes)
 		return true
-	} else if votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
+	} else if !c.strictQuorum && votes == 1 && attemptedContacts > 0 && (reachableTotal == 2 || reachableTotal == 3) {
 		// Degraded mode: only if we tried to contact others
 		c.log(slog.LevelDebug, "prevote_degraded_mode", "votes", votes, "reachable_peers", reachableTotal, "total_known", totalNodes, "attempted", attemptedContacts)
 		return true
@@ -1375,7 +1464,8 @@ func (c *ConsensusImpl) lastIndexTerm() (int64, int64, error) {
 	var idx, term sql.NullInt64
 	err := c.storage.db.QueryRow(`SELECT idx, term FROM raft_log ORDER BY idx DESC LIMIT 1`).Scan(&idx, &term)
 	if err == sql.ErrNoRows {
-		return 0, 0, nil
+		// Fully compacted log: the snapshot marks the last index/term.
+		return c.snapshotMeta()
 	}
 	if err != nil {
 		return 0, 0, err
@@ -1386,6 +1476,9 @@ func (c *ConsensusImpl) lastIndexTerm() (int64, int64, error) {
 func (c *ConsensusImpl) postJSON(url string, body []byte) bool {
 	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
+	if c.group != "" {
+		req.Header.Set(RaftGroupHeader, c.group)
+	}
 	if c.hmacSecret != "" {
 		sig := computeHMACSHA256Hex(body, c.hmacSecret)
 		req.Header.Set("X-Cluster-Signature", sig)
@@ -1402,6 +1495,9 @@ func (c *ConsensusImpl) postJSON(url string, body []byte) bool {
 func (c *ConsensusImpl) postJSONWithResponse(url string, body []byte) ([]byte, error) {
 	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
+	if c.group != "" {
+		req.Header.Set(RaftGroupHeader, c.group)
+	}
 	if c.hmacSecret != "" {
 		sig := computeHMACSHA256Hex(body, c.hmacSecret)
 		req.Header.Set("X-Cluster-Signature", sig)
@@ -1412,7 +1508,14 @@ func (c *ConsensusImpl) postJSONWithResponse(url string, body []byte) ([]byte, e
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return nil, errors.New("http error")
+		return nil, &httpStatusError{code: resp.StatusCode}
 	}
 	return io.ReadAll(resp.Body)
 }
+
+// httpStatusError is a non-2xx answer to a node-to-node request.
+type httpStatusError struct {
+	code int
+}
+
+func (e *httpStatusError) Error() string { return "http error " + strconv.Itoa(e.code) }
@@ -0,0 +1,275 @@
+package agendadistribuida
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsensusConfig holds the timing, transport and log settings of a node.
+// LoadConsensusConfig builds it from DefaultConsensusConfig, an optional JSON
+// file and RAFT_* environment variables, in that order of precedence (the
+// environment wins).
+type ConsensusConfig struct {
+	// HeartbeatInterval is how often the leader heartbeats and idle
+	// replicators resend AppendEntries.
+	HeartbeatInterval time.Duration
+	// ElectionTimeout is the base time without a leader before a follower
+	// campaigns. A random ElectionJitter is added, plus ElectionBackoff for
+	// each consecutive failed election (up to 3).
+	ElectionTimeout time.Duration
+	ElectionJitter  time.Duration
+	ElectionBackoff time.Duration
+	// ElectionWait is how long a (pre-)vote or heartbeat round waits for
+	// answers.
+	ElectionWait time.Duration
+	// PeerStaleness is how long a peer counts as active after it was last
+	// seen (reachable majorities outside strict quorum mode).
+	PeerStaleness time.Duration
+	// HTTPTimeout bounds every node-to-node request.
+	HTTPTimeout time.Duration
+	// ApplyWaitTimeout is how long Propose waits for its entry to be
+	// committed and applied.
+	ApplyWaitTimeout time.Duration
+	// DigestInterval is how often the leader compares state machine digests
+	// with its followers (0: only on demand).
+	DigestInterval time.Duration
+
+	MaxBatch          int64 // entries per AppendEntries and per proposal batch (0: no limit)
+	PipelineDepth     int64 // AppendEntries batches in flight per follower
+	SnapshotThreshold int64 // applied entries between snapshots (0: never snapshot)
+	SnapshotTrailing  int64 // entries kept below a snapshot for lagging followers
+	ChangeRetention   int64 // applied entries whose changes are kept for consumers (0: keep all)
+	SessionRetention  int64 // entries after which an idle client session expires (0: never)
+	LearnerCatchUpLag int64 // max lag of a learner promoted to voter
+	Shards            int64 // Raft groups the agenda is split into besides the meta group (0: a single group)
+	Learner           bool  // read replica: receives the log, never votes
+	StrictQuorum      bool  // majorities over every voter (InitialVoters before a configuration), plus CheckQuorum
+	FaultInjection    bool  // accept fault rules on /raft/faults (tests only)
+
+	// InitialVoters lists the voters of the first cluster configuration as
+	// node IDs, each optionally followed by "=host:port" (see
+	// bootstrapConfigLocked). Without it the cluster stays in legacy mode.
+	InitialVoters []string
+}
+
+// DefaultConsensusConfig returns the settings used when nothing is configured.
+func DefaultConsensusConfig() ConsensusConfig {
+	return ConsensusConfig{
+		HeartbeatInterval: 1 * time.Second,
+		ElectionTimeout:   5 * time.Second,
+		ElectionJitter:    2 * time.Second,
+		ElectionBackoff:   2 * time.Second,
+		ElectionWait:      3 * time.Second,
+		PeerStaleness:     15 * time.Second,
+		HTTPTimeout:       5 * time.Second,
+		ApplyWaitTimeout:  10 * time.Second,
+		DigestInterval:    10 * time.Minute,
+		MaxBatch:          128,
+		PipelineDepth:     4,
+		SnapshotThreshold: 1000,
+		SnapshotTrailing:  100,
+		ChangeRetention:   100000,
+		SessionRetention:  100000,
+		LearnerCatchUpLag: 10,
+	}
+}
+
+// consensusSetting names one field of ConsensusConfig in the config file and
+// in the environment.
+type consensusSetting struct {
+	key    string // config file key
+	env    string
+	target any // *time.Duration, *int64, *bool or *[]string
+}
+
+func (cfg *ConsensusConfig) settings() []consensusSetting {
+	return []consensusSetting{
+		{"heartbeat_interval", "RAFT_HEARTBEAT_INTERVAL", &cfg.HeartbeatInterval},
+		{"election_timeout", "RAFT_ELECTION_TIMEOUT", &cfg.ElectionTimeout},
+		{"election_jitter", "RAFT_ELECTION_JITTER", &cfg.ElectionJitter},
+		{"election_backoff", "RAFT_ELECTION_BACKOFF", &cfg.ElectionBackoff},
+		{"election_wait", "RAFT_ELECTION_WAIT", &cfg.ElectionWait},
+		{"peer_staleness", "RAFT_PEER_STALENESS", &cfg.PeerStaleness},
+		{"http_timeout", "RAFT_HTTP_TIMEOUT", &cfg.HTTPTimeout},
+		{"apply_wait_timeout", "RAFT_APPLY_TIMEOUT", &cfg.ApplyWaitTimeout},
+		{"digest_interval", "RAFT_DIGEST_INTERVAL", &cfg.DigestInterval},
+		{"max_batch", "RAFT_MAX_BATCH", &cfg.MaxBatch},
+		{"pipeline_depth", "RAFT_PIPELINE_DEPTH", &cfg.PipelineDepth},
+		{"snapshot_threshold", "RAFT_SNAPSHOT_THRESHOLD", &cfg.SnapshotThreshold},
+		{"snapshot_trailing", "RAFT_SNAPSHOT_TRAILING", &cfg.SnapshotTrailing},
+		{"change_retention", "RAFT_CHANGE_RETENTION", &cfg.ChangeRetention},
+		{"session_retention", "RAFT_SESSION_RETENTION", &cfg.SessionRetention},
+		{"learner_catchup_lag", "RAFT_LEARNER_CATCHUP_LAG", &cfg.LearnerCatchUpLag},
+		{"shards", "RAFT_SHARDS", &cfg.Shards},
+		{"learner", "RAFT_LEARNER", &cfg.Learner},
+		{"strict_quorum", "RAFT_STRICT_QUORUM", &cfg.StrictQuorum},
+		{"fault_injection", "RAFT_FAULT_INJECTION", &cfg.FaultInjection},
+		{"initial_voters", "RAFT_INITIAL_VOTERS", &cfg.InitialVoters},
+	}
+}
+
+// set parses v into the setting's field. Durations use Go syntax ("1500ms"),
+// lists are comma-separated.
+func (s consensusSetting) set(v string) error {
+	v = strings.TrimSpace(v)
+	switch t := s.target.(type) {
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*t = d
+	case *int64:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*t = n
+	case *bool:
+		switch strings.ToLower(v) {
+		case "1", "true", "yes":
+			*t = true
+		case "0", "false", "no", "":
+			*t = false
+		default:
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+	case *[]string:
+		*t = nil
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*t = append(*t, item)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadConsensusConfig returns the validated settings from path (a JSON object
+// keyed like consensusSetting.key; skipped when path is empty) overridden by
+// the environment.
+func LoadConsensusConfig(path string) (ConsensusConfig, error) {
+	cfg := DefaultConsensusConfig()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return ConsensusConfig{}, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return ConsensusConfig{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return ConsensusConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (cfg *ConsensusConfig) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, s := range cfg.settings() {
+		v, ok := raw[s.key]
+		if !ok {
+			continue
+		}
+		delete(raw, s.key)
+		text := string(bytes.TrimSpace(v))
+		switch {
+		case strings.HasPrefix(text, `"`):
+			if err := json.Unmarshal(v, &text); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, s.key, err)
+			}
+		case strings.HasPrefix(text, "["):
+			var items []string
+			if err := json.Unmarshal(v, &items); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, s.key, err)
+			}
+			text = strings.Join(items, ",")
+		}
+		if err := s.set(text); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, s.key, err)
+		}
+	}
+	for key := range raw {
+		return fmt.Errorf("%s: unknown setting %q", path, key)
+	}
+	return nil
+}
+
+func (cfg *ConsensusConfig) loadEnv() error {
+	for _, s := range cfg.settings() {
+		v, ok := os.LookupEnv(s.env)
+		if !ok || strings.TrimSpace(v) == "" {
+			continue
+		}
+		if err := s.set(v); err != nil {
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+	}
+	return nil
+}
+
+// Validate rejects settings the consensus loop cannot work with.
+func (cfg ConsensusConfig) Validate() error {
+	var errs []error
+	for _, d := range []struct {
+		key string
+		val time.Duration
+	}{
+		{"heartbeat_interval", cfg.HeartbeatInterval},
+		{"election_timeout", cfg.ElectionTimeout},
+		{"election_wait", cfg.ElectionWait},
+		{"peer_staleness", cfg.PeerStaleness},
+		{"http_timeout", cfg.HTTPTimeout},
+		{"apply_wait_timeout", cfg.ApplyWaitTimeout},
+	} {
+		if d.val <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.key))
+		}
+	}
+	if cfg.ElectionJitter < 0 || cfg.ElectionBackoff < 0 || cfg.DigestInterval < 0 {
+		errs = append(errs, errors.New("election_jitter, election_backoff and digest_interval must not be negative"))
+	}
+	// A follower must see several heartbeats before its election timer fires,
+	// or a single delayed heartbeat starts an election.
+	if cfg.HeartbeatInterval > 0 && cfg.ElectionTimeout < 3*cfg.HeartbeatInterval {
+		errs = append(errs, fmt.Errorf("election_timeout (%s) must be at least 3 x heartbeat_interval (%s)", cfg.ElectionTimeout, cfg.HeartbeatInterval))
+	}
+	if cfg.ElectionWait > cfg.ElectionTimeout {
+		errs = append(errs, fmt.Errorf("election_wait (%s) must not exceed election_timeout (%s)", cfg.ElectionWait, cfg.ElectionTimeout))
+	}
+	if cfg.PeerStaleness < 2*cfg.HeartbeatInterval {
+		errs = append(errs, fmt.Errorf("peer_staleness (%s) must be at least 2 x heartbeat_interval (%s)", cfg.PeerStaleness, cfg.HeartbeatInterval))
+	}
+	if cfg.PipelineDepth < 1 {
+		errs = append(errs, errors.New("pipeline_depth must be at least 1"))
+	}
+	if cfg.MaxBatch < 0 || cfg.SnapshotThreshold < 0 || cfg.SnapshotTrailing < 0 || cfg.ChangeRetention < 0 || cfg.SessionRetention < 0 || cfg.LearnerCatchUpLag < 0 || cfg.Shards < 0 {
+		errs = append(errs, errors.New("max_batch, snapshot_threshold, snapshot_trailing, change_retention, session_retention, learner_catchup_lag and shards must not be negative"))
+	}
+	// Before a configuration is committed, strict majorities are counted over
+	// the initial voters (raft_quorum.go).
+	if cfg.StrictQuorum && len(cfg.InitialVoters) == 0 {
+		errs = append(errs, errors.New("strict_quorum requires initial_voters"))
+	}
+	seen := map[string]bool{}
+	for _, m := range parseInitialVoters(cfg.InitialVoters) {
+		if m.NodeID == "" || seen[m.NodeID] {
+			errs = append(errs, fmt.Errorf("initial_voters: empty or repeated node id %q", m.NodeID))
+		}
+		seen[m.NodeID] = true
+	}
+	return errors.Join(errs...)
+}
@@ -24,9 +24,10 @@ type DiscoveryManager struct {
 	stop          chan struct{}
 	httpClient    *http.Client
 	maxPeerAge    time.Duration
+	learner       bool // ConsensusConfig.Learner: announce this node as non-voting
 }
 
-func NewDiscoveryManager(store *Storage, peers *EnvPeerStore, localID, advertiseAddr string) *DiscoveryManager {
+func NewDiscoveryManager(store *Storage, peers *EnvPeerStore, localID, advertiseAddr string, learner bool) *DiscoveryManager {
 	return &DiscoveryManager{
 		store:         store,
 		peers:         peers,
@@ -40,9 +41,16 @@ func NewDiscoveryManager(store *Storage, peers *EnvPeerStore, localID, advertise
 		stop:          make(chan struct{}),
 		httpClient:    &http.Client{Timeout: 2 * time.Second},
 		maxPeerAge:    2 * time.Minute,
+		learner:       learner,
 	}
 }
 
+// SetConsensus sends the discovery requests through the fault injector of
+// cons. It must be called before Start.
+func (d *DiscoveryManager) SetConsensus(cons Consensus) {
+	d.httpClient = clusterHTTPClient(cons, d.httpClient.Timeout)
+}
+
 func (d *DiscoveryManager) Start() {
 	// Register local node
 	_ = d.store.UpsertClusterNode(&ClusterNode{
@@ -50,7 +58,10 @@ func (d *DiscoveryManager) Start() {
 		Address:  d.advertiseAddr,
 		Source:   "local",
 		LastSeen: time.Now(),
+		Learner:  d.learner,
 	})
+	_ = d.store.SetClusterNodeLearner(d.localID, d.learner)
+	d.peers.SetLearner(d.localID, d.learner)
 	go d.syncLoop()
 	if d.dnsName != "" {
 		go d.dnsLoop()
@@ -156,10 +167,11 @@ func (d *DiscoveryManager) seedLoop() {
 }
 
 func (d *DiscoveryManager) announceToSeeds() {
-	payload := map[string]string{
+	payload := map[string]any{
 		"node_id": d.localID,
 		"address": d.advertiseAddr,
 		"source":  "gossip",
+		"learner": d.learner,
 	}
 	body, _ := json.Marshal(payload)
 	for _, seed := range d.seeds {
@@ -223,6 +235,7 @@ func (d *DiscoveryManager) announceToSeeds() {
 					Address:  node.Address,
 					Source:   "gossip",
 					LastSeen: lastSeen,
+					Learner:  node.Learner,
 				})
 			}
 		}
@@ -262,6 +275,7 @@ func (d *DiscoveryManager) updatePeersFromStorage() {
 			addr = node.NodeID
 		}
 		snapshot[node.NodeID] = addr
+		d.peers.SetLearner(node.NodeID, node.Learner)
 	}
 	d.peers.SetSnapshot(snapshot)
 	RecordAudit(context.Background(), AuditLevelInfo, "cluster", "peers_synced", "peer snapshot refreshed", map[string]any{
@@ -3,19 +3,28 @@ package agendadistribuida
 import (
 	"context"
 	"encoding/json"
-	"net/http"
 	"time"
 )
 
-// StartHeartbeats polls peers' /raft/health to discover current leader.
-// It's a best-effort mechanism until full AppendEntries heartbeats are implemented.
-// Also updates LastSeen for successfully contacted peers to keep them in PeerStore
-// during network partitions.
-func StartHeartbeats(ps *EnvPeerStore, store *Storage, stopCh <-chan struct{}) {
+// peerSeer is implemented by consensus nodes that track when each peer was
+// last heard from (ConsensusImpl.NotePeerSeen).
+type peerSeer interface {
+	NotePeerSeen(id string)
+}
+
+// StartHeartbeats polls peers' /raft/health to update LastSeen for successfully
+// contacted peers, keeping them in PeerStore during network partitions, and
+// reports each answer to cons (see peerSeer).
+// Followers learn the leader from AppendEntries; the term-stamped leader hint in
+// each health response only helps nodes that have not heard from it yet (a hint
+// older than the term already known is ignored, so a deposed leader still
+// claiming leadership is not picked up). The polls go through the fault
+// injector of cons.
+func StartHeartbeats(ps *EnvPeerStore, store *Storage, cons Consensus, stopCh <-chan struct{}) {
 	go func() {
 		ticker := time.NewTicker(2 * time.Second)
 		defer ticker.Stop()
-		client := &http.Client{Timeout: 3 * time.Second} // Increased timeout for network latency
+		client := clusterHTTPClient(cons, 3*time.Second) // Increased timeout for network latency
 		for {
 			select {
 			case <-stopCh:
@@ -29,14 +38,18 @@ func StartHeartbeats(ps *EnvPeerStore, store *Storage, stopCh <-chan struct{}) {
 						continue
 					}
 					var h struct {
-						NodeID   string `json:"node_id"`
-						IsLeader bool   `json:"is_leader"`
+						NodeID     string `json:"node_id"`
+						Leader     string `json:"leader"`
+						LeaderTerm int64  `json:"leader_term"`
 					}
 					_ = json.NewDecoder(resp.Body).Decode(&h)
 					resp.Body.Close()
 					
 					// Update LastSeen for successfully contacted peer
 					// This is critical during partitions: reachable peers stay in PeerStore
+					if seer, ok := cons.(peerSeer); ok {
+						seer.NotePeerSeen(id)
+					}
 					if store != nil && id != "" && id != ps.LocalID() {
 						_ = store.UpsertClusterNode(&ClusterNode{
 							NodeID:   id,
@@ -46,16 +59,15 @@ func StartHeartbeats(ps *EnvPeerStore, store *Storage, stopCh <-chan struct{}) {
 						})
 					}
 					
-					if h.IsLeader {
+					if h.Leader != "" && h.Leader != ps.LocalID() && h.LeaderTerm > 0 {
 						prev := ps.GetLeader()
-						ps.SetLeader(id)
-						if prev != id {
-							Logger().Info("heartbeat_leader_detected", "leader_id", id)
+						if ps.SetLeader(h.Leader, h.LeaderTerm) && prev != h.Leader {
+							Logger().Info("heartbeat_leader_detected", "leader_id", h.Leader, "term", h.LeaderTerm, "from", id)
 							RecordAudit(context.Background(), AuditLevelInfo, "cluster", "leader_detected", "leader discovered via heartbeat", map[string]any{
-								"leader_id": id,
+								"leader_id": h.Leader,
+								"term":      h.LeaderTerm,
 							})
 						}
-						break
 					}
 				}
 			}
@@ -49,6 +49,10 @@ func (rr *responseRecorder) WriteHeader(status int) {
 	rr.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap lets http.ResponseController reach the connection (flushes and write
+// deadlines of streaming handlers).
+func (rr *responseRecorder) Unwrap() http.ResponseWriter { return rr.ResponseWriter }
+
 func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	h, ok := rr.ResponseWriter.(http.Hijacker)
 	if !ok {
@@ -169,6 +173,7 @@ func NewAPI(
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	})
+	protected.Use(api.readConsistencyMiddleware())
 
 	// Routes protegidas
 	protected.HandleFunc("/groups", api.handleCreateGroup()).Methods("POST")
@@ -206,6 +211,52 @@ func NewAPI(
 
 func (a *API) Router() *mux.Router { return a.router }
 
+// appsFor binds the appointment service to the client session of r, if the
+// request carries one (see clientRequestFromHTTP), and to the record of what
+// r writes (see trackRaftProgress).
+func (a *API) appsFor(r *http.Request) AppointmentService {
+	cr, _ := clientRequestFromHTTP(r)
+	cr.written = writeRecordFrom(r.Context())
+	if cr.ClientID == "" && cr.written == nil {
+		return a.apps
+	}
+	return a.apps.WithClientRequest(cr)
+}
+
+// propose replicates entry on behalf of r: it carries the client session of
+// r and its commit index is reported in the response. Check the error with
+// proposeFailed: a committed entry may come with ErrNotAppliedLocally.
+func (a *API) propose(r *http.Request, entry LogEntry) (ApplyResult, error) {
+	res, err := a.cons.Propose(stampClientRequest(r, entry))
+	if !proposeFailed(err) {
+		noteWrite(r.Context(), res)
+	}
+	return res, err
+}
+
+// readConsistencyMiddleware runs a ReadIndex barrier before GET handlers when
+// the client opts into linearizable reads (see wantsLinearizableRead). Reads
+// without the opt-in keep serving whatever the local node has applied.
+func (a *API) readConsistencyMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || a.cons == nil || !wantsLinearizableRead(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// ReadIndex gives up after RAFT_APPLY_TIMEOUT.
+			idx, err := a.cons.ReadIndex(r.Context())
+			if err != nil {
+				a.log(r.Context(), slog.LevelWarn, "linearizable_read_failed", "path", r.URL.Path, "err", err)
+				http.Error(w, "linearizable read unavailable: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("X-Raft-Read-Index", strconv.FormatInt(idx, 10))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (a *API) handleRegister() http.HandlerFunc {
 	type req struct {
 		Username    string `json:"username"`
@@ -271,13 +322,21 @@ func (a *API) handleRegister() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			res, err := a.propose(r, entry)
+			if proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "register_propose_failed", "err", err)
+				// The state machine reports a lost race as an apply conflict.
+				if strings.Contains(err.Error(), "apply conflict") {
+					http.Error(w, "user already exists", http.StatusConflict)
+					return
+				}
 				http.Error(w, "failed to replicate user", http.StatusInternalServerError)
 				return
 			}
-			// After commit, load the user from storage to get its ID
-			if created, err := a.users.GetUserByUsername(u.Username); err == nil && created != nil {
+			// Load the user the state machine created (unless it is not
+			// applied here yet, see ErrNotAppliedLocally)
+			u.ID = res.ID
+			if created, err := a.users.GetUserByID(res.ID); err == nil && created != nil {
 				u = created
 			}
 		} else {
@@ -402,11 +461,13 @@ func (a *API) handleCreateGroup() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			res, err := a.propose(r, entry)
+			if proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "group_create_propose_failed", "err", err)
 				http.Error(w, "failed to replicate group", http.StatusInternalServerError)
 				return
 			}
+			g.ID = res.ID
 		} else {
 			// Fallback for single-node or no-consensus setups: write directly
 			if err := a.groupsRepo.CreateGroup(g); err != nil {
@@ -516,7 +577,7 @@ func (a *API) handleAddMember() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "group_member_add_propose_failed", "err", err, "group_id", groupID)
 				http.Error(w, "failed to replicate member add", http.StatusInternalServerError)
 				return
@@ -627,7 +688,7 @@ func (a *API) handleCreateAppointment() http.HandlerFunc {
 		}
 		var payload map[string]any
 		if in.GroupID != nil {
-			created, parts, err := a.apps.CreateGroupAppointment(uid, appt)
+			created, parts, err := a.appsFor(r).CreateGroupAppointment(uid, appt)
 			if err != nil {
 				a.log(ctx, slog.LevelWarn, "appointment_create_group_failed", "err", err)
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -636,7 +697,7 @@ func (a *API) handleCreateAppointment() http.HandlerFunc {
 			json.NewEncoder(w).Encode(map[string]interface{}{"appointment": created, "participants": parts})
 			payload = map[string]any{"appointment_id": created.ID, "group_id": in.GroupID}
 		} else {
-			created, err := a.apps.CreatePersonalAppointment(uid, appt)
+			created, err := a.appsFor(r).CreatePersonalAppointment(uid, appt)
 			if err != nil {
 				a.log(ctx, slog.LevelWarn, "appointment_create_personal_failed", "err", err)
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -961,7 +1022,7 @@ func (a *API) handleUpdateAppointment() http.HandlerFunc {
 			GroupID:     in.GroupID,
 		}
 
-		updated, err := a.apps.UpdateAppointment(userID, appointment)
+		updated, err := a.appsFor(r).UpdateAppointment(userID, appointment)
 		if err != nil {
 			a.log(ctx, slog.LevelError, "appointment_update_failed", "err", err, "appointment_id", appointmentID)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -997,7 +1058,7 @@ func (a *API) handleDeleteAppointment() http.HandlerFunc {
 			return
 		}
 
-		err := a.apps.DeleteAppointment(userID, appointmentID)
+		err := a.appsFor(r).DeleteAppointment(userID, appointmentID)
 		if err != nil {
 			a.log(ctx, slog.LevelError, "appointment_delete_failed", "err", err, "appointment_id", appointmentID)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -1077,7 +1138,7 @@ func (a *API) handleUpdateGroup() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "group_update_propose_failed", "err", err, "group_id", groupID)
 				http.Error(w, "failed to replicate group update", http.StatusInternalServerError)
 				return
@@ -1208,7 +1269,7 @@ func (a *API) handleUpdateMember() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "group_member_update_propose_failed", "err", err, "group_id", groupID)
 				http.Error(w, "failed to replicate member update", http.StatusInternalServerError)
 				return
@@ -1284,7 +1345,7 @@ func (a *API) handleRemoveMember() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "group_member_remove_propose_failed", "err", err, "group_id", groupID)
 				http.Error(w, "failed to replicate member remove", http.StatusInternalServerError)
 				return
@@ -1357,7 +1418,7 @@ func (a *API) handleAcceptInvitation() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "invitation_accept_propose_failed", "err", err, "appointment_id", appointmentID)
 				http.Error(w, "failed to replicate invitation accept", http.StatusInternalServerError)
 				return
@@ -1424,7 +1485,7 @@ func (a *API) handleRejectInvitation() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "invitation_reject_propose_failed", "err", err, "appointment_id", appointmentID)
 				http.Error(w, "failed to replicate invitation reject", http.StatusInternalServerError)
 				return
@@ -1552,7 +1613,7 @@ func (a *API) handleUpdateProfile() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "profile_update_propose_failed", "err", err, "user_id", userID)
 				http.Error(w, "failed to replicate profile update", http.StatusInternalServerError)
 				return
@@ -1632,7 +1693,7 @@ func (a *API) handleUpdatePassword() http.HandlerFunc {
 				http.Error(w, "internal error", http.StatusInternalServerError)
 				return
 			}
-			if err := a.cons.Propose(entry); err != nil {
+			if _, err := a.propose(r, entry); proposeFailed(err) {
 				a.log(ctx, slog.LevelError, "password_update_propose_failed", "err", err, "user_id", userID)
 				http.Error(w, "failed to replicate password update", http.StatusInternalServerError)
 				return
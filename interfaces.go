@@ -1,7 +1,10 @@
 // interfaces.go
 package agendadistribuida
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Repositories define data persistence contracts. They should be pure CRUD-ish.
 // Business rules belong in services, not here.
@@ -94,17 +97,44 @@ type RequestVoteRequest struct {
 }
 
 type RequestVoteResponse struct {
-	Term        int64 `json:"term"`
-	VoteGranted bool  `json:"vote_granted"`
+	Term        int64  `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+	LeaderID    string `json:"leader_id,omitempty"` // leader the voter follows in Term, if known
+}
+
+// InstallSnapshotRequest ships the leader's latest snapshot to a follower whose
+// nextIndex falls inside the compacted prefix of the log. The whole snapshot is
+// sent in a single message.
+type InstallSnapshotRequest struct {
+	Term              int64          `json:"term"`
+	LeaderID          string         `json:"leader_id"`
+	LastIncludedIndex int64          `json:"last_included_index"`
+	LastIncludedTerm  int64          `json:"last_included_term"`
+	Data              []byte         `json:"data"`
+	Config            *ClusterConfig `json:"config,omitempty"`
+}
+
+type InstallSnapshotResponse struct {
+	Term    int64 `json:"term"`
+	Success bool  `json:"success"`
 }
 
 type Consensus interface {
 	NodeID() string
 	IsLeader() bool
 	LeaderID() string
-	Propose(entry LogEntry) error
+	// Propose replicates entry and returns what the state machine produced
+	// when applying it, or its error.
+	Propose(entry LogEntry) (ApplyResult, error)
 	HandleAppendEntries(req AppendEntriesRequest) (AppendEntriesResponse, error)
 	HandleRequestVote(req RequestVoteRequest) (RequestVoteResponse, error)
+	HandleInstallSnapshot(req InstallSnapshotRequest) (InstallSnapshotResponse, error)
+	// ReadIndex blocks until local state reflects every write committed
+	// before the call (linearizable read barrier) and returns the read index.
+	ReadIndex(ctx context.Context) (int64, error)
+	// SessionResult returns the cached outcome of a client request that was
+	// already applied (see raft_sessions.go).
+	SessionResult(req ClientRequest) (res ApplyResult, found bool, err error)
 	Start() error
 	Stop() error
 }
@@ -112,9 +142,13 @@ type Consensus interface {
 type PeerStore interface {
 	LocalID() string
 	ListPeers() []string
-	SetLeader(id string)
+	// SetLeader records a term-stamped leader hint; hints older than the
+	// recorded term are ignored.
+	SetLeader(id string, term int64) bool
 	GetLeader() string
+	Leader() (id string, term int64)
 	ResolveAddr(id string) string
+	IsLearner(id string) bool
 }
 
 // Services define business use-cases. They compose repositories and infrastructure.
@@ -147,6 +181,9 @@ type AppointmentService interface {
 	GetAppointmentParticipants(appointmentID string) ([]ParticipantDetails, error)
 	// Wiring de consenso (permitir inyectarlo desde main)
 	SetConsensus(c Consensus)
+	// WithClientRequest devuelve el servicio ligado a una sesión de cliente,
+	// para que los reintentos se apliquen una sola vez
+	WithClientRequest(req ClientRequest) AppointmentService
 }
 
 type AgendaService interface {
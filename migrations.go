@@ -0,0 +1,322 @@
+// migrations.go
+package agendadistribuida
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is one ordered, forward-only schema step. Steps are applied inside
+// a transaction and recorded in schema_migrations, so a restart only runs the
+// versions that are still missing and existing data (including raft_log and
+// raft_meta) is preserved.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// execSQL builds a migration step from a plain SQL script.
+func execSQL(script string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+// migrations must stay sorted by version. Never edit a released step: append a
+// new one instead.
+var migrations = []migration{
+	{version: 1, name: "initial_schema", up: execSQL(schemaV1)},
+	{version: 2, name: "raft_snapshot", up: execSQL(schemaV2RaftSnapshot)},
+	{version: 3, name: "cluster_node_learner", up: execSQL(schemaV3ClusterNodeLearner)},
+	{version: 4, name: "raft_client_sessions", up: execSQL(schemaV4RaftClientSessions)},
+	{version: 5, name: "raft_payload_version", up: execSQL(schemaV5RaftPayloadVersion)},
+	{version: 6, name: "raft_changes", up: execSQL(schemaV6RaftChanges)},
+	{version: 7, name: "raft_sessions_idx", up: execSQL(schemaV7RaftSessionsIdx)},
+}
+
+// ====================
+// Migraciones
+// ====================
+func (s *Storage) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at DATETIME NOT NULL
+)`); err != nil {
+		return err
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	last := 0
+	for _, m := range migrations {
+		if m.version <= last {
+			return fmt.Errorf("migration %d (%s) is out of order", m.version, m.name)
+		}
+		last = m.version
+		if applied[m.version] {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		Logger().Info("schema_migration_applied", "version", m.version, "name", m.name)
+	}
+	return nil
+}
+
+func (s *Storage) appliedMigrations() (map[int]bool, error) {
+	rows, err := s.db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out[v] = true
+	}
+	return out, rows.Err()
+}
+
+func (s *Storage) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := m.up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations(version, name, applied_at) VALUES(?,?,?)`,
+		m.version, m.name, time.Now()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the highest applied migration version.
+func (s *Storage) SchemaVersion() (int, error) {
+	var v sql.NullInt64
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&v); err != nil {
+		return 0, err
+	}
+	return int(v.Int64), nil
+}
+
+// schemaV1 is the original schema. It only uses IF NOT EXISTS so databases
+// created before schema_migrations existed are adopted without changes.
+const schemaV1 = `
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	email TEXT UNIQUE,
+	password_hash TEXT NOT NULL,
+	display_name TEXT,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    creator_id TEXT,
+    creator_username TEXT,
+    group_type TEXT NOT NULL DEFAULT 'hierarchical'
+);
+
+CREATE TABLE IF NOT EXISTS group_members (
+	group_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	rank INTEGER NOT NULL DEFAULT 0,
+	added_by TEXT,
+	created_at DATETIME NOT NULL,
+	PRIMARY KEY (group_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS appointments (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	description TEXT,
+	owner_id TEXT NOT NULL,
+	group_id TEXT,
+	start_ts INTEGER NOT NULL,
+	end_ts INTEGER NOT NULL,
+	privacy TEXT NOT NULL,
+	status TEXT NOT NULL,
+	version INTEGER DEFAULT 1,
+	origin_node TEXT,
+	deleted INTEGER DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS participants (
+	id TEXT PRIMARY KEY,
+	appointment_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	status TEXT NOT NULL,
+	is_optional INTEGER DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS participants_appt_user_uniq ON participants(appointment_id, user_id);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	payload TEXT,
+	read_at DATETIME,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	entity TEXT NOT NULL,
+	entity_id TEXT NOT NULL,
+	action TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	origin_node TEXT,
+	version INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS cluster_nodes (
+    node_id TEXT PRIMARY KEY,
+    address TEXT NOT NULL,
+    source TEXT,
+    last_seen DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS cluster_nodes_last_seen_idx ON cluster_nodes(last_seen);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    component TEXT NOT NULL,
+    action TEXT NOT NULL,
+    level TEXT NOT NULL,
+    message TEXT NOT NULL,
+    actor_id TEXT,
+    request_id TEXT,
+    node_id TEXT,
+    payload TEXT,
+    occurred_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_component_idx ON audit_logs(component, action);
+
+-- Raft / Consenso: log replicado y metadatos persistentes
+CREATE TABLE IF NOT EXISTS raft_log (
+    term INTEGER NOT NULL,
+    idx INTEGER NOT NULL,
+    event_id TEXT UNIQUE,
+    aggregate TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    op TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    ts DATETIME NOT NULL,
+    PRIMARY KEY(term, idx)
+);
+
+CREATE INDEX IF NOT EXISTS raft_log_idx ON raft_log(idx);
+
+CREATE TABLE IF NOT EXISTS raft_meta (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS raft_applied (
+    event_id TEXT PRIMARY KEY,
+    idx INTEGER NOT NULL,
+    applied_at DATETIME NOT NULL
+);
+
+-- Inicialización básica de claves si no existen
+INSERT OR IGNORE INTO raft_meta(key, value) VALUES
+    ('currentTerm', '0'),
+    ('votedFor', ''),
+    ('commitIndex', '0'),
+    ('lastApplied', '0');
+`
+
+const schemaV2RaftSnapshot = `
+-- Último snapshot del estado (una sola fila); el log por debajo de last_index se compacta
+CREATE TABLE IF NOT EXISTS raft_snapshot (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_index INTEGER NOT NULL,
+    last_term INTEGER NOT NULL,
+    data BLOB NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+INSERT OR IGNORE INTO raft_meta(key, value) VALUES
+    ('snapshotIndex', '0'),
+    ('snapshotTerm', '0');
+`
+
+const schemaV3ClusterNodeLearner = `
+-- Nodos learner (réplicas sin voto)
+ALTER TABLE cluster_nodes ADD COLUMN learner INTEGER NOT NULL DEFAULT 0;
+`
+
+const schemaV4RaftClientSessions = `
+-- Sesiones de cliente: cada entrada del log puede llevar (client_id, seq)
+ALTER TABLE raft_log ADD COLUMN client_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE raft_log ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+
+-- Última petición aplicada por cliente y su resultado, para responder a los reintentos
+CREATE TABLE IF NOT EXISTS raft_sessions (
+    client_id TEXT PRIMARY KEY,
+    seq INTEGER NOT NULL,
+    idx INTEGER NOT NULL,
+    result TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME NOT NULL
+);
+`
+
+const schemaV5RaftPayloadVersion = `
+-- Versión del esquema del payload de cada entrada; 0 = anterior al versionado
+ALTER TABLE raft_log ADD COLUMN payload_version INTEGER NOT NULL DEFAULT 0;
+`
+
+const schemaV6RaftChanges = `
+-- Cambios confirmados y aplicados, para consumidores externos (CDC); local a cada nodo
+CREATE TABLE IF NOT EXISTS raft_changes (
+    idx INTEGER PRIMARY KEY,
+    term INTEGER NOT NULL,
+    event_id TEXT NOT NULL,
+    aggregate TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    op TEXT NOT NULL,
+    payload_version INTEGER NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    entity_id TEXT NOT NULL DEFAULT '',
+    ts DATETIME NOT NULL,
+    applied_at DATETIME NOT NULL
+);
+
+-- Los cambios hasta changesFloor (incluido) no están disponibles en este nodo
+INSERT OR IGNORE INTO raft_meta(key, value)
+    SELECT 'changesFloor', value FROM raft_meta WHERE key = 'lastApplied';
+`
+
+const schemaV7RaftSessionsIdx = `
+-- Las sesiones caducan por índice del log (RAFT_SESSION_RETENTION)
+CREATE INDEX IF NOT EXISTS raft_sessions_idx_idx ON raft_sessions(idx);
+`
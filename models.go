@@ -111,6 +111,11 @@ type LogEntry struct {
 	Op          string    `json:"op" db:"op"`
 	Payload     string    `json:"payload" db:"payload"` // JSON serializado del cambio de dominio
 	Timestamp   time.Time `json:"ts" db:"ts"`
+	// Sesión de cliente (opcional): una misma (ClientID, Seq) se aplica una sola vez
+	ClientID string `json:"client_id,omitempty" db:"client_id"`
+	Seq      int64  `json:"seq,omitempty" db:"seq"`
+	// Versión del esquema del payload (0: entrada anterior al versionado, ver raft_upcast.go)
+	Version int `json:"payload_version,omitempty" db:"payload_version"`
 }
 
 // RaftState contiene el estado persistente y volátil mínimo para el nodo
@@ -120,6 +125,9 @@ type RaftState struct {
 	VotedFor    string `json:"voted_for"`
 	CommitIndex int64  `json:"commit_index"`
 	LastApplied int64  `json:"last_applied"`
+	// Último snapshot: el log con idx <= SnapshotIndex puede estar compactado.
+	SnapshotIndex int64 `json:"snapshot_index"`
+	SnapshotTerm  int64 `json:"snapshot_term"`
 }
 
 type Event struct {
@@ -160,6 +168,9 @@ type ClusterNode struct {
 	Address  string    `json:"address" db:"address"`
 	Source   string    `json:"source" db:"source"`
 	LastSeen time.Time `json:"last_seen" db:"last_seen"`
+	// Learner marca réplicas de solo lectura: reciben el log pero no votan
+	// ni cuentan para el quórum.
+	Learner bool `json:"learner" db:"learner"`
 }
 
 // AuditLog stores immutable operational events for troubleshooting.
@@ -3,14 +3,16 @@ package agendadistribuida
 import "sync"
 
 type EnvPeerStore struct {
-	mu      sync.RWMutex
-	localID string
-	peers   map[string]string // nodeID -> address
-	leader  string
+	mu         sync.RWMutex
+	localID    string
+	peers      map[string]string // nodeID -> address
+	learners   map[string]bool   // non-voting peers (still receive the log)
+	leader     string
+	leaderTerm int64 // term leader was learned for
 }
 
 func NewEnvPeerStore(localID string, peers []string) *EnvPeerStore {
-	store := &EnvPeerStore{localID: localID, peers: map[string]string{}}
+	store := &EnvPeerStore{localID: localID, peers: map[string]string{}, learners: map[string]bool{}}
 	store.SetPeers(peers)
 	return store
 }
@@ -27,8 +29,27 @@ func (p *EnvPeerStore) ListPeers() []string {
 	return ids
 }
 
-func (p *EnvPeerStore) SetLeader(id string) { p.mu.Lock(); p.leader = id; p.mu.Unlock() }
-func (p *EnvPeerStore) GetLeader() string   { p.mu.RLock(); defer p.mu.RUnlock(); return p.leader }
+// SetLeader records id ("" for unknown) as the leader of term. Hints for a
+// term older than the recorded one are stale and ignored; it reports whether
+// the hint was taken.
+func (p *EnvPeerStore) SetLeader(id string, term int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if term < p.leaderTerm {
+		return false
+	}
+	p.leader, p.leaderTerm = id, term
+	return true
+}
+
+func (p *EnvPeerStore) GetLeader() string { p.mu.RLock(); defer p.mu.RUnlock(); return p.leader }
+
+// Leader returns the recorded leader and the term it leads.
+func (p *EnvPeerStore) Leader() (string, int64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.leader, p.leaderTerm
+}
 
 func (p *EnvPeerStore) ResolveAddr(id string) string {
 	p.mu.RLock()
@@ -87,5 +108,27 @@ func (p *EnvPeerStore) RemovePeer(id string) {
 	}
 	p.mu.Lock()
 	delete(p.peers, id)
+	delete(p.learners, id)
 	p.mu.Unlock()
 }
+
+// SetLearner marks id (possibly the local node) as learner or voter.
+func (p *EnvPeerStore) SetLearner(id string, learner bool) {
+	if id == "" {
+		return
+	}
+	p.mu.Lock()
+	if learner {
+		p.learners[id] = true
+	} else {
+		delete(p.learners, id)
+	}
+	p.mu.Unlock()
+}
+
+// IsLearner reports whether id is a non-voting member.
+func (p *EnvPeerStore) IsLearner(id string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.learners[id]
+}
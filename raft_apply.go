@@ -70,23 +70,29 @@ type userCreatePayload struct {
 	DisplayName  string `json:"display_name"`
 }
 
+// The appointment id derives from the owner's username. Since version 2 the
+// proposer may resolve it into OwnerUsername, so that applying does not read
+// the owner row (with shards the meta group may not have applied it yet);
+// without it the owner is looked up as in version 1.
 type apptCreatePayload struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	OwnerID     string    `json:"owner_id"`
-	Start       time.Time `json:"start"`
-	End         time.Time `json:"end"`
-	Privacy     Privacy   `json:"privacy"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	OwnerID       string    `json:"owner_id"`
+	OwnerUsername string    `json:"owner_username,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Privacy       Privacy   `json:"privacy"`
 }
 
 type apptCreateGroupPayload struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	OwnerID     string    `json:"owner_id"`
-	GroupID     string    `json:"group_id"`
-	Start       time.Time `json:"start"`
-	End         time.Time `json:"end"`
-	Privacy     Privacy   `json:"privacy"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	OwnerID       string    `json:"owner_id"`
+	OwnerUsername string    `json:"owner_username,omitempty"`
+	GroupID       string    `json:"group_id"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Privacy       Privacy   `json:"privacy"`
 }
 
 type apptUpdatePayload struct {
@@ -144,43 +150,51 @@ type invitationStatusPayload struct {
 	Status        ApptStatus `json:"status"`
 }
 
-func NewRaftApplier(store *Storage) func(LogEntry) error {
-	return func(e LogEntry) error {
-		switch e.Op {
-		case OpApptCreatePersonal:
-			var p apptCreatePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			if existingID, err := store.FindAppointmentBySignature(p.OwnerID, nil, p.Start, p.End, p.Title); err == nil && existingID != "" {
-				if _, err := store.GetParticipantByAppointmentAndUser(existingID, p.OwnerID); err == nil {
-					return nil
-				}
-				part := &Participant{AppointmentID: existingID, UserID: p.OwnerID, Status: StatusAccepted}
-				if err := store.AddParticipant(part); err != nil {
-					if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-						return nil
-					}
-					return err
-				}
-				return nil
-			}
-			a := &Appointment{
-				Title:       p.Title,
-				Description: p.Description,
-				OwnerID:     p.OwnerID,
-				Start:       p.Start,
-				End:         p.End,
-				Privacy:     p.Privacy,
-				Status:      StatusAccepted,
-			}
-			if err := store.CreateAppointment(a); err != nil {
-				return err
-			}
-			part := &Participant{AppointmentID: a.ID, UserID: p.OwnerID, Status: StatusAccepted}
-			if _, err := store.GetParticipantByAppointmentAndUser(a.ID, p.OwnerID); err == nil {
+// SQLiteStateMachine is the agenda state machine: it applies committed log
+// entries to the SQLite tables and snapshots them (see stateMachineTables).
+type SQLiteStateMachine struct {
+	store *Storage
+	// tables restricts snapshots and digests to some of the
+	// stateMachineTables (nil: all of them).
+	tables []string
+}
+
+func NewSQLiteStateMachine(store *Storage) *SQLiteStateMachine {
+	return &SQLiteStateMachine{store: store}
+}
+
+// newMetaStateMachine is the state machine of the meta group of a MultiRaft,
+// which snapshots and compares only the metaTables.
+func newMetaStateMachine(store *Storage) *SQLiteStateMachine {
+	return &SQLiteStateMachine{store: store, tables: metaTables}
+}
+
+// Apply mutates SQLite according to e. Replays of an already applied entry are
+// no-ops that still report the affected entity.
+func (m *SQLiteStateMachine) Apply(e LogEntry) (ApplyResult, error) {
+	res := ApplyResult{Index: e.Index, Op: e.Op}
+	e, err := upcastEntry(e)
+	if err != nil {
+		return res, err
+	}
+	err = m.apply(e, &res)
+	return res, err
+}
+
+func (m *SQLiteStateMachine) apply(e LogEntry, res *ApplyResult) error {
+	store := m.store
+	switch e.Op {
+	case OpApptCreatePersonal:
+		var p apptCreatePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		if existingID, err := store.FindAppointmentBySignature(p.OwnerID, nil, p.Start, p.End, p.Title); err == nil && existingID != "" {
+			res.ID = existingID
+			if _, err := store.GetParticipantByAppointmentAndUser(existingID, p.OwnerID); err == nil {
 				return nil
 			}
+			part := &Participant{AppointmentID: existingID, UserID: p.OwnerID, Status: StatusAccepted}
 			if err := store.AddParticipant(part); err != nil {
 				if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 					return nil
@@ -188,350 +202,396 @@ func NewRaftApplier(store *Storage) func(LogEntry) error {
 				return err
 			}
 			return nil
-		case OpRepairEnsureUser:
-			var p repairEnsureUserPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			// Safeguard: do not create users with empty password hash from repairs
-			if p.PasswordHash == "" {
-				if u, err := store.GetUserByUsername(p.Username); err != nil || u == nil {
-					// User does not exist, and we have no password. Do not create it.
-					// Return a non-fatal error to avoid poisoning the log.
-					return errors.New("apply conflict: repair.user.ensure skipped for new user with empty password hash")
-				}
-			}
-			u := &User{
-				ID:           p.ID,
-				Username:     p.Username,
-				Email:        p.Email,
-				PasswordHash: p.PasswordHash,
-				DisplayName:  p.DisplayName,
-			}
-			return store.EnsureUser(u)
-		case OpApptCreateGroup:
-			var p apptCreateGroupPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			if existingID, err := store.FindAppointmentBySignature(p.OwnerID, &p.GroupID, p.Start, p.End, p.Title); err == nil && existingID != "" {
+		}
+		a := &Appointment{
+			Title:       p.Title,
+			Description: p.Description,
+			OwnerID:     p.OwnerID,
+			Start:       p.Start,
+			End:         p.End,
+			Privacy:     p.Privacy,
+			Status:      StatusAccepted,
+		}
+		if p.OwnerUsername != "" {
+			a.ID = AppointmentIDFromSignature(p.OwnerUsername, "", p.Start, p.End, p.Title)
+		}
+		if err := store.CreateAppointment(a); err != nil {
+			return err
+		}
+		res.ID = a.ID
+		part := &Participant{AppointmentID: a.ID, UserID: p.OwnerID, Status: StatusAccepted}
+		if _, err := store.GetParticipantByAppointmentAndUser(a.ID, p.OwnerID); err == nil {
+			return nil
+		}
+		if err := store.AddParticipant(part); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 				return nil
 			}
-			gID := p.GroupID
-			a := &Appointment{
-				Title:       p.Title,
-				Description: p.Description,
-				OwnerID:     p.OwnerID,
-				GroupID:     &gID,
-				Start:       p.Start,
-				End:         p.End,
-				Privacy:     p.Privacy,
-				Status:      StatusPending,
-			}
-			// This will insert the appointment, compute participants based on group membership
-			// and create the corresponding invite notifications on every node.
-			_, err := store.CreateGroupAppointment(a)
 			return err
-		case OpApptUpdate:
-			var p apptUpdatePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			a, err := store.GetAppointmentByID(p.AppointmentID)
-			if err != nil {
-				return err
-			}
-			if p.Title != nil {
-				a.Title = *p.Title
-			}
-			if p.Description != nil {
-				a.Description = *p.Description
-			}
-			if p.Start != nil {
-				a.Start = *p.Start
-			}
-			if p.End != nil {
-				a.End = *p.End
-			}
-			if p.Privacy != nil {
-				a.Privacy = *p.Privacy
-			}
-			return store.UpdateAppointment(a)
-		case OpApptDelete:
-			var p apptDeletePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			return store.DeleteAppointment(p.AppointmentID)
-		case OpUserCreate:
-			var p userCreatePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			// Idempotency / safety: if the user already exists, treat as success.
-			if existing, err := store.GetUserByUsername(p.Username); err == nil && existing != nil {
-				return nil
+		}
+		return nil
+	case OpRepairEnsureUser:
+		var p repairEnsureUserPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		// Safeguard: do not create users with empty password hash from repairs
+		if p.PasswordHash == "" {
+			if u, err := store.GetUserByUsername(p.Username); err != nil || u == nil {
+				// User does not exist, and we have no password. Do not create it.
+				// Return a non-fatal error to avoid poisoning the log.
+				return errors.New("apply conflict: repair.user.ensure skipped for new user with empty password hash")
 			}
-			// If email is already taken by a different user, this is a real conflict.
-			if p.Email != "" {
-				if byEmail, err := store.GetUserByEmail(p.Email); err == nil && byEmail != nil {
-					if byEmail.Username == p.Username {
-						return nil
-					}
-					return errors.New("user.create apply conflict: email already exists")
+		}
+		u := &User{
+			ID:           p.ID,
+			Username:     p.Username,
+			Email:        p.Email,
+			PasswordHash: p.PasswordHash,
+			DisplayName:  p.DisplayName,
+		}
+		return store.EnsureUser(u)
+	case OpApptCreateGroup:
+		var p apptCreateGroupPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		if existingID, err := store.FindAppointmentBySignature(p.OwnerID, &p.GroupID, p.Start, p.End, p.Title); err == nil && existingID != "" {
+			res.ID = existingID
+			return nil
+		}
+		gID := p.GroupID
+		a := &Appointment{
+			Title:       p.Title,
+			Description: p.Description,
+			OwnerID:     p.OwnerID,
+			GroupID:     &gID,
+			Start:       p.Start,
+			End:         p.End,
+			Privacy:     p.Privacy,
+			Status:      StatusPending,
+		}
+		if p.OwnerUsername != "" {
+			a.ID = AppointmentIDFromSignature(p.OwnerUsername, gID, p.Start, p.End, p.Title)
+		}
+		// This will insert the appointment, compute participants based on group membership
+		// and create the corresponding invite notifications on every node.
+		parts, err := store.CreateGroupAppointment(a)
+		if err != nil {
+			return err
+		}
+		res.ID = a.ID
+		res.Participants = parts
+		return nil
+	case OpApptUpdate:
+		var p apptUpdatePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		res.ID = p.AppointmentID
+		a, err := store.GetAppointmentByID(p.AppointmentID)
+		if err != nil {
+			return err
+		}
+		if p.Title != nil {
+			a.Title = *p.Title
+		}
+		if p.Description != nil {
+			a.Description = *p.Description
+		}
+		if p.Start != nil {
+			a.Start = *p.Start
+		}
+		if p.End != nil {
+			a.End = *p.End
+		}
+		if p.Privacy != nil {
+			a.Privacy = *p.Privacy
+		}
+		return store.UpdateAppointment(a)
+	case OpApptDelete:
+		var p apptDeletePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		res.ID = p.AppointmentID
+		return store.DeleteAppointment(p.AppointmentID)
+	case OpUserCreate:
+		var p userCreatePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		// Idempotency / safety: if the user already exists, treat as success.
+		if existing, err := store.GetUserByUsername(p.Username); err == nil && existing != nil {
+			res.ID = existing.ID
+			return nil
+		}
+		// If email is already taken by a different user, this is a real conflict.
+		if p.Email != "" {
+			if byEmail, err := store.GetUserByEmail(p.Email); err == nil && byEmail != nil {
+				if byEmail.Username == p.Username {
+					res.ID = byEmail.ID
+					return nil
 				}
+				return errors.New("user.create apply conflict: email already exists")
 			}
-			u := &User{
-				Username:     p.Username,
-				Email:        p.Email,
-				PasswordHash: p.PasswordHash,
-				ID:           p.ID,
-				DisplayName:  p.DisplayName,
-			}
-			if err := store.CreateUser(u); err != nil {
-				// If we lost a race or the row already exists, treat as success.
-				if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-					if existing, gerr := store.GetUserByUsername(p.Username); gerr == nil && existing != nil {
-						return nil
-					}
-					if p.Email != "" {
-						if byEmail, gerr := store.GetUserByEmail(p.Email); gerr == nil && byEmail != nil {
-							if byEmail.Username == p.Username {
-								return nil
-							}
-						}
-					}
+		}
+		u := &User{
+			Username:     p.Username,
+			Email:        p.Email,
+			PasswordHash: p.PasswordHash,
+			ID:           p.ID,
+			DisplayName:  p.DisplayName,
+		}
+		if err := store.CreateUser(u); err != nil {
+			// If we lost a race or the row already exists, treat as success.
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				if existing, gerr := store.GetUserByUsername(p.Username); gerr == nil && existing != nil {
+					res.ID = existing.ID
+					return nil
 				}
-				return err
-			}
-			return nil
-		case OpUserUpdateProfile:
-			var p userUpdateProfilePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			u, err := store.GetUserByID(p.UserID)
-			if err != nil {
-				return err
-			}
-			desired := *u
-			if p.Username != nil {
-				desired.Username = *p.Username
-			}
-			if p.Email != nil {
-				desired.Email = *p.Email
-			}
-			if p.DisplayName != nil {
-				desired.DisplayName = *p.DisplayName
-			}
-			// Idempotency: if already at desired state, treat as success.
-			if desired.Username == u.Username && desired.Email == u.Email && desired.DisplayName == u.DisplayName {
-				return nil
-			}
-			u.Username = desired.Username
-			u.Email = desired.Email
-			u.DisplayName = desired.DisplayName
-			if err := store.UpdateUser(u); err != nil {
-				// If this fails due to UNIQUE constraints, re-check if state is already applied.
-				if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-					if fresh, gerr := store.GetUserByID(p.UserID); gerr == nil && fresh != nil {
-						if fresh.Username == desired.Username && fresh.Email == desired.Email && fresh.DisplayName == desired.DisplayName {
+				if p.Email != "" {
+					if byEmail, gerr := store.GetUserByEmail(p.Email); gerr == nil && byEmail != nil {
+						if byEmail.Username == p.Username {
+							res.ID = byEmail.ID
 							return nil
 						}
 					}
-					return errors.New("user.update_profile apply conflict: unique constraint")
 				}
-				return err
 			}
+			return err
+		}
+		res.ID = u.ID
+		return nil
+	case OpUserUpdateProfile:
+		var p userUpdateProfilePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		u, err := store.GetUserByID(p.UserID)
+		if err != nil {
+			return err
+		}
+		desired := *u
+		if p.Username != nil {
+			desired.Username = *p.Username
+		}
+		if p.Email != nil {
+			desired.Email = *p.Email
+		}
+		if p.DisplayName != nil {
+			desired.DisplayName = *p.DisplayName
+		}
+		// Idempotency: if already at desired state, treat as success.
+		if desired.Username == u.Username && desired.Email == u.Email && desired.DisplayName == u.DisplayName {
 			return nil
-		case OpUserUpdatePassword:
-			var p userUpdatePasswordPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			// Idempotency: if password hash already matches, treat as success.
-			if u, err := store.GetUserByID(p.UserID); err == nil && u != nil {
-				if u.PasswordHash == p.PasswordHash {
-					return nil
+		}
+		u.Username = desired.Username
+		u.Email = desired.Email
+		u.DisplayName = desired.DisplayName
+		if err := store.UpdateUser(u); err != nil {
+			// If this fails due to UNIQUE constraints, re-check if state is already applied.
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				if fresh, gerr := store.GetUserByID(p.UserID); gerr == nil && fresh != nil {
+					if fresh.Username == desired.Username && fresh.Email == desired.Email && fresh.DisplayName == desired.DisplayName {
+						return nil
+					}
 				}
+				return errors.New("user.update_profile apply conflict: unique constraint")
 			}
-			return store.UpdatePassword(p.UserID, p.PasswordHash)
-		case OpGroupCreate:
-			var p groupCreatePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			// Idempotency: check if group already exists by its deterministic signature.
-			gid := GroupIDFromSignature(p.GroupType, p.CreatorUser, p.Name)
-			if existing, err := store.GetGroupByID(gid); err == nil && existing != nil {
-				// Group exists, this is a no-op. Return nil to mark as applied.
+			return err
+		}
+		return nil
+	case OpUserUpdatePassword:
+		var p userUpdatePasswordPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		// Idempotency: if password hash already matches, treat as success.
+		if u, err := store.GetUserByID(p.UserID); err == nil && u != nil {
+			if u.PasswordHash == p.PasswordHash {
 				return nil
 			}
-			g := &Group{
-				Name:            p.Name,
-				Description:     p.Description,
-				CreatorID:       p.CreatorID,
-				CreatorUserName: p.CreatorUser,
-				GroupType:       p.GroupType,
-			}
-			if err := store.CreateGroup(g); err != nil {
-				// If we lost a race, and it now exists, treat as success.
-				if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-					return nil
-				}
-				return err
-			}
-			// Owner rank depends on group type: hierarchical -> higher rank, non_hierarchical -> 0
-			ownerRank := 5
-			if p.GroupType == GroupTypeNonHierarchical {
-				ownerRank = 0
-			}
-			return store.AddGroupMember(g.ID, p.CreatorID, ownerRank, nil)
-		case OpGroupUpdate:
-			var p groupUpdatePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			g, err := store.GetGroupByID(p.GroupID)
-			if err != nil {
-				return err
-			}
-			if p.Name != nil {
-				g.Name = *p.Name
-			}
-			if p.Description != nil {
-				g.Description = *p.Description
-			}
-			return store.UpdateGroup(g)
-		case OpGroupDelete:
-			var p groupDeletePayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			if _, err := store.GetGroupByID(p.GroupID); err != nil {
+		}
+		return store.UpdatePassword(p.UserID, p.PasswordHash)
+	case OpGroupCreate:
+		var p groupCreatePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		// Idempotency: check if group already exists by its deterministic signature.
+		gid := GroupIDFromSignature(p.GroupType, p.CreatorUser, p.Name)
+		if existing, err := store.GetGroupByID(gid); err == nil && existing != nil {
+			// Group exists, this is a no-op. Return nil to mark as applied.
+			res.ID = existing.ID
+			return nil
+		}
+		g := &Group{
+			Name:            p.Name,
+			Description:     p.Description,
+			CreatorID:       p.CreatorID,
+			CreatorUserName: p.CreatorUser,
+			GroupType:       p.GroupType,
+		}
+		if err := store.CreateGroup(g); err != nil {
+			// If we lost a race, and it now exists, treat as success.
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				res.ID = gid
 				return nil
 			}
-			return store.DeleteGroup(p.GroupID)
-		case OpGroupMemberAdd:
-			var p groupMemberPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			return store.AddGroupMember(p.GroupID, p.UserID, p.Rank, nil)
-		case OpGroupMemberUpdate:
-			var p groupMemberPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			return store.UpdateGroupMember(p.GroupID, p.UserID, p.Rank)
-		case OpGroupMemberRemove:
-			var p groupMemberPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			if err := store.RemoveGroupMember(p.GroupID, p.UserID); err != nil {
-				// Idempotency: removing a non-existing member is treated as success.
-				if strings.Contains(err.Error(), "member not found") {
-					return nil
-				}
-				return err
-			}
+			return err
+		}
+		res.ID = g.ID
+		// Owner rank depends on group type: hierarchical -> higher rank, non_hierarchical -> 0
+		ownerRank := 5
+		if p.GroupType == GroupTypeNonHierarchical {
+			ownerRank = 0
+		}
+		return store.AddGroupMember(g.ID, p.CreatorID, ownerRank, nil)
+	case OpGroupUpdate:
+		var p groupUpdatePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		g, err := store.GetGroupByID(p.GroupID)
+		if err != nil {
+			return err
+		}
+		if p.Name != nil {
+			g.Name = *p.Name
+		}
+		if p.Description != nil {
+			g.Description = *p.Description
+		}
+		return store.UpdateGroup(g)
+	case OpGroupDelete:
+		var p groupDeletePayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		if _, err := store.GetGroupByID(p.GroupID); err != nil {
 			return nil
-		case OpInvitationAccept, OpInvitationReject:
-			var p invitationStatusPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			// Idempotency: if participant already has this status, treat as success and
-			// avoid creating duplicate notifications.
-			if existing, err := store.GetParticipantByAppointmentAndUser(p.AppointmentID, p.UserID); err == nil && existing != nil {
-				if existing.Status == p.Status {
-					return nil
-				}
-			}
-			if err := store.UpdateParticipantStatus(p.AppointmentID, p.UserID, p.Status); err != nil {
-				return err
-			}
-			// Create notification for the appointment owner with enriched details
-			appointment, err := store.GetAppointmentByID(p.AppointmentID)
-			if err != nil || appointment == nil {
+		}
+		return store.DeleteGroup(p.GroupID)
+	case OpGroupMemberAdd:
+		var p groupMemberPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		return store.AddGroupMember(p.GroupID, p.UserID, p.Rank, nil)
+	case OpGroupMemberUpdate:
+		var p groupMemberPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		return store.UpdateGroupMember(p.GroupID, p.UserID, p.Rank)
+	case OpGroupMemberRemove:
+		var p groupMemberPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		if err := store.RemoveGroupMember(p.GroupID, p.UserID); err != nil {
+			// Idempotency: removing a non-existing member is treated as success.
+			if strings.Contains(err.Error(), "member not found") {
 				return nil
 			}
-			var userUsername, userDisplayName string
-			if user, err := store.GetUserByID(p.UserID); err == nil && user != nil {
-				userUsername = user.Username
-				userDisplayName = user.DisplayName
-			}
-			statusStr := "accepted"
-			if p.Status == StatusDeclined {
-				statusStr = "declined"
-			}
-			payload := struct {
-				AppointmentID string `json:"appointment_id"`
-				Title         string `json:"title"`
-				UserID        string `json:"user_id"`
-				UserUsername  string `json:"user_username"`
-				UserName      string `json:"user_display_name"`
-				Status        string `json:"status"`
-				Start         string `json:"start"`
-				End           string `json:"end"`
-			}{
-				AppointmentID: p.AppointmentID,
-				Title:         appointment.Title,
-				UserID:        p.UserID,
-				UserUsername:  userUsername,
-				UserName:      userDisplayName,
-				Status:        statusStr,
-				Start:         appointment.Start.Format(time.RFC3339),
-				End:           appointment.End.Format(time.RFC3339),
-			}
-			b, err := json.Marshal(payload)
-			if err != nil {
-				return err
-			}
-			noteType := "invitation_accepted"
-			if p.Status == StatusDeclined {
-				noteType = "invitation_declined"
-			}
-			return store.AddNotification(&Notification{
-				UserID:    appointment.OwnerID,
-				Type:      noteType,
-				Payload:   string(b),
-				CreatedAt: time.Now(),
-			})
-		case OpRepairUserClearEmailIfMatches:
-			var p repairUserClearEmailPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			return store.ClearUserEmailIfMatches(p.UserID, p.Email)
-		case OpRepairEnsureGroupMember:
-			var p repairEnsureGroupMemberPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			return store.EnsureGroupMember(p.GroupID, p.UserID, p.Rank, nil)
-		case OpRepairEnsureParticipant:
-			var p repairEnsureParticipantPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
-			}
-			if err := store.EnsureParticipant(p.AppointmentID, p.UserID, p.Status, p.IsOptional); err != nil {
-				if strings.Contains(err.Error(), "UNIQUE constraint failed") {
-					return nil
-				}
-				return err
+			return err
+		}
+		return nil
+	case OpInvitationAccept, OpInvitationReject:
+		var p invitationStatusPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		// Idempotency: if participant already has this status, treat as success and
+		// avoid creating duplicate notifications.
+		if existing, err := store.GetParticipantByAppointmentAndUser(p.AppointmentID, p.UserID); err == nil && existing != nil {
+			if existing.Status == p.Status {
+				return nil
 			}
+		}
+		if err := store.UpdateParticipantStatus(p.AppointmentID, p.UserID, p.Status); err != nil {
+			return err
+		}
+		// Create notification for the appointment owner with enriched details
+		appointment, err := store.GetAppointmentByID(p.AppointmentID)
+		if err != nil || appointment == nil {
 			return nil
-		case OpRepairEnsureNotification:
-			var p repairEnsureNotificationPayload
-			if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
-				return err
+		}
+		var userUsername, userDisplayName string
+		if user, err := store.GetUserByID(p.UserID); err == nil && user != nil {
+			userUsername = user.Username
+			userDisplayName = user.DisplayName
+		}
+		statusStr := "accepted"
+		if p.Status == StatusDeclined {
+			statusStr = "declined"
+		}
+		payload := struct {
+			AppointmentID string `json:"appointment_id"`
+			Title         string `json:"title"`
+			UserID        string `json:"user_id"`
+			UserUsername  string `json:"user_username"`
+			UserName      string `json:"user_display_name"`
+			Status        string `json:"status"`
+			Start         string `json:"start"`
+			End           string `json:"end"`
+		}{
+			AppointmentID: p.AppointmentID,
+			Title:         appointment.Title,
+			UserID:        p.UserID,
+			UserUsername:  userUsername,
+			UserName:      userDisplayName,
+			Status:        statusStr,
+			Start:         appointment.Start.Format(time.RFC3339),
+			End:           appointment.End.Format(time.RFC3339),
+		}
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		noteType := "invitation_accepted"
+		if p.Status == StatusDeclined {
+			noteType = "invitation_declined"
+		}
+		return store.AddNotification(&Notification{
+			UserID:    appointment.OwnerID,
+			Type:      noteType,
+			Payload:   string(b),
+			CreatedAt: time.Now(),
+		})
+	case OpRepairUserClearEmailIfMatches:
+		var p repairUserClearEmailPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		return store.ClearUserEmailIfMatches(p.UserID, p.Email)
+	case OpRepairEnsureGroupMember:
+		var p repairEnsureGroupMemberPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		return store.EnsureGroupMember(p.GroupID, p.UserID, p.Rank, nil)
+	case OpRepairEnsureParticipant:
+		var p repairEnsureParticipantPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		if err := store.EnsureParticipant(p.AppointmentID, p.UserID, p.Status, p.IsOptional); err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				return nil
 			}
-			return store.EnsureNotification(&Notification{UserID: p.UserID, Type: p.Type, Payload: p.Payload, CreatedAt: time.Now()})
-
-		default:
-			return errors.New("unsupported op: " + e.Op)
+			return err
 		}
+		return nil
+	case OpRepairEnsureNotification:
+		var p repairEnsureNotificationPayload
+		if err := json.Unmarshal([]byte(e.Payload), &p); err != nil {
+			return err
+		}
+		return store.EnsureNotification(&Notification{UserID: p.UserID, Type: p.Type, Payload: p.Payload, CreatedAt: time.Now()})
+
+	default:
+		return errors.New("unsupported op: " + e.Op)
 	}
 }
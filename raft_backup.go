@@ -0,0 +1,310 @@
+package agendadistribuida
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// --- online backup and seeding ---
+//
+// Backup copies the node's whole database with SQLite's backup API while the
+// apply loop is held, so the copy has the state machine exactly at
+// LastApplied. The copy is tagged in its raft_meta with that index and its
+// term (backupIndex, backupTerm), the node it came from and when.
+//
+// SeedFromBackup turns such a file into the database of a new node before it
+// starts: log entries after the backup index (possibly uncommitted) and the
+// source node's vote, peers, audit records and outbox are dropped, and the
+// commit and apply indexes are set to the backup index. The node then joins
+// like any other, and the leader only sends it the entries after the backup
+// (or a snapshot if it compacted them) instead of the whole log.
+
+// ErrDatabaseExists is returned by SeedFromBackup when the node already has a
+// database, so restarting a seeded node never overwrites it.
+var ErrDatabaseExists = errors.New("database already exists")
+
+// BackupInfo describes a backup file.
+type BackupInfo struct {
+	NodeID    string    `json:"node_id"`
+	Index     int64     `json:"index"` // last applied entry in the backup
+	Term      int64     `json:"term"`  // term of that entry
+	Path      string    `json:"path,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupRequest asks a node for a backup. Download streams the file back
+// instead of keeping it in the node's backup directory.
+type BackupRequest struct {
+	Download bool `json:"download,omitempty"`
+}
+
+// BackupDir is where backups requested over HTTP are written
+// (RAFT_BACKUP_DIR, default "backups").
+func BackupDir() string {
+	if dir := strings.TrimSpace(os.Getenv("RAFT_BACKUP_DIR")); dir != "" {
+		return dir
+	}
+	return "backups"
+}
+
+// backupFileName names the backup of nodeID at index and term.
+func backupFileName(nodeID string, index, term int64, at time.Time) string {
+	safe := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, nodeID)
+	return fmt.Sprintf("%s-%d-%d-%s.db", safe, index, term, at.UTC().Format("20060102T150405Z"))
+}
+
+// Backup writes a consistent copy of the database into dir and returns its
+// description. Writes to the state machine wait until the copy is done; Raft
+// RPCs keep being answered, but their writes wait for SQLite's lock.
+func (c *ConsensusImpl) Backup(ctx context.Context, dir string) (BackupInfo, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return BackupInfo{}, err
+	}
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	c.mu.RLock()
+	applied := c.state.LastApplied
+	c.mu.RUnlock()
+	term, err := c.logTermAt(applied)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	info := BackupInfo{NodeID: c.nodeID, Index: applied, Term: term, CreatedAt: time.Now().UTC()}
+	info.Path = filepath.Join(dir, backupFileName(c.nodeID, applied, term, info.CreatedAt))
+
+	start := time.Now()
+	tags := map[string]string{
+		"backupIndex": intToString(applied),
+		"backupTerm":  intToString(term),
+		"backupNode":  c.nodeID,
+		"backupAt":    info.CreatedAt.Format(time.RFC3339Nano),
+	}
+	if err := c.storage.BackupTo(ctx, info.Path, tags); err != nil {
+		c.log(slog.LevelWarn, "backup_failed", "index", applied, "err", err)
+		return BackupInfo{}, err
+	}
+	if st, err := os.Stat(info.Path); err == nil {
+		info.Bytes = st.Size()
+	}
+	c.log(slog.LevelInfo, "backup_created", "index", applied, "term", term, "path", info.Path, "bytes", info.Bytes, "ms", time.Since(start).Milliseconds())
+	c.audit("backup", "database backup created", map[string]any{"index": applied, "term": term, "path": info.Path, "bytes": info.Bytes})
+	return info, nil
+}
+
+// BackupTo copies the database to path with SQLite's backup API and stores
+// tags in the copy's raft_meta. The copy is written next to path and renamed
+// into place, so path never holds a partial backup.
+func (s *Storage) BackupTo(ctx context.Context, path string, tags map[string]string) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := copyDatabase(ctx, s.db, tmp, func(dst *sql.DB) error {
+		for k, v := range tags {
+			if _, err := dst.Exec(`INSERT INTO raft_meta(key,value) VALUES(?,?)
+        ON CONFLICT(key) DO UPDATE SET value=excluded.value`, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// copyDatabase copies the main database of src into the file dstPath in one
+// backup step, then runs fix on the copy before closing it.
+func copyDatabase(ctx context.Context, src *sql.DB, dstPath string, fix func(dst *sql.DB) error) error {
+	dst, err := sql.Open("sqlite3", "file:"+dstPath+"?_fk=1&_sync=FULL")
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		dstConn.Close()
+		return err
+	}
+	err = dstConn.Raw(func(d any) error {
+		return srcConn.Raw(func(s any) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			// One step copies every page under a single read transaction.
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+	srcConn.Close()
+	dstConn.Close()
+	if err != nil {
+		return fmt.Errorf("sqlite backup: %w", err)
+	}
+	if fix != nil {
+		return fix(dst)
+	}
+	return nil
+}
+
+// ReadBackupInfo reads the tags of a backup file.
+func ReadBackupInfo(path string) (BackupInfo, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer db.Close()
+	tags := map[string]string{}
+	rows, err := db.Query(`SELECT key, value FROM raft_meta WHERE key IN ('backupIndex','backupTerm','backupNode','backupAt')`)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("%s is not a backup: %w", path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return BackupInfo{}, err
+		}
+		tags[k] = v
+	}
+	if err := rows.Err(); err != nil {
+		return BackupInfo{}, err
+	}
+	if _, ok := tags["backupIndex"]; !ok {
+		return BackupInfo{}, fmt.Errorf("%s is not a backup: no backupIndex", path)
+	}
+	info := BackupInfo{
+		NodeID: tags["backupNode"],
+		Index:  parseInt64Default(tags["backupIndex"], 0),
+		Term:   parseInt64Default(tags["backupTerm"], 0),
+		Path:   path,
+		Bytes:  st.Size(),
+	}
+	info.CreatedAt, _ = time.Parse(time.RFC3339Nano, tags["backupAt"])
+
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return BackupInfo{}, err
+	}
+	if latest := migrations[len(migrations)-1].version; version.Int64 > int64(latest) {
+		return BackupInfo{}, fmt.Errorf("backup schema version %d is newer than supported version %d", version.Int64, latest)
+	}
+	return info, nil
+}
+
+// DatabasePath returns the file behind a SQLite DSN, or "" for in-memory
+// databases.
+func DatabasePath(dsn string) string {
+	path, query, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	if params, err := url.ParseQuery(query); err == nil && params.Get("mode") == "memory" {
+		return ""
+	}
+	if path == "" || path == ":memory:" {
+		return ""
+	}
+	return path
+}
+
+// SeedFromBackup makes the backup at backupPath the database of a new node
+// at dsn. It fails with ErrDatabaseExists if the node already has a database.
+func SeedFromBackup(ctx context.Context, backupPath, dsn string) (BackupInfo, error) {
+	target := DatabasePath(dsn)
+	if target == "" {
+		return BackupInfo{}, fmt.Errorf("cannot seed in-memory database %q", dsn)
+	}
+	if st, err := os.Stat(target); err == nil && st.Size() > 0 {
+		return BackupInfo{}, fmt.Errorf("%s: %w", target, ErrDatabaseExists)
+	}
+	info, err := ReadBackupInfo(backupPath)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	src, err := sql.Open("sqlite3", "file:"+backupPath+"?mode=ro")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer src.Close()
+
+	tmp := target + ".seed"
+	os.Remove(tmp)
+	err = copyDatabase(ctx, src, tmp, func(dst *sql.DB) error {
+		return prepareSeedTx(dst, info)
+	})
+	if err != nil {
+		os.Remove(tmp)
+		return BackupInfo{}, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return BackupInfo{}, err
+	}
+	return info, nil
+}
+
+// prepareSeedTx drops what the copy has of the source node itself and sets
+// the Raft state to the backup index.
+func prepareSeedTx(db *sql.DB, info BackupInfo) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmts := []struct {
+		sql  string
+		args []any
+	}{
+		// Entries after the backup index may never have been committed.
+		{`DELETE FROM raft_log WHERE idx > ?`, []any{info.Index}},
+		// Node-local: the source's peer view, audit trail and outbox.
+		{`DELETE FROM cluster_nodes`, nil},
+		{`DELETE FROM audit_logs`, nil},
+		{`DELETE FROM events`, nil},
+		{`DELETE FROM raft_meta WHERE key IN ('backupIndex','backupTerm','backupNode','backupAt')`, nil},
+	}
+	for _, st := range stmts {
+		if _, err := tx.Exec(st.sql, st.args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	meta := map[string]string{
+		"currentTerm": intToString(info.Term),
+		"votedFor":    "",
+		"commitIndex": intToString(info.Index),
+		"lastApplied": intToString(info.Index),
+		"seededFrom":  fmt.Sprintf("%s@%d", info.NodeID, info.Index),
+	}
+	for k, v := range meta {
+		if err := persistMetaTx(tx, k, v); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
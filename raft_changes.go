@@ -0,0 +1,231 @@
+package agendadistribuida
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- change data capture ---
+//
+// After applyCommitted is done with a state machine entry it records a
+// ChangeEvent in raft_changes: index, op, aggregate, the outcome and the
+// payload decoded (upcast, password hashes redacted). Entries that failed in a
+// benign way (e.g. a duplicate email) are recorded as rejected and skipped
+// entries as skipped, so consumers can tell which entries changed the state.
+// Raft's own entries are not recorded.
+//
+// Consumers page through the changes by Raft index with POST /raft/changes
+// (long-poll) or follow them with POST /raft/changes/stream (server-sent
+// events), and restart after the last index they processed. Each node keeps
+// its changes from changesFloor on: older ones are trimmed when snapshots are
+// taken (RAFT_CHANGE_RETENTION) or replaced by an installed snapshot. Asking
+// for changes below the floor fails with ErrChangesCompacted.
+
+// Outcome of a recorded change.
+const (
+	ChangeApplied  = "applied"  // the entry changed the state machine
+	ChangeRejected = "rejected" // applying it failed benignly, no effect
+	ChangeSkipped  = "skipped"  // skipped by an operator (raft.skip), no effect
+)
+
+const (
+	defaultChangePageSize = 100
+	maxChangePageSize     = 1000
+	maxChangeWait         = 30 * time.Second
+	// changeKeepalive is how often an idle change stream sends a comment.
+	changeKeepalive = 15 * time.Second
+)
+
+// ErrChangesCompacted is returned when the changes after a checkpoint are no
+// longer kept on this node.
+var ErrChangesCompacted = errors.New("changes compacted")
+
+// errEventAlreadyApplied is the error of a change whose event id was already
+// applied at an earlier index.
+var errEventAlreadyApplied = errors.New("event already applied")
+
+// ChangeEvent is a committed state machine entry as published to consumers.
+type ChangeEvent struct {
+	Index       int64           `json:"index"`
+	Term        int64           `json:"term"`
+	EventID     string          `json:"event_id"`
+	Aggregate   string          `json:"aggregate"`
+	AggregateID string          `json:"aggregate_id"`
+	Op          string          `json:"op"`
+	Version     int             `json:"payload_version"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	EntityID    string          `json:"entity_id,omitempty"` // id of the entity the entry created
+	Timestamp   time.Time       `json:"timestamp"`           // when the entry was proposed
+}
+
+// ChangeQuery asks for the changes after index After. Wait (milliseconds, at
+// most 30s) long-polls when there are none yet.
+type ChangeQuery struct {
+	After  int64 `json:"after"`
+	Limit  int   `json:"limit,omitempty"`
+	WaitMS int64 `json:"wait_ms,omitempty"`
+}
+
+// ChangePage is a page of changes. Next is the checkpoint to ask from next.
+type ChangePage struct {
+	NodeID  string        `json:"node_id"`
+	Floor   int64         `json:"floor"` // changes up to here are not kept
+	Changes []ChangeEvent `json:"changes"`
+	Next    int64         `json:"next"`
+}
+
+// CompactedError reports the floor a too old checkpoint fell below.
+type CompactedError struct {
+	After, Floor int64
+}
+
+func (e *CompactedError) Error() string {
+	return fmt.Sprintf("changes after %d are compacted on this node (floor %d)", e.After, e.Floor)
+}
+
+func (e *CompactedError) Unwrap() error { return ErrChangesCompacted }
+
+// recordChange stores the outcome of applying e through st, normally the
+// transaction e is applied in; the caller wakes the consumers once it commits.
+// It is idempotent, so an entry re-applied after a crash keeps its first
+// record.
+func (c *ConsensusImpl) recordChange(st *Storage, e LogEntry, status string, res ApplyResult, applyErr error) error {
+	if strings.HasPrefix(e.Op, "raft.") {
+		return nil
+	}
+	// Consumers get the payload in its current version; an entry that cannot
+	// be decoded is published as stored.
+	payload, version := e.Payload, e.Version
+	if p, err := decodePayload(e); err == nil && p != nil {
+		if b, err := json.Marshal(redactPayload(p)); err == nil {
+			payload, version = string(b), currentPayloadVersion(e.Op)
+		}
+	}
+	if !json.Valid([]byte(payload)) {
+		b, _ := json.Marshal(payload)
+		payload = string(b)
+	}
+	errText := ""
+	if applyErr != nil {
+		errText = applyErr.Error()
+	}
+	_, err := st.conn().Exec(`INSERT OR IGNORE INTO raft_changes(idx, term, event_id, aggregate, aggregate_id, op, payload_version, payload, status, error, entity_id, ts, applied_at)
+        VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		e.Index, e.Term, e.EventID, e.Aggregate, e.AggregateID, e.Op, version, payload, status, errText, res.ID, e.Timestamp, time.Now())
+	if err != nil {
+		return fmt.Errorf("record change %d: %w", e.Index, err)
+	}
+	return nil
+}
+
+// notifyChanges wakes the consumers waiting for new changes.
+func (c *ConsensusImpl) notifyChanges() {
+	c.changesMu.Lock()
+	if c.changesReady != nil {
+		close(c.changesReady)
+		c.changesReady = nil
+	}
+	c.changesMu.Unlock()
+}
+
+// changesWait returns a channel closed when the next change is recorded.
+func (c *ConsensusImpl) changesWait() <-chan struct{} {
+	c.changesMu.Lock()
+	defer c.changesMu.Unlock()
+	if c.changesReady == nil {
+		c.changesReady = make(chan struct{})
+	}
+	return c.changesReady
+}
+
+// compactChangesTx drops the changes up to upTo and raises the floor there.
+func compactChangesTx(tx *sql.Tx, upTo int64) error {
+	if _, err := tx.Exec(`DELETE FROM raft_changes WHERE idx <= ?`, upTo); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`INSERT INTO raft_meta(key,value) VALUES('changesFloor',?)
+        ON CONFLICT(key) DO UPDATE SET value=excluded.value WHERE CAST(value AS INTEGER) < CAST(excluded.value AS INTEGER)`, intToString(upTo))
+	return err
+}
+
+func (c *ConsensusImpl) changesFloor() (int64, error) {
+	var v string
+	err := c.storage.db.QueryRow(`SELECT value FROM raft_meta WHERE key='changesFloor'`).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Changes returns the changes after q.After, waiting up to q.WaitMS for the
+// first one. An empty page means nothing new was applied in time.
+func (c *ConsensusImpl) Changes(ctx context.Context, q ChangeQuery) (ChangePage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultChangePageSize
+	}
+	if limit > maxChangePageSize {
+		limit = maxChangePageSize
+	}
+	wait := min(time.Duration(q.WaitMS)*time.Millisecond, maxChangeWait)
+	var timeout <-chan time.Time
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		// Registered before reading, so a change recorded meanwhile is not missed.
+		ready := c.changesWait()
+		page, err := c.readChanges(q.After, limit)
+		if err != nil || len(page.Changes) > 0 || timeout == nil {
+			return page, err
+		}
+		select {
+		case <-ready:
+		case <-timeout:
+			return page, nil
+		case <-ctx.Done():
+			return page, ctx.Err()
+		}
+	}
+}
+
+func (c *ConsensusImpl) readChanges(after int64, limit int) (ChangePage, error) {
+	floor, err := c.changesFloor()
+	if err != nil {
+		return ChangePage{}, err
+	}
+	page := ChangePage{NodeID: c.nodeID, Floor: floor, Changes: []ChangeEvent{}, Next: after}
+	if after < floor {
+		return page, &CompactedError{After: after, Floor: floor}
+	}
+	rows, err := c.storage.db.Query(`SELECT idx, term, event_id, aggregate, aggregate_id, op, payload_version, payload, status, error, entity_id, ts
+        FROM raft_changes WHERE idx > ? ORDER BY idx ASC LIMIT ?`, after, limit)
+	if err != nil {
+		return ChangePage{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev ChangeEvent
+		var payload string
+		if err := rows.Scan(&ev.Index, &ev.Term, &ev.EventID, &ev.Aggregate, &ev.AggregateID, &ev.Op, &ev.Version, &payload, &ev.Status, &ev.Error, &ev.EntityID, &ev.Timestamp); err != nil {
+			return ChangePage{}, err
+		}
+		ev.Payload = json.RawMessage(payload)
+		page.Changes = append(page.Changes, ev)
+		page.Next = ev.Index
+	}
+	return page, rows.Err()
+}
@@ -0,0 +1,688 @@
+package agendadistribuida
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- state machine digests ---
+//
+// Every node can hash its replicated tables at an exact applied index: it
+// holds the apply loop at that index (applyHold), waits until it gets there
+// and hashes each table row by row, in primary key order. Columns that hold
+// node-local values (the time a node applied an entry, read_at, which is
+// written outside the log) are left out, so replicas that applied the same
+// entries produce the same digest.
+//
+// The leader periodically (RAFT_DIGEST_INTERVAL) or on demand
+// (/raft/digest/check) asks every follower for its digest at its own commit
+// index and compares. For each diverging table it fetches per-row hashes,
+// and the rows that differ are written to a raft_divergence audit record.
+
+// ErrDigestIndexPassed is returned when the state machine is already past the
+// index a digest was requested at (e.g. after installing a snapshot).
+var ErrDigestIndexPassed = errors.New("state machine already applied past the requested index")
+
+// maxDivergentRows caps the rows reported per diverging table.
+const maxDivergentRows = 20
+
+// digestAttempts is how many indexes CheckReplicas tries before it gives up
+// on a replica that keeps applying past the index it is asked for.
+const digestAttempts = 3
+
+// Status of a replica in a DivergenceReport. A replica is skipped when it had
+// already applied past every index it was asked for, so it could not be
+// compared this time.
+const (
+	DigestStatusMatch       = "match"
+	DigestStatusDiverged    = "diverged"
+	DigestStatusUnreachable = "unreachable"
+	DigestStatusSkipped     = "skipped"
+)
+
+// digestTable is a replicated table and the columns identifying its rows.
+type digestTable struct {
+	name string
+	key  []string
+}
+
+// digestTables are the stateMachineTables compared across replicas. events is
+// left out: it is each node's local outbox for the reconcilers.
+var digestTables = []digestTable{
+	{"users", []string{"id"}},
+	{"groups", []string{"id"}},
+	{"group_members", []string{"group_id", "user_id"}},
+	{"appointments", []string{"id"}},
+	{"participants", []string{"id"}},
+	{"notifications", []string{"id"}},
+	{"raft_sessions", []string{"client_id"}},
+	{"raft_applied", []string{"event_id"}},
+}
+
+// digestIgnoredColumns hold values that legitimately differ between replicas.
+var digestIgnoredColumns = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"applied_at": true,
+	"read_at":    true,
+}
+
+// DigestRequest asks a node for its digest at Index (0: whatever it has
+// applied now). Rows adds per-row hashes; Tables restricts the tables.
+type DigestRequest struct {
+	Index  int64    `json:"index"`
+	Tables []string `json:"tables,omitempty"`
+	Rows   bool     `json:"rows,omitempty"`
+}
+
+// TableDigest is the hash of one table. RowHashes maps row keys (key columns
+// joined with "/") to row hashes when requested.
+type TableDigest struct {
+	Table     string            `json:"table"`
+	Rows      int               `json:"rows"`
+	Hash      string            `json:"hash"`
+	RowHashes map[string]string `json:"row_hashes,omitempty"`
+}
+
+// StateDigest is a node's digest at Index.
+type StateDigest struct {
+	NodeID string        `json:"node_id"`
+	Index  int64         `json:"index"`
+	Tables []TableDigest `json:"tables"`
+}
+
+// DigestRowsRequest asks for the current content of some rows of a table.
+type DigestRowsRequest struct {
+	Table string   `json:"table"`
+	Keys  []string `json:"keys"`
+}
+
+// DivergenceReport is the outcome of comparing every follower with the leader.
+type DivergenceReport struct {
+	LeaderID string          `json:"leader_id"`
+	Index    int64           `json:"index"`
+	Diverged bool            `json:"diverged"`
+	Replicas []ReplicaReport `json:"replicas"`
+}
+
+type ReplicaReport struct {
+	NodeID string            `json:"node_id"`
+	Index  int64             `json:"index"` // the index it was compared at
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Tables []TableDivergence `json:"tables,omitempty"`
+}
+
+// TableDivergence describes one table that differs from the leader's. Keys
+// are the differing rows (at most maxDivergentRows), when they could be found.
+type TableDivergence struct {
+	Table        string   `json:"table"`
+	LeaderRows   int      `json:"leader_rows"`
+	FollowerRows int      `json:"follower_rows"`
+	Keys         []string `json:"keys,omitempty"`
+}
+
+// stateDigester is implemented by state machines that can be compared across
+// replicas.
+type stateDigester interface {
+	Digest(tables []string, rows bool) ([]TableDigest, error)
+	DigestRows(table string, keys []string) (map[string]map[string]any, error)
+}
+
+func (m *SQLiteStateMachine) Digest(tables []string, rows bool) ([]TableDigest, error) {
+	if m.tables != nil {
+		if len(tables) == 0 {
+			tables = m.tables
+		}
+		for _, name := range tables {
+			if err := m.checkDigestTable(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m.store.DigestStateMachine(tables, rows)
+}
+
+func (m *SQLiteStateMachine) DigestRows(table string, keys []string) (map[string]map[string]any, error) {
+	if err := m.checkDigestTable(table); err != nil {
+		return nil, err
+	}
+	return m.store.DigestRows(table, keys)
+}
+
+// checkDigestTable rejects a table m does not own.
+func (m *SQLiteStateMachine) checkDigestTable(name string) error {
+	if m.tables == nil || slices.Contains(m.tables, name) {
+		return nil
+	}
+	return fmt.Errorf("%w: table %q is not part of this group", ErrInvalidInput, name)
+}
+
+func findDigestTable(name string) (digestTable, bool) {
+	for _, t := range digestTables {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return digestTable{}, false
+}
+
+// DigestStateMachine hashes the given tables (all digestTables when empty).
+// Callers must prevent concurrent applies.
+func (s *Storage) DigestStateMachine(tables []string, withRows bool) ([]TableDigest, error) {
+	specs := digestTables
+	if len(tables) > 0 {
+		specs = nil
+		for _, name := range tables {
+			t, ok := findDigestTable(name)
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown table %q", ErrInvalidInput, name)
+			}
+			specs = append(specs, t)
+		}
+	}
+	out := make([]TableDigest, 0, len(specs))
+	for _, t := range specs {
+		d, err := s.digestTable(t, withRows)
+		if err != nil {
+			return nil, fmt.Errorf("digest %s: %w", t.name, err)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (s *Storage) digestTable(t digestTable, withRows bool) (TableDigest, error) {
+	d := TableDigest{Table: t.name}
+	if withRows {
+		d.RowHashes = map[string]string{}
+	}
+	rows, err := s.db.Query(`SELECT * FROM ` + t.name + ` ORDER BY ` + strings.Join(t.key, ","))
+	if err != nil {
+		return d, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return d, err
+	}
+	keyIdx, hashIdx := digestColumns(cols, t.key)
+	h := sha256.New()
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return d, err
+		}
+		key := digestRowKey(vals, keyIdx)
+		rh := digestRowHash(cols, vals, hashIdx)
+		fmt.Fprintf(h, "%s\x00%s\n", key, rh)
+		d.Rows++
+		if withRows {
+			d.RowHashes[key] = rh
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return d, err
+	}
+	d.Hash = hex.EncodeToString(h.Sum(nil))
+	return d, nil
+}
+
+// digestColumns returns the positions of the key columns and of the hashed
+// columns, sorted by name so the column order of the schema does not matter.
+func digestColumns(cols, key []string) (keyIdx, hashIdx []int) {
+	pos := make(map[string]int, len(cols))
+	for i, c := range cols {
+		pos[c] = i
+	}
+	for _, k := range key {
+		keyIdx = append(keyIdx, pos[k])
+	}
+	names := append([]string(nil), cols...)
+	sort.Strings(names)
+	for _, c := range names {
+		if !digestIgnoredColumns[c] {
+			hashIdx = append(hashIdx, pos[c])
+		}
+	}
+	return keyIdx, hashIdx
+}
+
+func digestRowKey(vals []any, keyIdx []int) string {
+	parts := make([]string, len(keyIdx))
+	for i, idx := range keyIdx {
+		switch v := vals[idx].(type) {
+		case []byte:
+			parts[i] = string(v)
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func digestRowHash(cols []string, vals []any, hashIdx []int) string {
+	h := sha256.New()
+	for _, i := range hashIdx {
+		fmt.Fprintf(h, "%s\x00%s\x00", cols[i], digestValue(vals[i]))
+	}
+	sum := h.Sum(nil)
+	return hex.EncodeToString(sum[:16])
+}
+
+// digestValue encodes v with its type, treating TEXT read as bytes or string
+// alike.
+func digestValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "n"
+	case int64:
+		return "i" + strconv.FormatInt(val, 10)
+	case float64:
+		return "f" + strconv.FormatFloat(val, 'g', -1, 64)
+	case bool:
+		if val {
+			return "i1"
+		}
+		return "i0"
+	case []byte:
+		return "s" + string(val)
+	case string:
+		return "s" + val
+	case time.Time:
+		return "t" + val.UTC().Format(time.RFC3339Nano)
+	default:
+		return "s" + fmt.Sprint(val)
+	}
+}
+
+// DigestRows returns the current content of the rows of table with the given
+// keys, for divergence reports. Password hashes are replaced by a fingerprint.
+func (s *Storage) DigestRows(table string, keys []string) (map[string]map[string]any, error) {
+	t, ok := findDigestTable(table)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown table %q", ErrInvalidInput, table)
+	}
+	where := make([]string, len(t.key))
+	for i, k := range t.key {
+		where[i] = k + "=?"
+	}
+	query := `SELECT * FROM ` + t.name + ` WHERE ` + strings.Join(where, " AND ")
+	out := make(map[string]map[string]any, len(keys))
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", len(t.key))
+		if len(parts) != len(t.key) {
+			continue
+		}
+		args := make([]any, len(parts))
+		for i, p := range parts {
+			args[i] = p
+		}
+		row, err := s.queryRowMap(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			out[key] = row
+		}
+	}
+	return out, nil
+}
+
+func (s *Storage) queryRowMap(query string, args ...any) (map[string]any, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]any, len(cols))
+	for i, c := range cols {
+		v := vals[i]
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		if c == "password_hash" && v != nil {
+			sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+			v = "[redacted sha256:" + hex.EncodeToString(sum[:4]) + "]"
+		}
+		row[c] = v
+	}
+	return row, nil
+}
+
+// StateDigestAt hashes the state machine exactly at req.Index, holding the
+// apply loop there until ctx expires. Only one digest is taken at a time.
+func (c *ConsensusImpl) StateDigestAt(ctx context.Context, req DigestRequest) (StateDigest, error) {
+	c.mu.RLock()
+	d, ok := c.sm.(stateDigester)
+	c.mu.RUnlock()
+	if !ok {
+		return StateDigest{}, errors.New("state machine does not support digests")
+	}
+	c.digestMu.Lock()
+	defer c.digestMu.Unlock()
+
+	c.mu.Lock()
+	if req.Index <= 0 {
+		req.Index = c.state.LastApplied
+	}
+	if c.state.LastApplied > req.Index {
+		c.mu.Unlock()
+		return StateDigest{}, ErrDigestIndexPassed
+	}
+	c.applyHold = req.Index
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.applyHold = 0
+		c.mu.Unlock()
+		c.signalApply()
+	}()
+
+	for {
+		c.mu.RLock()
+		applied := c.state.LastApplied
+		c.mu.RUnlock()
+		if applied >= req.Index {
+			break
+		}
+		c.signalApply()
+		select {
+		case <-ctx.Done():
+			return StateDigest{}, fmt.Errorf("waiting to apply index %d (applied %d): %w", req.Index, applied, ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+	c.mu.RLock()
+	applied := c.state.LastApplied
+	c.mu.RUnlock()
+	if applied != req.Index {
+		return StateDigest{}, ErrDigestIndexPassed
+	}
+	tables, err := d.Digest(req.Tables, req.Rows)
+	if err != nil {
+		return StateDigest{}, err
+	}
+	return StateDigest{NodeID: c.nodeID, Index: req.Index, Tables: tables}, nil
+}
+
+// DigestRows returns the current content of some rows of a replicated table.
+func (c *ConsensusImpl) DigestRows(req DigestRowsRequest) (map[string]map[string]any, error) {
+	c.mu.RLock()
+	d, ok := c.sm.(stateDigester)
+	c.mu.RUnlock()
+	if !ok {
+		return nil, errors.New("state machine does not support digests")
+	}
+	return d.DigestRows(req.Table, req.Keys)
+}
+
+// digestWait is how long a node holds its apply loop for a digest requested
+// over HTTP: half the RPC timeout, so the answer still reaches the leader.
+func (c *ConsensusImpl) digestWait() time.Duration {
+	return c.cfg.HTTPTimeout / 2
+}
+
+// CheckReplicas compares the digest of every follower with the leader's at
+// the leader's commit index and audits the rows that differ. A follower that
+// already applied past that index is asked again at the new commit index.
+func (c *ConsensusImpl) CheckReplicas(ctx context.Context) (DivergenceReport, error) {
+	c.mu.RLock()
+	isLeader := c.role == roleLeader
+	index := c.state.CommitIndex
+	c.mu.RUnlock()
+	if !isLeader {
+		return DivergenceReport{}, errors.New("not leader")
+	}
+	report := DivergenceReport{LeaderID: c.nodeID, Index: index}
+	peers := c.replicationPeers()
+	sort.Strings(peers)
+
+	reports := make(map[string]ReplicaReport, len(peers))
+	pending := peers
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			c.mu.RLock()
+			index = c.state.CommitIndex
+			c.mu.RUnlock()
+		}
+		last := attempt == digestAttempts
+		leader, remote, errs := c.collectDigests(ctx, pending, DigestRequest{Index: index})
+		if errors.Is(leader.err, ErrDigestIndexPassed) && !last {
+			continue
+		}
+		if leader.err != nil {
+			return report, fmt.Errorf("leader digest: %w", leader.err)
+		}
+		var passed []string
+		for _, pid := range pending {
+			rep := ReplicaReport{NodeID: pid, Index: index, Status: DigestStatusMatch}
+			if err := errs[pid]; err != nil {
+				if errors.Is(err, ErrDigestIndexPassed) {
+					if !last {
+						passed = append(passed, pid)
+						continue
+					}
+					rep.Status = DigestStatusSkipped
+				} else {
+					rep.Status = DigestStatusUnreachable
+				}
+				rep.Error = err.Error()
+				reports[pid] = rep
+				continue
+			}
+			theirs := tablesByName(remote[pid].Tables)
+			for _, mine := range leader.digest.Tables {
+				other := theirs[mine.Table]
+				if other.Hash != mine.Hash {
+					rep.Tables = append(rep.Tables, TableDivergence{Table: mine.Table, LeaderRows: mine.Rows, FollowerRows: other.Rows})
+				}
+			}
+			if len(rep.Tables) > 0 {
+				rep.Status = DigestStatusDiverged
+				report.Diverged = true
+				if err := c.explainDivergence(ctx, pid, &rep); err != nil {
+					rep.Error = err.Error()
+				}
+			}
+			reports[pid] = rep
+		}
+		pending = passed
+	}
+	for _, pid := range peers {
+		report.Replicas = append(report.Replicas, reports[pid])
+	}
+	c.log(slog.LevelInfo, "digest_check_done", "index", report.Index, "replicas", len(peers), "diverged", report.Diverged)
+	return report, nil
+}
+
+type digestOutcome struct {
+	digest StateDigest
+	err    error
+}
+
+// collectDigests takes the leader's digest and asks the peers for theirs at
+// the same time, so every node holds its apply loop only briefly.
+func (c *ConsensusImpl) collectDigests(ctx context.Context, peers []string, req DigestRequest) (digestOutcome, map[string]StateDigest, map[string]error) {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		local  digestOutcome
+		remote = map[string]StateDigest{}
+		errs   = map[string]error{}
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		lctx, cancel := context.WithTimeout(ctx, c.digestWait())
+		defer cancel()
+		local.digest, local.err = c.StateDigestAt(lctx, req)
+	}()
+	payload, _ := json.Marshal(req)
+	for _, pid := range peers {
+		wg.Add(1)
+		go func(pid string) {
+			defer wg.Done()
+			var d StateDigest
+			body, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/digest", payload)
+			var status *httpStatusError
+			if errors.As(err, &status) && status.code == http.StatusConflict {
+				err = ErrDigestIndexPassed
+			}
+			if err == nil {
+				err = json.Unmarshal(body, &d)
+			}
+			if err == nil && d.Index != req.Index {
+				err = fmt.Errorf("digest taken at index %d, want %d", d.Index, req.Index)
+			}
+			mu.Lock()
+			if err != nil {
+				errs[pid] = err
+			} else {
+				remote[pid] = d
+			}
+			mu.Unlock()
+		}(pid)
+	}
+	wg.Wait()
+	return local, remote, errs
+}
+
+// explainDivergence compares per-row hashes of the diverging tables of pid at
+// a fresh index, fills in the differing keys and audits the rows.
+func (c *ConsensusImpl) explainDivergence(ctx context.Context, pid string, rep *ReplicaReport) error {
+	c.mu.RLock()
+	index := c.state.CommitIndex
+	c.mu.RUnlock()
+	req := DigestRequest{Index: index, Rows: true}
+	for _, t := range rep.Tables {
+		req.Tables = append(req.Tables, t.Table)
+	}
+	leader, remote, errs := c.collectDigests(ctx, []string{pid}, req)
+	if leader.err != nil {
+		return leader.err
+	}
+	if err := errs[pid]; err != nil {
+		return err
+	}
+	theirs := tablesByName(remote[pid].Tables)
+	for i, mine := range leader.digest.Tables {
+		keys := diffRowHashes(mine.RowHashes, theirs[mine.Table].RowHashes)
+		if len(keys) > maxDivergentRows {
+			keys = keys[:maxDivergentRows]
+		}
+		rep.Tables[i].Keys = keys
+		fields := map[string]any{
+			"follower":      pid,
+			"index":         index,
+			"table":         mine.Table,
+			"leader_rows":   mine.Rows,
+			"follower_rows": theirs[mine.Table].Rows,
+			"keys":          keys,
+		}
+		if len(keys) > 0 {
+			if rows, err := c.DigestRows(DigestRowsRequest{Table: mine.Table, Keys: keys}); err == nil {
+				fields["leader_values"] = rows
+			}
+			if rows, err := c.fetchDigestRows(pid, DigestRowsRequest{Table: mine.Table, Keys: keys}); err == nil {
+				fields["follower_values"] = rows
+			}
+		}
+		c.log(slog.LevelError, "replica_divergence", "follower", pid, "index", index, "table", mine.Table, "rows", len(keys))
+		fields["node_id"] = c.nodeID
+		RecordAudit(ctx, AuditLevelError, "consensus", "raft_divergence", "replica state diverges from the leader", fields)
+	}
+	return nil
+}
+
+func (c *ConsensusImpl) fetchDigestRows(pid string, req DigestRowsRequest) (map[string]map[string]any, error) {
+	payload, _ := json.Marshal(req)
+	body, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/digest/rows", payload)
+	if err != nil {
+		return nil, err
+	}
+	var rows map[string]map[string]any
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func tablesByName(tables []TableDigest) map[string]TableDigest {
+	out := make(map[string]TableDigest, len(tables))
+	for _, t := range tables {
+		out[t.Table] = t
+	}
+	return out
+}
+
+// diffRowHashes returns the sorted keys whose rows differ or exist on one side
+// only.
+func diffRowHashes(a, b map[string]string) []string {
+	var keys []string
+	for k, h := range a {
+		if b[k] != h {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runDigestChecker compares replicas every DigestInterval while leader.
+func (c *ConsensusImpl) runDigestChecker(ctx context.Context) {
+	t := c.clock.NewTicker(c.cfg.DigestInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C():
+			if !c.IsLeader() {
+				continue
+			}
+			if _, err := c.CheckReplicas(ctx); err != nil {
+				c.log(slog.LevelWarn, "digest_check_failed", "err", err.Error())
+			}
+		}
+	}
+}
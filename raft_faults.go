@@ -0,0 +1,231 @@
+package agendadistribuida
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- fault injection ---
+//
+// Every node-to-node request of a node (ConsensusImpl.postJSON*, the
+// reconcilers, health polling, discovery and API requests proxied to a
+// leader) goes through its FaultInjector, an http.RoundTripper that can
+// drop, delay, duplicate or partition the requests to each peer according to
+// the rules set through POST /raft/faults. A partitioned peer is cut off both
+// ways: FaultMiddleware also rejects the requests it sends to this node (they
+// are stamped with X-Raft-From). This lets partition scenarios run on one
+// machine without iptables. Rules can only be set when the node runs with
+// RAFT_FAULT_INJECTION.
+
+// faultFromHeader names the node that sent a cluster request.
+const faultFromHeader = "X-Raft-From"
+
+// ErrFaultDropped is returned for requests dropped by the fault injector.
+var ErrFaultDropped = errors.New("fault injection: request dropped")
+
+// FaultRule describes the faults injected for one peer (node id or address;
+// "*" applies to every peer without a rule of its own). A rule without any
+// fault removes the peer's rule.
+type FaultRule struct {
+	Peer      string  `json:"peer"`
+	Drop      float64 `json:"drop,omitempty"`      // probability a request is dropped
+	DelayMS   int64   `json:"delay_ms,omitempty"`  // added to every request
+	JitterMS  int64   `json:"jitter_ms,omitempty"` // random extra delay
+	Duplicate float64 `json:"duplicate,omitempty"` // probability a request is delivered twice
+	Partition bool    `json:"partition,omitempty"` // no requests in either direction
+}
+
+func (r FaultRule) empty() bool {
+	return r.Drop <= 0 && r.DelayMS <= 0 && r.JitterMS <= 0 && r.Duplicate <= 0 && !r.Partition
+}
+
+// FaultRequest changes the rules of a node: Reset removes every rule before
+// Rules are applied. An empty request only lists the rules.
+type FaultRequest struct {
+	Reset bool        `json:"reset,omitempty"`
+	Rules []FaultRule `json:"rules,omitempty"`
+}
+
+// FaultState lists the rules in effect on a node.
+type FaultState struct {
+	NodeID  string      `json:"node_id"`
+	Enabled bool        `json:"enabled"`
+	Rules   []FaultRule `json:"rules"`
+}
+
+// FaultInjector is the transport of a node's cluster requests.
+type FaultInjector struct {
+	nodeID  string
+	base    http.RoundTripper
+	resolve func(id string) string // peer id -> address
+
+	mu    sync.RWMutex
+	rules map[string]FaultRule
+}
+
+func newFaultInjector(nodeID string, base http.RoundTripper, resolve func(string) string) *FaultInjector {
+	return &FaultInjector{nodeID: nodeID, base: base, resolve: resolve, rules: map[string]FaultRule{}}
+}
+
+// Apply updates the rules as described by req and returns them.
+func (f *FaultInjector) Apply(req FaultRequest) []FaultRule {
+	f.mu.Lock()
+	if req.Reset {
+		f.rules = map[string]FaultRule{}
+	}
+	for _, r := range req.Rules {
+		if r.Peer == "" {
+			continue
+		}
+		if r.empty() {
+			delete(f.rules, r.Peer)
+		} else {
+			f.rules[r.Peer] = r
+		}
+	}
+	f.mu.Unlock()
+	return f.Rules()
+}
+
+// Rules returns the rules in effect, ordered by peer.
+func (f *FaultInjector) Rules() []FaultRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]FaultRule, 0, len(f.rules))
+	for _, r := range f.rules {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out
+}
+
+// ruleFor returns the rule for peer, given as a node id or an address.
+func (f *FaultInjector) ruleFor(peer string) (FaultRule, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(f.rules) == 0 || peer == "" {
+		return FaultRule{}, false
+	}
+	if r, ok := f.rules[peer]; ok {
+		return r, true
+	}
+	for key, r := range f.rules {
+		if key == "*" {
+			continue
+		}
+		if f.resolve(key) == peer || f.resolve(peer) == key {
+			return r, true
+		}
+	}
+	r, ok := f.rules["*"]
+	return r, ok
+}
+
+// RoundTrip sends req to its peer unless a rule says otherwise.
+func (f *FaultInjector) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(faultFromHeader, f.nodeID)
+	rule, ok := f.ruleFor(req.URL.Host)
+	if !ok {
+		return f.base.RoundTrip(req)
+	}
+	if rule.Partition || (rule.Drop > 0 && rand.Float64() < rule.Drop) {
+		return nil, ErrFaultDropped
+	}
+	if delay := time.Duration(rule.DelayMS) * time.Millisecond; delay > 0 || rule.JitterMS > 0 {
+		if rule.JitterMS > 0 {
+			delay += time.Duration(rand.Int64N(rule.JitterMS)) * time.Millisecond
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+	if rule.Duplicate > 0 && rand.Float64() < rule.Duplicate && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			dup := req.Clone(context.Background())
+			dup.Body = body
+			go func() {
+				if resp, err := f.base.RoundTrip(dup); err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}()
+		}
+	}
+	return f.base.RoundTrip(req)
+}
+
+// Faults returns the fault rules of this node.
+func (c *ConsensusImpl) Faults() FaultState {
+	return FaultState{NodeID: c.nodeID, Enabled: c.cfg.FaultInjection, Rules: c.faults.Rules()}
+}
+
+// SetFaults changes the fault rules of this node.
+func (c *ConsensusImpl) SetFaults(req FaultRequest) (FaultState, error) {
+	if !c.cfg.FaultInjection {
+		return FaultState{}, errors.New("fault injection disabled (RAFT_FAULT_INJECTION)")
+	}
+	rules := c.faults.Apply(req)
+	c.log(slog.LevelWarn, "fault_rules_changed", "rules", len(rules))
+	c.audit("raft_faults", "fault injection rules changed", map[string]any{"rules": rules, "reset": req.Reset})
+	return FaultState{NodeID: c.nodeID, Enabled: true, Rules: rules}, nil
+}
+
+// faultInjectorOf returns the fault injector of cons (with shards, the meta
+// group's, which holds the rules set through /raft/faults), or nil.
+func faultInjectorOf(cons Consensus) *FaultInjector {
+	switch c := cons.(type) {
+	case *ConsensusImpl:
+		if c != nil {
+			return c.faults
+		}
+	case *MultiRaft:
+		return c.meta.faults
+	case metaLed:
+		return c.meta.faults
+	}
+	return nil
+}
+
+// clusterHTTPClient returns a client for node-to-node requests of cons, going
+// through its fault injector.
+func clusterHTTPClient(cons Consensus, timeout time.Duration) *http.Client {
+	if f := faultInjectorOf(cons); f != nil {
+		return &http.Client{Timeout: timeout, Transport: f}
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// FaultMiddleware rejects cluster requests from peers this node is
+// partitioned from.
+func FaultMiddleware(cons Consensus) mux.MiddlewareFunc {
+	faults := faultInjectorOf(cons)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if faults == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if from := r.Header.Get(faultFromHeader); from != "" {
+				if rule, ok := faults.ruleFor(from); ok && rule.Partition {
+					// Drain the body so the connection can be reused.
+					io.Copy(io.Discard, r.Body)
+					http.Error(w, "partitioned by fault injection", http.StatusServiceUnavailable)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -2,10 +2,15 @@ package agendadistribuida
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,10 +28,16 @@ func RegisterRaftHTTP(r *mux.Router, cons Consensus, store *Storage) {
 			"leader":    cons.LeaderID(),
 		}
 		if impl, ok := cons.(*ConsensusImpl); ok {
+			resp["leader"], resp["leader_term"] = impl.LeaderTerm()
 			impl.mu.RLock()
 			resp["term"] = impl.state.CurrentTerm
 			resp["commit_index"] = impl.state.CommitIndex
 			resp["last_applied"] = impl.state.LastApplied
+			resp["snapshot_index"] = impl.state.SnapshotIndex
+			resp["snapshot_term"] = impl.state.SnapshotTerm
+			resp["config"] = impl.committedConfigLocked()
+			resp["learner"] = impl.isLearnerLocked()
+			resp["strict_quorum"] = impl.strictQuorum
 			if impl.applyErr != nil {
 				resp["apply_error"] = impl.applyErr.Error()
 				resp["apply_error_index"] = impl.applyErrIndex
@@ -39,6 +50,433 @@ func RegisterRaftHTTP(r *mux.Router, cons Consensus, store *Storage) {
 		json.NewEncoder(w).Encode(resp)
 	}).Methods("GET")
 
+	// Admin: hand leadership to another node (e.g. before draining this one).
+	// Body: {"target":"node-2"}; an empty target picks the most up-to-date follower.
+	r.HandleFunc("/raft/transfer-leadership", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "leadership transfer not supported", http.StatusNotImplemented)
+			return
+		}
+		if !impl.IsLeader() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": "not leader", "leader": impl.LeaderID()})
+			return
+		}
+		newLeader, err := impl.TransferLeadership(req.Target)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "transferred", "leader": newLeader})
+	}).Methods("POST")
+
+	// Admin: page through this node's raft_log (see raft_inspect.go).
+	// Body: LogQuery, e.g. {"from":120,"op":"user.create","limit":20}.
+	r.HandleFunc("/raft/log", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var q LogQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "log inspection not supported", http.StatusNotImplemented)
+			return
+		}
+		page, err := impl.InspectLog(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	}).Methods("POST")
+
+	// Admin: resolve an entry this node cannot apply (see raft_poison.go).
+	r.HandleFunc("/raft/poisoned", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "poisoned entry workflow not supported", http.StatusNotImplemented)
+			return
+		}
+		pe, err := impl.PoisonedEntry()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if pe == nil {
+			pe = &PoisonedEntry{NodeID: impl.NodeID()}
+		}
+		json.NewEncoder(w).Encode(pe)
+	}).Methods("POST")
+
+	r.HandleFunc("/raft/poisoned/retry", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "poisoned entry workflow not supported", http.StatusNotImplemented)
+			return
+		}
+		if err := impl.RetryPoisonedEntry(); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "applied"})
+	}).Methods("POST")
+
+	// Body: {"index":118,"reason":"..."}; must be sent to the leader.
+	r.HandleFunc("/raft/skip", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req struct {
+			Index  int64  `json:"index"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "poisoned entry workflow not supported", http.StatusNotImplemented)
+			return
+		}
+		if !impl.IsLeader() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": "not leader", "leader": impl.LeaderID()})
+			return
+		}
+		res, err := impl.SkipEntry(req.Index, req.Reason)
+		if err != nil {
+			writeAdminProposeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "skipped", "skip_index": res.Index})
+	}).Methods("POST")
+
+	// Body: {"op":"repair.user.ensure","aggregate_id":"...","payload":{...}};
+	// must be sent to the leader.
+	r.HandleFunc("/raft/repair", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req struct {
+			Op          string          `json:"op"`
+			Aggregate   string          `json:"aggregate"`
+			AggregateID string          `json:"aggregate_id"`
+			Payload     json.RawMessage `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "poisoned entry workflow not supported", http.StatusNotImplemented)
+			return
+		}
+		if !impl.IsLeader() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": "not leader", "leader": impl.LeaderID()})
+			return
+		}
+		res, err := impl.ProposeRepair(LogEntry{Op: req.Op, Aggregate: req.Aggregate, AggregateID: req.AggregateID, Payload: string(req.Payload)})
+		if err != nil {
+			writeAdminProposeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(res)
+	}).Methods("POST")
+
+	// Cluster: state machine digest at an applied index (see raft_digest.go).
+	r.HandleFunc("/raft/digest", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req DigestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "state digests not supported", http.StatusNotImplemented)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), impl.digestWait())
+		defer cancel()
+		d, err := impl.StateDigestAt(ctx, req)
+		if errors.Is(err, ErrDigestIndexPassed) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(d)
+	}).Methods("POST")
+
+	r.HandleFunc("/raft/digest/rows", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req DigestRowsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "state digests not supported", http.StatusNotImplemented)
+			return
+		}
+		rows, err := impl.DigestRows(req)
+		if errors.Is(err, ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(rows)
+	}).Methods("POST")
+
+	// Admin: compare every follower with the leader now; must be sent to the
+	// leader.
+	r.HandleFunc("/raft/digest/check", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "state digests not supported", http.StatusNotImplemented)
+			return
+		}
+		if !impl.IsLeader() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": "not leader", "leader": impl.LeaderID()})
+			return
+		}
+		report, err := impl.CheckReplicas(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(report)
+	}).Methods("POST")
+
+	// Admin: fault injection rules of this node (see raft_faults.go). An empty
+	// body lists them.
+	r.HandleFunc("/raft/faults", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req FaultRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "fault injection not supported", http.StatusNotImplemented)
+			return
+		}
+		if !req.Reset && len(req.Rules) == 0 {
+			json.NewEncoder(w).Encode(impl.Faults())
+			return
+		}
+		state, err := impl.SetFaults(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(state)
+	}).Methods("POST")
+
+	// Change data capture (see raft_changes.go): the changes applied after a
+	// checkpoint, waiting up to wait_ms for the first one.
+	r.HandleFunc("/raft/changes", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var q ChangeQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "change data capture not supported", http.StatusNotImplemented)
+			return
+		}
+		if q.WaitMS > 0 {
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+		page, err := impl.Changes(r.Context(), q)
+		if err != nil {
+			writeChangesError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	}).Methods("POST")
+
+	// The same changes as server-sent events ("id: <index>"), until the client
+	// disconnects. A Last-Event-ID header resumes after that index.
+	r.HandleFunc("/raft/changes/stream", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var q ChangeQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if id := r.Header.Get("Last-Event-ID"); id != "" {
+			after, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+				return
+			}
+			q.After = after
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "change data capture not supported", http.StatusNotImplemented)
+			return
+		}
+		// A checkpoint that is already compacted still gets a plain 410.
+		page, err := impl.Changes(r.Context(), ChangeQuery{After: q.After, Limit: q.Limit})
+		if err != nil {
+			writeChangesError(w, err)
+			return
+		}
+		rc := http.NewResponseController(w)
+		_ = rc.SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		for {
+			if len(page.Changes) == 0 {
+				io.WriteString(w, ": keepalive\n\n")
+			}
+			for _, ev := range page.Changes {
+				b, _ := json.Marshal(ev)
+				fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", ev.Index, b)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			page, err = impl.Changes(r.Context(), ChangeQuery{After: page.Next, Limit: q.Limit, WaitMS: changeKeepalive.Milliseconds()})
+			if r.Context().Err() != nil {
+				return
+			}
+			if err != nil {
+				b, _ := json.Marshal(map[string]any{"error": err.Error(), "floor": page.Floor})
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+				rc.Flush()
+				return
+			}
+		}
+	}).Methods("POST")
+
+	// Admin: online backup of this node's database (see raft_backup.go), kept
+	// in RAFT_BACKUP_DIR or, with download, streamed back.
+	r.HandleFunc("/raft/backup", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req BackupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "backups not supported", http.StatusNotImplemented)
+			return
+		}
+		dir := BackupDir()
+		if req.Download {
+			tmp, err := os.MkdirTemp("", "raft-backup-")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer os.RemoveAll(tmp)
+			dir = tmp
+		}
+		info, err := impl.Backup(r.Context(), dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !req.Download {
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+		f, err := os.Open(info.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Bytes, 10))
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(info.Path)+`"`)
+		w.Header().Set("X-Raft-Index", strconv.FormatInt(info.Index, 10))
+		w.Header().Set("X-Raft-Term", strconv.FormatInt(info.Term, 10))
+		io.Copy(w, f)
+	}).Methods("POST")
+
+	r.HandleFunc("/raft/timeout-now", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req TimeoutNowRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "timeout-now not supported", http.StatusNotImplemented)
+			return
+		}
+		resp, err := impl.HandleTimeoutNow(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}).Methods("POST")
+
 	r.HandleFunc("/raft/request-vote", func(w http.ResponseWriter, r *http.Request) {
 		if !validateClusterHMAC(w, r) {
 			return
@@ -106,9 +544,89 @@ func RegisterRaftHTTP(r *mux.Router, cons Consensus, store *Storage) {
 		}
 		json.NewEncoder(w).Encode(resp)
 	}).Methods("POST")
+
+	r.HandleFunc("/raft/read-index", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req ReadIndexRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "read index not supported", http.StatusNotImplemented)
+			return
+		}
+		resp, err := impl.HandleReadIndex(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}).Methods("POST")
+
+	// Proposals forwarded by nodes running shards to the leader of a group
+	// (raft_shards.go). The outcome of the entry is in the body.
+	r.HandleFunc("/raft/propose", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var entry LogEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		impl, ok := cons.(*ConsensusImpl)
+		if !ok {
+			http.Error(w, "propose not supported", http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !impl.IsLeader() {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": "not leader", "leader": impl.LeaderID()})
+			return
+		}
+		res, err := impl.Propose(entry)
+		resp := ProposeResponse{Result: res}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		json.NewEncoder(w).Encode(resp)
+	}).Methods("POST")
+
+	r.HandleFunc("/raft/install-snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if !validateClusterHMAC(w, r) {
+			return
+		}
+		var req InstallSnapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := cons.HandleInstallSnapshot(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}).Methods("POST")
+}
+
+// writeAdminProposeError maps the outcome of an operator proposal to a status.
+func writeAdminProposeError(w http.ResponseWriter, err error) {
+	status := http.StatusServiceUnavailable
+	if errors.Is(err, ErrInvalidInput) {
+		status = http.StatusBadRequest
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": err.Error()})
 }
 
 // Middleware that redirects write methods to leader if current node is follower.
+// cons.LeaderID() only names the leader of the current term (see raft_leader.go).
 func LeaderWriteMiddleware(cons Consensus, leaderAddrResolver func(string) string) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -120,7 +638,8 @@ func LeaderWriteMiddleware(cons Consensus, leaderAddrResolver func(string) strin
 				w.Header().Set("Access-Control-Allow-Origin", "*")
 			}
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Read-Consistency, X-Client-ID, X-Request-Seq, X-Raft-Group, X-Min-Applied-Index, X-Max-Staleness")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Raft-Read-Index, X-Raft-Applied-Index, X-Raft-Term, X-Raft-Commit-Index, X-Raft-Group")
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Max-Age", "3600")
 
@@ -145,28 +664,47 @@ func LeaderWriteMiddleware(cons Consensus, leaderAddrResolver func(string) strin
 				return
 			}
 
+			// API responses report how far this node has got (raft_staleness.go).
+			if cons != nil && (path == "/register" || path == "/login" || strings.HasPrefix(path, "/api/")) {
+				w, r = trackRaftProgress(w, r, cons)
+			}
+
+			// With shards each group has its own leader (raft_shards.go).
+			if m, ok := cons.(*MultiRaft); ok {
+				m.serveSharded(w, r, next, leaderAddrResolver)
+				return
+			}
+
 			// For /register and /login, enforce that they go through the leader when consensus is wired
 			if (path == "/register" || path == "/login") && cons != nil && !cons.IsLeader() {
 				leaderID := cons.LeaderID()
 				if leaderID != "" {
 					addr := leaderAddrResolver(leaderID)
-					proxyRequestToLeader(w, r, addr)
+					proxyRequestToLeader(w, r, cons, addr)
 					return
 				}
 			}
 
+			// Reads bounding their staleness are served here once this node is
+			// fresh enough, by the leader otherwise.
+			if strings.HasPrefix(path, "/api/") && serveBoundedRead(w, r, next, progressGroup(cons, r), leaderAddrResolver) {
+				return
+			}
+
 			// If this node is leader or consensus is not wired, handle normally
 			if cons == nil || cons.IsLeader() {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			// On followers, proxy ALL operations under /api/ (reads and writes) to the leader
-			if strings.HasPrefix(path, "/api/") {
+			// On followers, proxy ALL operations under /api/ (reads and writes) to the leader,
+			// except GETs that opted into linearizable reads: those are served locally
+			// after a ReadIndex barrier (see readConsistencyMiddleware).
+			if strings.HasPrefix(path, "/api/") && !(r.Method == http.MethodGet && wantsLinearizableRead(r)) {
 				leaderID := cons.LeaderID()
 				if leaderID != "" {
 					addr := leaderAddrResolver(leaderID)
-					proxyRequestToLeader(w, r, addr)
+					proxyRequestToLeader(w, r, cons, addr)
 					return
 				}
 			}
@@ -176,8 +714,9 @@ func LeaderWriteMiddleware(cons Consensus, leaderAddrResolver func(string) strin
 	}
 }
 
-// proxyRequestToLeader forwards the request to the leader and returns the response
-func proxyRequestToLeader(w http.ResponseWriter, r *http.Request, leaderAddr string) {
+// proxyRequestToLeader forwards the request to the leader, through the fault
+// injector of cons, and returns the response
+func proxyRequestToLeader(w http.ResponseWriter, r *http.Request, cons Consensus, leaderAddr string) {
 	// Create a new request to the leader using internal Docker address
 	leaderURL := "http://" + leaderAddr + r.RequestURI
 	req, err := http.NewRequest(r.Method, leaderURL, r.Body)
@@ -198,7 +737,7 @@ func proxyRequestToLeader(w http.ResponseWriter, r *http.Request, leaderAddr str
 	}
 
 	// Make the request
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := clusterHTTPClient(cons, 10*time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		Logger().Error("proxy_request_failed", "err", err, "url", leaderURL)
@@ -225,6 +764,20 @@ func proxyRequestToLeader(w http.ResponseWriter, r *http.Request, leaderAddr str
 	}
 }
 
+// writeChangesError answers a failed change query: 410 with the floor when
+// the checkpoint was compacted.
+func writeChangesError(w http.ResponseWriter, err error) {
+	var compacted *CompactedError
+	switch {
+	case errors.As(err, &compacted):
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(map[string]any{"error": err.Error(), "floor": compacted.Floor})
+	case errors.Is(err, context.Canceled):
+	default:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
+
 // --- HMAC guard ---
 
 func validateClusterHMAC(w http.ResponseWriter, r *http.Request) bool {
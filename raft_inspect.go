@@ -0,0 +1,242 @@
+package agendadistribuida
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// --- log inspection ---
+//
+// POST /raft/log (cluster-signed) pages through this node's raft_log so an
+// operator can find the entry behind an apply_error without opening the
+// SQLite file. Each entry carries its apply status and its payload decoded
+// into the struct raft_apply.go uses for its op. cmd/raftctl is the client.
+
+const (
+	defaultLogPageSize = 50
+	maxLogPageSize     = 500
+)
+
+// Apply status of an inspected entry.
+const (
+	LogStatusApplied     = "applied"     // recorded in raft_applied
+	LogStatusFailed      = "failed"      // applying it failed; apply is stuck here
+	LogStatusCommitted   = "committed"   // committed, waiting to be applied
+	LogStatusUncommitted = "uncommitted" // not known to be committed yet
+)
+
+// LogQuery selects log entries. Zero values do not filter.
+type LogQuery struct {
+	From        int64  `json:"from,omitempty"` // first index (inclusive)
+	To          int64  `json:"to,omitempty"`   // last index (inclusive)
+	Op          string `json:"op,omitempty"`
+	AggregateID string `json:"aggregate_id,omitempty"`
+	Limit       int    `json:"limit,omitempty"` // page size, default 50, at most 500
+}
+
+// LogEntryView is a log entry as shown to operators.
+type LogEntryView struct {
+	Index       int64           `json:"index"`
+	Term        int64           `json:"term"`
+	EventID     string          `json:"event_id"`
+	Aggregate   string          `json:"aggregate"`
+	AggregateID string          `json:"aggregate_id"`
+	Op          string          `json:"op"`
+	Timestamp   time.Time       `json:"timestamp"`
+	ClientID    string          `json:"client_id,omitempty"`
+	Seq         int64           `json:"seq,omitempty"`
+	Version     int             `json:"payload_version"` // as stored; Payload is upcast to the current version
+	Status      string          `json:"status"`
+	AppliedAt   *time.Time      `json:"applied_at,omitempty"`
+	ApplyError  string          `json:"apply_error,omitempty"`
+	Payload     any             `json:"payload,omitempty"`      // decoded payload
+	RawPayload  json.RawMessage `json:"raw_payload,omitempty"`  // set when the payload could not be decoded
+	DecodeError string          `json:"decode_error,omitempty"` // why it could not
+}
+
+// LogPage is one page of inspected entries. Next is the From of the next
+// page, or 0 when there are no more matching entries.
+type LogPage struct {
+	NodeID        string         `json:"node_id"`
+	FirstIndex    int64          `json:"first_index"` // oldest entry still in the log
+	LastIndex     int64          `json:"last_index"`
+	CommitIndex   int64          `json:"commit_index"`
+	LastApplied   int64          `json:"last_applied"`
+	SnapshotIndex int64          `json:"snapshot_index"`
+	Entries       []LogEntryView `json:"entries"`
+	Next          int64          `json:"next,omitempty"`
+}
+
+// payloadTypes maps each op to the payload struct it is applied from.
+var payloadTypes = map[string]func() any{
+	OpApptCreatePersonal:            func() any { return &apptCreatePayload{} },
+	OpApptCreateGroup:               func() any { return &apptCreateGroupPayload{} },
+	OpApptUpdate:                    func() any { return &apptUpdatePayload{} },
+	OpApptDelete:                    func() any { return &apptDeletePayload{} },
+	OpUserCreate:                    func() any { return &userCreatePayload{} },
+	OpUserUpdateProfile:             func() any { return &userUpdateProfilePayload{} },
+	OpUserUpdatePassword:            func() any { return &userUpdatePasswordPayload{} },
+	OpGroupCreate:                   func() any { return &groupCreatePayload{} },
+	OpGroupUpdate:                   func() any { return &groupUpdatePayload{} },
+	OpGroupDelete:                   func() any { return &groupDeletePayload{} },
+	OpGroupMemberAdd:                func() any { return &groupMemberPayload{} },
+	OpGroupMemberUpdate:             func() any { return &groupMemberPayload{} },
+	OpGroupMemberRemove:             func() any { return &groupMemberPayload{} },
+	OpInvitationAccept:              func() any { return &invitationStatusPayload{} },
+	OpInvitationReject:              func() any { return &invitationStatusPayload{} },
+	OpRepairUserClearEmailIfMatches: func() any { return &repairUserClearEmailPayload{} },
+	OpRepairEnsureUser:              func() any { return &repairEnsureUserPayload{} },
+	OpRepairEnsureGroupMember:       func() any { return &repairEnsureGroupMemberPayload{} },
+	OpRepairEnsureParticipant:       func() any { return &repairEnsureParticipantPayload{} },
+	OpRepairEnsureNotification:      func() any { return &repairEnsureNotificationPayload{} },
+	OpRaftConfig:                    func() any { return &ClusterConfig{} },
+	OpRaftSkip:                      func() any { return &skipPayload{} },
+}
+
+// decodePayload decodes the payload of e into the struct for its op, upcast
+// to the current version the same way applying it does.
+func decodePayload(e LogEntry) (any, error) {
+	if e.Op == OpRaftNoop {
+		return nil, nil
+	}
+	newPayload, ok := payloadTypes[e.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown op %q", e.Op)
+	}
+	e, err := upcastEntry(e)
+	if err != nil {
+		return nil, err
+	}
+	p := newPayload()
+	if err := json.Unmarshal([]byte(e.Payload), p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// redactPayload hides password hashes from inspected payloads.
+func redactPayload(p any) any {
+	const redacted = "[redacted]"
+	switch v := p.(type) {
+	case *userCreatePayload:
+		if v.PasswordHash != "" {
+			v.PasswordHash = redacted
+		}
+	case *repairEnsureUserPayload:
+		if v.PasswordHash != "" {
+			v.PasswordHash = redacted
+		}
+	case *userUpdatePasswordPayload:
+		if v.PasswordHash != "" {
+			v.PasswordHash = redacted
+		}
+	}
+	return p
+}
+
+// InspectLog returns the entries matching q, oldest first, with their apply
+// status.
+func (c *ConsensusImpl) InspectLog(q LogQuery) (LogPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLogPageSize
+	}
+	if limit > maxLogPageSize {
+		limit = maxLogPageSize
+	}
+
+	c.mu.RLock()
+	page := LogPage{
+		NodeID:        c.nodeID,
+		CommitIndex:   c.state.CommitIndex,
+		LastApplied:   c.state.LastApplied,
+		SnapshotIndex: c.state.SnapshotIndex,
+	}
+	applyErr, applyErrIndex := c.applyErr, c.applyErrIndex
+	c.mu.RUnlock()
+
+	var first, last sql.NullInt64
+	if err := c.storage.db.QueryRow(`SELECT MIN(idx), MAX(idx) FROM raft_log`).Scan(&first, &last); err != nil {
+		return LogPage{}, err
+	}
+	page.FirstIndex, page.LastIndex = first.Int64, last.Int64
+
+	where := []string{"l.idx >= ?"}
+	args := []any{q.From}
+	if q.To > 0 {
+		where = append(where, "l.idx <= ?")
+		args = append(args, q.To)
+	}
+	if q.Op != "" {
+		where = append(where, "l.op = ?")
+		args = append(args, q.Op)
+	}
+	if q.AggregateID != "" {
+		where = append(where, "l.aggregate_id = ?")
+		args = append(args, q.AggregateID)
+	}
+	// One extra row tells whether there is a next page.
+	args = append(args, limit+1)
+	rows, err := c.storage.db.Query(`SELECT l.term, l.idx, l.event_id, l.aggregate, l.aggregate_id, l.op, l.payload, l.ts, l.client_id, l.seq, l.payload_version, a.applied_at
+        FROM raft_log l LEFT JOIN raft_applied a ON a.event_id = l.event_id
+        WHERE `+strings.Join(where, " AND ")+` ORDER BY l.idx ASC LIMIT ?`, args...)
+	if err != nil {
+		return LogPage{}, err
+	}
+	defer rows.Close()
+	page.Entries = []LogEntryView{}
+	for rows.Next() {
+		var e LogEntry
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&e.Term, &e.Index, &e.EventID, &e.Aggregate, &e.AggregateID, &e.Op, &e.Payload, &e.Timestamp, &e.ClientID, &e.Seq, &e.Version, &appliedAt); err != nil {
+			return LogPage{}, err
+		}
+		if len(page.Entries) == limit {
+			page.Next = e.Index
+			break
+		}
+		v := LogEntryView{
+			Index:       e.Index,
+			Term:        e.Term,
+			EventID:     e.EventID,
+			Aggregate:   e.Aggregate,
+			AggregateID: e.AggregateID,
+			Op:          e.Op,
+			Timestamp:   e.Timestamp,
+			ClientID:    e.ClientID,
+			Seq:         e.Seq,
+			Version:     e.Version,
+		}
+		switch {
+		case appliedAt.Valid:
+			v.Status = LogStatusApplied
+			v.AppliedAt = &appliedAt.Time
+		case applyErr != nil && e.Index == applyErrIndex:
+			v.Status = LogStatusFailed
+			v.ApplyError = applyErr.Error()
+		case e.Index <= page.LastApplied:
+			// Raft's own entries are applied without a raft_applied row.
+			v.Status = LogStatusApplied
+		case e.Index <= page.CommitIndex:
+			v.Status = LogStatusCommitted
+		default:
+			v.Status = LogStatusUncommitted
+		}
+		if p, err := decodePayload(e); err != nil {
+			v.DecodeError = err.Error()
+			if json.Valid([]byte(e.Payload)) {
+				v.RawPayload = json.RawMessage(e.Payload)
+			} else {
+				raw, _ := json.Marshal(e.Payload)
+				v.RawPayload = raw
+			}
+		} else {
+			v.Payload = redactPayload(p)
+		}
+		page.Entries = append(page.Entries, v)
+	}
+	return page, rows.Err()
+}
@@ -0,0 +1,58 @@
+package agendadistribuida
+
+import "log/slog"
+
+// --- leader discovery ---
+//
+// Followers learn the leader from AppendEntries (and InstallSnapshot): a
+// request accepted for term T names the leader of T. The PeerStore keeps that
+// leader together with T, and moving to a newer term clears it (see
+// setTermLocked), so a leader deposed by a newer term is never reported again.
+// Vote responses and /raft/health carry the responder's leader for its current
+// term as a hint; a hint is only taken if it is not older than what the node
+// already knows.
+
+// LeaderID returns the leader of the current term, or "" while it is unknown.
+func (c *ConsensusImpl) LeaderID() string {
+	id, _ := c.LeaderTerm()
+	return id
+}
+
+// LeaderTerm returns the leader known for the current term ("" if none) and
+// the term it leads.
+func (c *ConsensusImpl) LeaderTerm() (string, int64) {
+	id, term := c.peers.Leader()
+	c.mu.RLock()
+	cur := c.state.CurrentTerm
+	c.mu.RUnlock()
+	if term < cur {
+		return "", cur
+	}
+	return id, term
+}
+
+// leaderHintLocked returns the leader of term if this node knows it, for
+// piggybacking on responses. Callers hold c.mu.
+func (c *ConsensusImpl) leaderHintLocked(term int64) string {
+	id, lt := c.peers.Leader()
+	if lt != term {
+		return ""
+	}
+	return id
+}
+
+// noteLeaderHint records the leader a peer reported for term.
+func (c *ConsensusImpl) noteLeaderHint(from, leader string, term int64) {
+	if leader == "" || leader == c.nodeID {
+		return
+	}
+	c.mu.RLock()
+	stale := term < c.state.CurrentTerm
+	c.mu.RUnlock()
+	if stale {
+		return
+	}
+	if prev := c.peers.GetLeader(); prev != leader && c.peers.SetLeader(leader, term) {
+		c.log(slog.LevelDebug, "leader_hint_accepted", "leader_id", leader, "term", term, "from", from)
+	}
+}
@@ -0,0 +1,762 @@
+package agendadistribuida
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- cluster membership (joint consensus) ---
+//
+// The voting set is a replicated ClusterConfig carried by OpRaftConfig log
+// entries. A change from C_old to C_new goes through two entries: first the
+// joint configuration C_old,new (Voters=C_new, OldVoters=C_old), in which every
+// decision needs a majority of both sets, and then C_new alone. Majorities are
+// always counted against the committed configuration; until the first
+// configuration is committed the node falls back to the legacy activePeers view.
+// The first configuration comes from RAFT_INITIAL_VOTERS only.
+//
+// Learners (read replicas) are members that receive the log but never vote
+// and never count toward quorum. Adding or removing a learner does not change
+// any majority, so it takes a single entry; promoting one to voter goes
+// through joint consensus like any other voter change. Nodes joining through
+// /cluster/join always start as learners.
+//
+// Removed node IDs are kept in the configuration so that a node still
+// gossiping its join after /cluster/leave is not added back; only an explicit
+// readmission (ReadmitLearner) lets it in again.
+
+const OpRaftConfig = "raft.config"
+
+// ConfigMember identifies a voting node and the address used to reach it.
+type ConfigMember struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+}
+
+// ClusterConfig is a Raft cluster configuration. OldVoters is only set while
+// the cluster is in the joint C_old,new phase. Removed lists the node IDs taken
+// out with RemoveServer.
+type ClusterConfig struct {
+	Voters    []ConfigMember `json:"voters"`
+	OldVoters []ConfigMember `json:"old_voters,omitempty"`
+	Learners  []ConfigMember `json:"learners,omitempty"`
+	Removed   []string       `json:"removed,omitempty"`
+}
+
+var (
+	ErrConfigChangeInProgress = errors.New("membership change already in progress")
+	ErrNotVoter               = errors.New("node is not a voter in the current configuration")
+	ErrLearnerNotCaughtUp     = errors.New("learner is not caught up with the leader")
+	ErrNodeRemoved            = errors.New("node was removed from the cluster")
+)
+
+func (cfg *ClusterConfig) IsJoint() bool { return cfg != nil && len(cfg.OldVoters) > 0 }
+
+func memberIDs(ms []ConfigMember) []string {
+	out := make([]string, 0, len(ms))
+	for _, m := range ms {
+		out = append(out, m.NodeID)
+	}
+	return out
+}
+
+// Members returns every node that takes part in the configuration, i.e. the
+// union of both voter sets while joint plus the learners.
+func (cfg *ClusterConfig) Members() []ConfigMember {
+	seen := make(map[string]bool)
+	var out []ConfigMember
+	for _, set := range [][]ConfigMember{cfg.Voters, cfg.OldVoters, cfg.Learners} {
+		for _, m := range set {
+			if seen[m.NodeID] {
+				continue
+			}
+			seen[m.NodeID] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func hasMember(ms []ConfigMember, id string) bool {
+	for _, m := range ms {
+		if m.NodeID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutMember(ms []ConfigMember, id string) ([]ConfigMember, bool) {
+	out := make([]ConfigMember, 0, len(ms))
+	removed := false
+	for _, m := range ms {
+		if m.NodeID == id {
+			removed = true
+			continue
+		}
+		out = append(out, m)
+	}
+	return out, removed
+}
+
+// IsVoter reports whether id votes in either voter set.
+func (cfg *ClusterConfig) IsVoter(id string) bool {
+	return hasMember(cfg.Voters, id) || hasMember(cfg.OldVoters, id)
+}
+
+// IsRemoved reports whether id was removed and not readmitted since.
+func (cfg *ClusterConfig) IsRemoved(id string) bool {
+	for _, r := range cfg.Removed {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLearner reports whether id is a non-voting member.
+func (cfg *ClusterConfig) IsLearner(id string) bool {
+	return !cfg.IsVoter(id) && hasMember(cfg.Learners, id)
+}
+
+func (cfg *ClusterConfig) address(id string) string {
+	for _, m := range cfg.Members() {
+		if m.NodeID == id {
+			return m.Address
+		}
+	}
+	return ""
+}
+
+func majorityOf(ids []string, ok func(id string) bool) bool {
+	if len(ids) == 0 {
+		return false
+	}
+	n := 0
+	for _, id := range ids {
+		if ok(id) {
+			n++
+		}
+	}
+	return n >= len(ids)/2+1
+}
+
+// HasQuorum reports whether the nodes accepted by ok form a majority of the
+// configuration (of both voter sets while joint).
+func (cfg *ClusterConfig) HasQuorum(ok func(id string) bool) bool {
+	if !majorityOf(memberIDs(cfg.Voters), ok) {
+		return false
+	}
+	if cfg.IsJoint() && !majorityOf(memberIDs(cfg.OldVoters), ok) {
+		return false
+	}
+	return true
+}
+
+// quorumMatch returns the highest index replicated on a majority of ids.
+func quorumMatch(ids []string, match func(id string) int64) int64 {
+	if len(ids) == 0 {
+		return 0
+	}
+	vals := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		vals = append(vals, match(id))
+	}
+	sort.Slice(vals, func(i, j int) bool { return vals[i] > vals[j] })
+	return vals[len(ids)/2]
+}
+
+// CommitIndex returns the highest index replicated on a quorum of the
+// configuration, given each member's match index.
+func (cfg *ClusterConfig) CommitIndex(match func(id string) int64) int64 {
+	idx := quorumMatch(memberIDs(cfg.Voters), match)
+	if cfg.IsJoint() {
+		if old := quorumMatch(memberIDs(cfg.OldVoters), match); old < idx {
+			idx = old
+		}
+	}
+	return idx
+}
+
+// committedConfigLocked returns a copy of the committed configuration, or nil
+// while the cluster has not committed one yet. Callers must hold c.mu.
+func (c *ConsensusImpl) committedConfigLocked() *ClusterConfig {
+	if c.config == nil {
+		return nil
+	}
+	cp := *c.config
+	cp.Voters = append([]ConfigMember(nil), c.config.Voters...)
+	cp.OldVoters = append([]ConfigMember(nil), c.config.OldVoters...)
+	cp.Learners = append([]ConfigMember(nil), c.config.Learners...)
+	cp.Removed = append([]string(nil), c.config.Removed...)
+	return &cp
+}
+
+func (c *ConsensusImpl) committedConfig() *ClusterConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.committedConfigLocked()
+}
+
+// Configuration returns the committed cluster configuration (nil if none).
+func (c *ConsensusImpl) Configuration() *ClusterConfig { return c.committedConfig() }
+
+// replicationPeers returns the nodes the leader must send AppendEntries to:
+// every configuration member, or the legacy active peers without a config.
+func (c *ConsensusImpl) replicationPeers() []string {
+	cfg := c.committedConfig()
+	if cfg == nil {
+		return c.activePeers(c.cfg.PeerStaleness)
+	}
+	var out []string
+	for _, m := range cfg.Members() {
+		if m.NodeID != c.nodeID {
+			out = append(out, m.NodeID)
+		}
+	}
+	return out
+}
+
+// peerAddr resolves a node address, preferring the one recorded in the
+// configuration, then the one given in RAFT_INITIAL_VOTERS, over the discovery
+// PeerStore.
+func (c *ConsensusImpl) peerAddr(id string) string {
+	c.mu.RLock()
+	cfg := c.config
+	c.mu.RUnlock()
+	if cfg != nil {
+		if addr := cfg.address(id); addr != "" {
+			return addr
+		}
+	}
+	for _, m := range parseInitialVoters(c.cfg.InitialVoters) {
+		if m.NodeID == id && m.Address != "" {
+			return m.Address
+		}
+	}
+	return c.peers.ResolveAddr(id)
+}
+
+func buildConfigEntry(cfg ClusterConfig) (LogEntry, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return LogEntry{}, err
+	}
+	return LogEntry{
+		EventID:     strconv.FormatInt(time.Now().UnixNano(), 10),
+		Aggregate:   "raft",
+		AggregateID: "config",
+		Op:          OpRaftConfig,
+		Payload:     string(b),
+		Timestamp:   time.Now(),
+	}, nil
+}
+
+// applyConfigEntry makes a committed configuration entry effective. It is
+// called from applyCommitted in log order.
+func (c *ConsensusImpl) applyConfigEntry(e LogEntry) error {
+	e, err := upcastEntry(e)
+	if err != nil {
+		return err
+	}
+	var cfg ClusterConfig
+	if err := json.Unmarshal([]byte(e.Payload), &cfg); err != nil {
+		return err
+	}
+	return c.setCommittedConfig(&cfg, e.Index)
+}
+
+func (c *ConsensusImpl) setCommittedConfig(cfg *ClusterConfig, idx int64) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := c.persistMetaString("config", string(b)); err != nil {
+		return err
+	}
+	if up, ok := c.peers.(interface{ UpsertPeer(id, addr string) }); ok {
+		for _, m := range cfg.Members() {
+			up.UpsertPeer(m.NodeID, m.Address)
+		}
+	}
+	sl, _ := c.peers.(interface{ SetLearner(id string, learner bool) })
+	for _, m := range cfg.Members() {
+		if sl != nil {
+			sl.SetLearner(m.NodeID, cfg.IsLearner(m.NodeID))
+		}
+		_ = c.storage.SetClusterNodeLearner(m.NodeID, cfg.IsLearner(m.NodeID))
+	}
+	c.mu.Lock()
+	c.config = cfg
+	c.mu.Unlock()
+	c.log(slog.LevelInfo, "config_committed", "index", idx, "voters", memberIDs(cfg.Voters), "old_voters", memberIDs(cfg.OldVoters), "learners", memberIDs(cfg.Learners))
+	c.audit("membership", "cluster configuration committed", map[string]any{"index": idx, "voters": memberIDs(cfg.Voters), "old_voters": memberIDs(cfg.OldVoters), "learners": memberIDs(cfg.Learners)})
+	return nil
+}
+
+// maintainConfig is run by the leader on every heartbeat tick. It commits the
+// first configuration when none exists, finishes a joint configuration left
+// behind by a previous leader or a failed change, and steps down once the
+// leader itself is no longer part of the committed configuration.
+func (c *ConsensusImpl) maintainConfig() {
+	if !c.IsLeader() {
+		return
+	}
+	if !c.configMu.TryLock() {
+		return
+	}
+	defer c.configMu.Unlock()
+	cur := c.committedConfig()
+	if cur != nil && !cur.IsJoint() && !cur.IsVoter(c.nodeID) {
+		c.mu.Lock()
+		c.role = roleFollower
+		c.peers.SetLeader("", c.state.CurrentTerm)
+		c.mu.Unlock()
+		c.log(slog.LevelWarn, "leader_removed_from_config")
+		c.audit("demotion", "leader stepped down after being removed from configuration", nil)
+		return
+	}
+	if cur == nil {
+		c.bootstrapConfigLocked()
+		return
+	}
+	if cur.IsJoint() {
+		if err := c.finishJointLocked(cur); err != nil {
+			c.log(slog.LevelWarn, "config_finish_joint_failed", "err", err)
+		}
+	}
+}
+
+// finishJointLocked commits C_new for a joint configuration. Callers must hold
+// c.configMu.
+func (c *ConsensusImpl) finishJointLocked(joint *ClusterConfig) error {
+	entry, err := buildConfigEntry(ClusterConfig{Voters: joint.Voters, Learners: joint.Learners, Removed: joint.Removed})
+	if err != nil {
+		return err
+	}
+	_, err = c.Propose(entry)
+	return err
+}
+
+// SetAdvertiseAddr sets the address other nodes reach this one at
+// (ADVERTISE_ADDR), recorded for it in the configuration. It must be called
+// before Start.
+func (c *ConsensusImpl) SetAdvertiseAddr(addr string) {
+	c.mu.Lock()
+	c.advertiseAddr = addr
+	c.mu.Unlock()
+}
+
+func (c *ConsensusImpl) selfAddr() string {
+	c.mu.RLock()
+	addr := c.advertiseAddr
+	c.mu.RUnlock()
+	if addr != "" {
+		return addr
+	}
+	return c.peers.ResolveAddr(c.nodeID)
+}
+
+// parseInitialVoters splits RAFT_INITIAL_VOTERS entries ("id" or
+// "id=host:port"). Members listed without an address have none.
+func parseInitialVoters(list []string) []ConfigMember {
+	out := make([]ConfigMember, 0, len(list))
+	for _, item := range list {
+		id, addr, _ := strings.Cut(item, "=")
+		out = append(out, ConfigMember{NodeID: strings.TrimSpace(id), Address: strings.TrimSpace(addr)})
+	}
+	return out
+}
+
+// bootstrapConfigLocked commits the first configuration: the voters listed in
+// RAFT_INITIAL_VOTERS, never the peers discovery happens to know, which
+// include aliases of the same nodes. Only a listed node bootstraps, and only
+// once every listed voter has an address (its own, else the one discovery
+// learned for its node ID), no two of them share one, and a quorum of them was
+// heard from within PeerStaleness. Until then, or without the list, the
+// cluster stays in legacy mode. Afterwards membership only changes through
+// AddLearner, PromoteLearner and RemoveServer. Callers must hold c.configMu.
+func (c *ConsensusImpl) bootstrapConfigLocked() {
+	voters := parseInitialVoters(c.cfg.InitialVoters)
+	if !hasMember(voters, c.nodeID) {
+		return
+	}
+	var cfg ClusterConfig
+	byAddr := make(map[string]string)
+	for _, m := range voters {
+		if m.Address == "" && m.NodeID == c.nodeID {
+			m.Address = c.selfAddr()
+		}
+		if m.Address == "" {
+			m.Address = c.peers.ResolveAddr(m.NodeID)
+			// ResolveAddr falls back to the id, which is only an address
+			// for host:port ids
+			if m.Address == m.NodeID && !strings.Contains(m.Address, ":") {
+				c.log(slog.LevelDebug, "config_bootstrap_waiting", "reason", "unresolved voter", "voter", m.NodeID)
+				return
+			}
+		}
+		if other, dup := byAddr[m.Address]; dup {
+			c.log(slog.LevelWarn, "config_bootstrap_duplicate_address", "voter", m.NodeID, "same_as", other, "address", m.Address)
+			continue
+		}
+		byAddr[m.Address] = m.NodeID
+		cfg.Voters = append(cfg.Voters, m)
+	}
+	now := c.clock.Now()
+	c.seenMu.Lock()
+	heard := cfg.HasQuorum(func(id string) bool {
+		at, ok := c.peerSeen[id]
+		return id == c.nodeID || ok && now.Sub(at) <= c.cfg.PeerStaleness
+	})
+	c.seenMu.Unlock()
+	if !heard {
+		c.log(slog.LevelDebug, "config_bootstrap_waiting", "reason", "no quorum heard from", "voters", memberIDs(cfg.Voters))
+		return
+	}
+	entry, err := buildConfigEntry(cfg)
+	if err != nil {
+		return
+	}
+	if _, err := c.Propose(entry); err != nil {
+		c.log(slog.LevelWarn, "config_bootstrap_failed", "err", err)
+		return
+	}
+	c.log(slog.LevelInfo, "config_bootstrapped", "voters", memberIDs(cfg.Voters))
+}
+
+// AddServer adds a new voter through joint consensus. It is a no-op if the
+// node is already a voter. Learners only become voters through
+// PromoteLearner, and removed nodes must be readmitted first.
+func (c *ConsensusImpl) AddServer(id, addr string) error {
+	if id == "" {
+		return ErrInvalidInput
+	}
+	if addr == "" {
+		addr = id
+	}
+	return c.changeMembership(func(cfg *ClusterConfig) (bool, error) {
+		switch {
+		case hasMember(cfg.Voters, id):
+			return false, nil
+		case cfg.IsLearner(id):
+			return false, errors.New("node is a learner; promote it instead")
+		case cfg.IsRemoved(id):
+			return false, ErrNodeRemoved
+		}
+		cfg.Voters = append(cfg.Voters, ConfigMember{NodeID: id, Address: addr})
+		return true, nil
+	})
+}
+
+// AddLearner adds a non-voting member that receives the log. It is a no-op
+// if the node is already a member, and refused for a removed node.
+func (c *ConsensusImpl) AddLearner(id, addr string) error {
+	return c.addLearner(id, addr, false)
+}
+
+// ReadmitLearner is AddLearner for a node removed earlier: it clears the
+// removal and adds the node back as a learner.
+func (c *ConsensusImpl) ReadmitLearner(id, addr string) error {
+	return c.addLearner(id, addr, true)
+}
+
+func (c *ConsensusImpl) addLearner(id, addr string, readmit bool) error {
+	if id == "" {
+		return ErrInvalidInput
+	}
+	if addr == "" {
+		addr = id
+	}
+	return c.changeMembership(func(cfg *ClusterConfig) (bool, error) {
+		if hasMember(cfg.Voters, id) || hasMember(cfg.Learners, id) {
+			return false, nil
+		}
+		if cfg.IsRemoved(id) {
+			if !readmit {
+				return false, ErrNodeRemoved
+			}
+			cfg.Removed = withoutID(cfg.Removed, id)
+		}
+		cfg.Learners = append(cfg.Learners, ConfigMember{NodeID: id, Address: addr})
+		return true, nil
+	})
+}
+
+// PromoteLearner turns a learner into a voter once its log is within
+// learnerCatchUpLag entries of the leader's commit index.
+func (c *ConsensusImpl) PromoteLearner(id string) error {
+	if id == "" {
+		return ErrInvalidInput
+	}
+	cfg := c.committedConfig()
+	if cfg == nil || !cfg.IsLearner(id) {
+		return errors.New("node is not a learner")
+	}
+	c.mu.RLock()
+	match := c.matchIdx[id]
+	commit := c.state.CommitIndex
+	c.mu.RUnlock()
+	if commit-match > c.learnerCatchUpLag {
+		c.log(slog.LevelWarn, "learner_promotion_rejected", "node_id", id, "match_index", match, "commit_index", commit)
+		return ErrLearnerNotCaughtUp
+	}
+	return c.changeMembership(func(cfg *ClusterConfig) (bool, error) {
+		if !cfg.IsLearner(id) {
+			return false, errors.New("node is not a learner")
+		}
+		var promoted []ConfigMember
+		for _, m := range cfg.Learners {
+			if m.NodeID == id {
+				promoted = append(promoted, m)
+			}
+		}
+		cfg.Learners, _ = withoutMember(cfg.Learners, id)
+		cfg.Voters = append(cfg.Voters, promoted...)
+		return true, nil
+	})
+}
+
+// RemoveServer removes a voter (through joint consensus) or a learner and
+// records its ID as removed. A leader removing itself steps down once the new
+// configuration is committed.
+func (c *ConsensusImpl) RemoveServer(id string) error {
+	if id == "" {
+		return ErrInvalidInput
+	}
+	return c.changeMembership(func(cfg *ClusterConfig) (bool, error) {
+		var rv, rl bool
+		cfg.Voters, rv = withoutMember(cfg.Voters, id)
+		cfg.Learners, rl = withoutMember(cfg.Learners, id)
+		if !rv && !rl {
+			return false, nil
+		}
+		if !cfg.IsRemoved(id) {
+			cfg.Removed = append(cfg.Removed, id)
+		}
+		return true, nil
+	})
+}
+
+func withoutID(ids []string, id string) []string {
+	out := make([]string, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// changeMembership applies mutate to a copy of the committed configuration;
+// mutate reports whether it changed anything.
+// Voter changes go C_old -> C_old,new -> C_new; learner-only changes do not
+// affect any quorum and are committed with a single entry. Only one change
+// runs at a time and each step waits for its entry to be committed and applied.
+func (c *ConsensusImpl) changeMembership(mutate func(cfg *ClusterConfig) (bool, error)) error {
+	if !c.IsLeader() {
+		return errors.New("not leader")
+	}
+	if !c.configMu.TryLock() {
+		return ErrConfigChangeInProgress
+	}
+	defer c.configMu.Unlock()
+
+	cur := c.committedConfig()
+	if cur == nil {
+		return errors.New("cluster configuration not bootstrapped yet (see RAFT_INITIAL_VOTERS)")
+	}
+	if cur.IsJoint() {
+		return ErrConfigChangeInProgress
+	}
+	next := c.committedConfig()
+	if changed, err := mutate(next); err != nil || !changed {
+		return err
+	}
+	if len(next.Voters) == 0 {
+		return errors.New("configuration must keep at least one voter")
+	}
+
+	if sameMembers(cur.Voters, next.Voters) {
+		entry, err := buildConfigEntry(*next)
+		if err != nil {
+			return err
+		}
+		c.log(slog.LevelInfo, "config_change_learners", "old", memberIDs(cur.Learners), "new", memberIDs(next.Learners))
+		_, err = c.Propose(entry)
+		return err
+	}
+
+	joint := ClusterConfig{Voters: next.Voters, OldVoters: cur.Voters, Learners: next.Learners, Removed: next.Removed}
+	entry, err := buildConfigEntry(joint)
+	if err != nil {
+		return err
+	}
+	c.log(slog.LevelInfo, "config_change_joint", "old", memberIDs(cur.Voters), "new", memberIDs(next.Voters))
+	if _, err := c.Propose(entry); err != nil {
+		return err
+	}
+	if err := c.finishJointLocked(&joint); err != nil {
+		// The joint configuration stays in effect until maintainConfig
+		// completes it on a later heartbeat.
+		c.log(slog.LevelError, "config_change_final_failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+func sameMembers(a, b []ConfigMember) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, m := range a {
+		if !hasMember(b, m.NodeID) {
+			return false
+		}
+	}
+	return true
+}
+
+// becomeLeaderLocked switches to leader and resets per-peer replication state.
+// Callers must hold c.mu.
+func (c *ConsensusImpl) becomeLeaderLocked(peers []string) {
+	c.role = roleLeader
+	c.peers.SetLeader(c.nodeID, c.state.CurrentTerm)
+	c.heartbeatFailures = 0
+	c.failedElections = 0
+	lastIdx, _, _ := c.lastIndexTerm()
+	c.nextIdx = make(map[string]int64)
+	c.matchIdx = make(map[string]int64)
+	for _, id := range peers {
+		if id == c.nodeID {
+			continue
+		}
+		c.nextIdx[id] = lastIdx + 1
+		c.matchIdx[id] = 0
+	}
+	// Entries left uncommitted by the previous leader only commit together
+	// with one of this term (see advanceCommitLocked).
+	term, commit := c.state.CurrentTerm, c.state.CommitIndex
+	go func() {
+		if _, err := c.commitTermNoop(term, commit); err != nil {
+			c.log(slog.LevelDebug, "term_noop_failed", "term", term, "err", err)
+		}
+	}()
+}
+
+// startConfiguredElection runs pre-vote and election counting votes against
+// the committed configuration only: unreachable voters count as "no", so a
+// minority partition can never elect a leader.
+func (c *ConsensusImpl) startConfiguredElection(cfg *ClusterConfig, preVote bool) error {
+	if !cfg.IsVoter(c.nodeID) {
+		c.log(slog.LevelDebug, "election_skipped_not_voter")
+		return ErrNotVoter
+	}
+	if preVote && !c.collectVotes(cfg, true) {
+		c.mu.Lock()
+		if c.failedElections < 10 {
+			c.failedElections++
+		}
+		c.mu.Unlock()
+		c.log(slog.LevelWarn, "prevote_failed")
+		return errors.New("prevote failed")
+	}
+
+	c.mu.Lock()
+	term := c.state.CurrentTerm + 1
+	if err := c.setTermLocked(term, c.nodeID); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.role = roleCandidate
+	c.mu.Unlock()
+
+	if !c.collectVotes(cfg, false) {
+		c.mu.Lock()
+		if c.failedElections < 10 {
+			c.failedElections++
+		}
+		c.mu.Unlock()
+		c.log(slog.LevelWarn, "election_failed", "term", term, "voters", memberIDs(cfg.Voters))
+		return errors.New("not enough votes")
+	}
+	c.mu.Lock()
+	if c.role != roleCandidate || c.state.CurrentTerm != term {
+		c.mu.Unlock()
+		return errors.New("election superseded")
+	}
+	c.becomeLeaderLocked(c.replicationPeersFrom(cfg))
+	c.mu.Unlock()
+	c.log(slog.LevelInfo, "election_won", "term", term, "voters", memberIDs(cfg.Voters), "joint", cfg.IsJoint())
+	c.audit("election", "node became leader", map[string]any{"term": term, "voters": memberIDs(cfg.Voters), "joint": cfg.IsJoint()})
+	return nil
+}
+
+func (c *ConsensusImpl) replicationPeersFrom(cfg *ClusterConfig) []string {
+	var out []string
+	for _, m := range cfg.Members() {
+		if m.NodeID != c.nodeID {
+			out = append(out, m.NodeID)
+		}
+	}
+	return out
+}
+
+// collectVotes sends (pre-)vote requests to every configuration member and
+// returns true as soon as the granted votes form a quorum.
+func (c *ConsensusImpl) collectVotes(cfg *ClusterConfig, preVote bool) bool {
+	c.mu.RLock()
+	term := c.state.CurrentTerm
+	c.mu.RUnlock()
+	lastIdx, lastTerm, _ := c.lastIndexTerm()
+	req := RequestVoteRequest{Term: term, CandidateID: c.nodeID, LastLogIndex: lastIdx, LastLogTerm: lastTerm, PreVote: preVote}
+	payload, _ := json.Marshal(req)
+
+	granted := map[string]bool{c.nodeID: true}
+	quorum := func() bool { return cfg.HasQuorum(func(id string) bool { return granted[id] }) }
+	if quorum() {
+		return true
+	}
+	type vote struct {
+		id string
+		ok bool
+	}
+	peers := c.replicationPeersFrom(cfg)
+	ch := make(chan vote, len(peers))
+	for _, id := range peers {
+		go func(pid string) {
+			body, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/request-vote", payload)
+			if err != nil {
+				ch <- vote{id: pid}
+				return
+			}
+			var resp RequestVoteResponse
+			if err := json.Unmarshal(body, &resp); err != nil {
+				ch <- vote{id: pid}
+				return
+			}
+			c.noteLeaderHint(pid, resp.LeaderID, resp.Term)
+			ch <- vote{id: pid, ok: resp.VoteGranted}
+		}(id)
+	}
+	timeout := time.After(c.cfg.ElectionWait)
+	for pending := len(peers); pending > 0; pending-- {
+		select {
+		case v := <-ch:
+			if v.ok {
+				granted[v.id] = true
+			}
+			if quorum() {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+	return quorum()
+}
@@ -0,0 +1,155 @@
+package agendadistribuida
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// --- durable Raft state ---
+//
+// currentTerm, votedFor and the log are what a node promises to remember
+// across a crash, so every change to them is written in one transaction
+// before the in-memory state moves: a new term is always stored together with
+// its vote (empty or not), and a follower's log repair (the truncation, the
+// leader's entries and the commit index they allow) is committed or rolled
+// back as a whole. The database runs with synchronous=FULL (see durableDSN),
+// so a vote is never granted and an AppendEntries never acknowledged before
+// the transaction is on disk. When a write fails, the state is left as it was
+// and the RPC fails with the error.
+
+// persistTx runs fn in a single transaction.
+func (c *ConsensusImpl) persistTx(fn func(tx *sql.Tx) error) error {
+	tx, err := c.storage.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// setTermLocked durably moves to term with votedFor ("" for no vote) and then
+// updates the in-memory state. Callers hold c.mu.
+func (c *ConsensusImpl) setTermLocked(term int64, votedFor string) error {
+	err := c.persistTx(func(tx *sql.Tx) error {
+		if err := persistMetaTx(tx, "currentTerm", intToString(term)); err != nil {
+			return err
+		}
+		return persistMetaTx(tx, "votedFor", votedFor)
+	})
+	if err != nil {
+		c.log(slog.LevelError, "raft_persist_term_failed", "term", term, "voted_for", votedFor, "err", err)
+		return err
+	}
+	if term > c.state.CurrentTerm {
+		// Nobody is known to lead the new term yet.
+		c.peers.SetLeader("", term)
+	}
+	c.state.CurrentTerm = term
+	c.state.VotedFor = votedFor
+	return nil
+}
+
+// logRepair is a follower-side log mutation applied in one transaction.
+type logRepair struct {
+	truncateFrom int64      // delete entries from this index on (0: none)
+	entries      []LogEntry // appended after the truncation
+	commitIndex  int64      // new commit index (<0: unchanged)
+	lastApplied  int64      // new last applied index (<0: unchanged)
+}
+
+// repairLogLocked durably applies r and then updates the in-memory indexes.
+// Callers hold c.mu.
+func (c *ConsensusImpl) repairLogLocked(r logRepair) error {
+	err := c.persistTx(func(tx *sql.Tx) error {
+		if r.truncateFrom > 0 {
+			if _, err := tx.Exec(`DELETE FROM raft_log WHERE idx >= ?`, r.truncateFrom); err != nil {
+				return err
+			}
+		}
+		if err := appendLogTx(tx, r.entries); err != nil {
+			return err
+		}
+		if r.commitIndex >= 0 {
+			if err := persistMetaTx(tx, "commitIndex", intToString(r.commitIndex)); err != nil {
+				return err
+			}
+		}
+		if r.lastApplied >= 0 {
+			return persistMetaTx(tx, "lastApplied", intToString(r.lastApplied))
+		}
+		return nil
+	})
+	if err != nil {
+		c.log(slog.LevelError, "raft_persist_log_failed", "truncate_from", r.truncateFrom, "entries", len(r.entries), "err", err)
+		return err
+	}
+	if r.commitIndex >= 0 {
+		c.state.CommitIndex = r.commitIndex
+	}
+	if r.lastApplied >= 0 {
+		c.state.LastApplied = r.lastApplied
+	}
+	return nil
+}
+
+// appendLogTx writes consecutive entries, replacing any at the same index.
+func appendLogTx(tx *sql.Tx, entries []LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO raft_log(term, idx, event_id, aggregate, aggregate_id, op, payload, ts, client_id, seq, payload_version)
+        VALUES(?,?,?,?,?,?,?,?,?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if _, err := stmt.Exec(e.Term, e.Index, e.EventID, e.Aggregate, e.AggregateID, e.Op, e.Payload, e.Timestamp, e.ClientID, e.Seq, e.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveIndex records commitIndex or lastApplied outside a log repair. Both are
+// rebuilt after a crash (committed entries are re-applied and deduplicated by
+// raft_applied), so a failed write is only logged.
+func (c *ConsensusImpl) saveIndex(key string, val int64) {
+	if err := c.persistMeta(key, val); err != nil {
+		c.log(slog.LevelWarn, "raft_persist_index_failed", "key", key, "value", val, "err", err)
+	}
+}
+
+// durableDSN asks the SQLite driver for synchronous=FULL on every connection
+// unless the DSN already chooses a synchronous mode, so that a committed
+// transaction survives a power loss and not just a process crash. Unless the
+// DSN chooses otherwise, transactions also take the write lock when they
+// begin (BEGIN IMMEDIATE): one that read first could otherwise fail to write,
+// without waiting, once another connection committed meanwhile.
+func durableDSN(dsn string) string {
+	query := ""
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		query = dsn[i+1:]
+	}
+	params, _ := url.ParseQuery(query)
+	var extra []string
+	if !params.Has("_sync") && !params.Has("_synchronous") {
+		extra = append(extra, "_sync=FULL")
+	}
+	if !params.Has("_txlock") {
+		extra = append(extra, "_txlock=immediate")
+	}
+	if len(extra) == 0 {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + strings.Join(extra, "&")
+}
@@ -0,0 +1,213 @@
+package agendadistribuida
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- poisoned entries ---
+//
+// A committed entry whose apply fails with a non-ignorable error (see
+// isIgnorableApplyError) stops the state machine at that index (applyErr).
+// The apply loop keeps retrying it and the node rejects proposals until it
+// goes through. An operator can:
+//
+//   - PoisonedEntry: look at the entry and its error;
+//   - RetryPoisonedEntry: apply it again right away (after fixing whatever
+//     made it fail, e.g. a full disk);
+//   - SkipEntry: commit, through the leader, a raft.skip entry naming it.
+//     Every node still failing on that entry finds the committed skip, records
+//     the entry as applied without its effects and writes an audit record;
+//     nodes that applied it are not affected. The skip goes through the log so
+//     that all replicas skip the same entry;
+//   - ProposeRepair: commit a compensating entry (e.g. a repair.* op). It is
+//     applied after the skip, so the cluster recovers without wiping the
+//     database.
+//
+// Both operator entries are accepted while the leader itself is blocked.
+
+const OpRaftSkip = "raft.skip"
+
+// ErrEntrySkipped is returned to the proposer of an entry an operator skipped.
+var ErrEntrySkipped = errors.New("entry skipped by operator")
+
+// skipPayload names the entry to skip. Term and event id guard against
+// skipping a different entry that later took the same index.
+type skipPayload struct {
+	Index   int64  `json:"index"`
+	Term    int64  `json:"term"`
+	EventID string `json:"event_id"`
+	Reason  string `json:"reason"`
+}
+
+// PoisonedEntry is the entry the state machine is stuck on.
+type PoisonedEntry struct {
+	NodeID string        `json:"node_id"`
+	Index  int64         `json:"index"`
+	Error  string        `json:"error"`
+	Entry  *LogEntryView `json:"entry,omitempty"`
+}
+
+// PoisonedEntry returns the entry this node cannot apply, or nil when applying
+// is not blocked.
+func (c *ConsensusImpl) PoisonedEntry() (*PoisonedEntry, error) {
+	c.mu.RLock()
+	applyErr, idx := c.applyErr, c.applyErrIndex
+	c.mu.RUnlock()
+	if applyErr == nil {
+		return nil, nil
+	}
+	pe := &PoisonedEntry{NodeID: c.nodeID, Index: idx, Error: applyErr.Error()}
+	page, err := c.InspectLog(LogQuery{From: idx, To: idx, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Entries) > 0 {
+		pe.Entry = &page.Entries[0]
+	}
+	return pe, nil
+}
+
+// RetryPoisonedEntry applies committed entries now, starting with the one that
+// failed. It returns the apply error if the entry still fails.
+func (c *ConsensusImpl) RetryPoisonedEntry() error {
+	c.mu.RLock()
+	idx := c.applyErrIndex
+	c.mu.RUnlock()
+	err := c.applyCommitted()
+	fields := map[string]any{"index": idx}
+	if err != nil {
+		fields["err"] = err.Error()
+	}
+	c.audit("raft_retry", "operator retried poisoned entry", fields)
+	return err
+}
+
+// SkipEntry commits a raft.skip for the entry at index. It must run on the
+// leader; the entry does not have to be failing there.
+func (c *ConsensusImpl) SkipEntry(index int64, reason string) (ApplyResult, error) {
+	if index <= 0 || strings.TrimSpace(reason) == "" {
+		return ApplyResult{}, ErrInvalidInput
+	}
+	e, err := c.logEntryAt(index)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	if e == nil {
+		return ApplyResult{}, fmt.Errorf("no log entry at index %d", index)
+	}
+	if strings.HasPrefix(e.Op, "raft.") {
+		return ApplyResult{}, fmt.Errorf("cannot skip %s entries", e.Op)
+	}
+	b, err := json.Marshal(skipPayload{Index: e.Index, Term: e.Term, EventID: e.EventID, Reason: reason})
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	c.audit("raft_skip_proposed", "operator proposed skipping a log entry", map[string]any{"index": index, "op": e.Op, "event_id": e.EventID, "reason": reason})
+	return c.propose(LogEntry{
+		EventID:     strconv.FormatInt(time.Now().UnixNano(), 10),
+		Aggregate:   "raft",
+		AggregateID: strconv.FormatInt(index, 10),
+		Op:          OpRaftSkip,
+		Payload:     string(b),
+		Timestamp:   time.Now(),
+	}, true)
+}
+
+// ProposeRepair commits a compensating state machine entry, also while the
+// leader is blocked by an apply error. Only ops the state machine knows are
+// accepted, and the payload must decode into the op's payload struct.
+func (c *ConsensusImpl) ProposeRepair(entry LogEntry) (ApplyResult, error) {
+	if strings.HasPrefix(entry.Op, "raft.") {
+		return ApplyResult{}, fmt.Errorf("%s is not a state machine op", entry.Op)
+	}
+	if entry.Version == 0 {
+		entry.Version = currentPayloadVersion(entry.Op)
+	}
+	if _, err := decodePayload(entry); err != nil {
+		return ApplyResult{}, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if entry.EventID == "" {
+		entry.EventID = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	if entry.Aggregate == "" {
+		entry.Aggregate = "repair"
+	}
+	c.audit("raft_repair_proposed", "operator proposed a repair entry", map[string]any{"op": entry.Op, "aggregate_id": entry.AggregateID, "event_id": entry.EventID})
+	return c.propose(entry, true)
+}
+
+// findSkip looks for a committed raft.skip naming e after it. A skip written
+// in a payload version this node does not know is an error, since it might
+// name e.
+func (c *ConsensusImpl) findSkip(e LogEntry, commitIndex int64) (skipPayload, bool, error) {
+	rows, err := c.storage.db.Query(`SELECT payload, payload_version FROM raft_log WHERE op=? AND idx>? AND idx<=? ORDER BY idx ASC`, OpRaftSkip, e.Index, commitIndex)
+	if err != nil {
+		return skipPayload{}, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		skip := LogEntry{Op: OpRaftSkip}
+		if err := rows.Scan(&skip.Payload, &skip.Version); err != nil {
+			return skipPayload{}, false, err
+		}
+		skip, err := upcastEntry(skip)
+		if err != nil {
+			return skipPayload{}, false, err
+		}
+		var p skipPayload
+		if err := json.Unmarshal([]byte(skip.Payload), &p); err != nil {
+			continue
+		}
+		if p.Index == e.Index && p.Term == e.Term && p.EventID == e.EventID {
+			return p, true, nil
+		}
+	}
+	return skipPayload{}, false, rows.Err()
+}
+
+// recordSkip logs and audits that e is being skipped instead of applied.
+func (c *ConsensusImpl) recordSkip(e LogEntry, applyErr error, skip skipPayload) {
+	c.log(slog.LevelWarn, "apply_entry_skipped", "index", e.Index, "op", e.Op, "err", applyErr.Error(), "reason", skip.Reason)
+	payload := e.Payload
+	if p, err := decodePayload(e); err == nil && p != nil {
+		if b, err := json.Marshal(redactPayload(p)); err == nil {
+			payload = string(b)
+		}
+	}
+	RecordAudit(context.Background(), AuditLevelWarn, "consensus", "raft_skip", "log entry skipped by operator", map[string]any{
+		"node_id":      c.nodeID,
+		"index":        e.Index,
+		"term":         e.Term,
+		"event_id":     e.EventID,
+		"op":           e.Op,
+		"aggregate_id": e.AggregateID,
+		"payload":      payload,
+		"apply_error":  applyErr.Error(),
+		"reason":       skip.Reason,
+	})
+}
+
+// logEntryAt returns the entry at idx, or nil if it is not in the log.
+func (c *ConsensusImpl) logEntryAt(idx int64) (*LogEntry, error) {
+	var e LogEntry
+	err := c.storage.db.QueryRow(`SELECT term, idx, event_id, aggregate, aggregate_id, op, payload, ts, client_id, seq, payload_version FROM raft_log WHERE idx=?`, idx).
+		Scan(&e.Term, &e.Index, &e.EventID, &e.Aggregate, &e.AggregateID, &e.Op, &e.Payload, &e.Timestamp, &e.ClientID, &e.Seq, &e.Version)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,215 @@
+package agendadistribuida
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// --- group commit ---
+//
+// Propose does not write to raft_log itself. It queues the entry and a single
+// proposer goroutine drains whatever is queued at that moment (up to maxBatch
+// entries), assigns consecutive indexes, appends the whole batch in one
+// transaction and waits once for the batch to be committed. The replicators
+// ship the batch in as few AppendEntries as possible. Each caller still gets
+// its own index back, together with what the state machine produced for its
+// entry: applyCommitted resolves every registered proposal as it applies it.
+
+var errConsensusStopped = errors.New("consensus stopped")
+
+type proposal struct {
+	entry LogEntry
+	admin bool                // operator entry; outlives apply errors
+	done  chan proposalResult // buffered; receives exactly one result
+}
+
+type proposalResult struct {
+	index  int64
+	result ApplyResult
+	err    error
+}
+
+// submitProposal queues entry for the proposer goroutine and waits until it
+// has been applied on this node, at most ApplyWaitTimeout in all. It returns
+// the index assigned to entry and the state machine's result for it.
+func (c *ConsensusImpl) submitProposal(entry LogEntry, admin bool) (int64, ApplyResult, error) {
+	p := &proposal{entry: entry, admin: admin, done: make(chan proposalResult, 1)}
+	timeout := time.NewTimer(c.cfg.ApplyWaitTimeout)
+	defer timeout.Stop()
+	select {
+	case c.proposals <- p:
+	case <-timeout.C:
+		return 0, ApplyResult{}, errors.New("proposal queue full")
+	}
+	select {
+	case res := <-p.done:
+		return res.index, res.result, res.err
+	case <-timeout.C:
+		c.forgetProposal(p)
+		return 0, ApplyResult{}, errors.New("timeout waiting for entry to be applied")
+	}
+}
+
+// runProposer is the only writer of new entries to raft_log on the leader.
+func (c *ConsensusImpl) runProposer(ctx context.Context) {
+	for {
+		var first *proposal
+		select {
+		case <-ctx.Done():
+			c.failQueuedProposals()
+			return
+		case first = <-c.proposals:
+		}
+		batch := []*proposal{first}
+		// Coalesce whatever else is already queued.
+		limit := int(c.maxBatch)
+	drain:
+		for limit <= 0 || len(batch) < limit {
+			select {
+			case p := <-c.proposals:
+				batch = append(batch, p)
+			default:
+				break drain
+			}
+		}
+		c.commitBatch(batch)
+	}
+}
+
+// commitBatch appends a batch to the log and hands it to a goroutine that
+// waits for it to commit, so the next batch can be appended meanwhile.
+func (c *ConsensusImpl) commitBatch(batch []*proposal) {
+	fail := func(err error) {
+		for _, p := range batch {
+			p.done <- proposalResult{err: err}
+		}
+	}
+	c.mu.RLock()
+	isLeader := c.role == roleLeader
+	transfer := c.transferTarget != ""
+	term := c.state.CurrentTerm
+	c.mu.RUnlock()
+	if !isLeader {
+		fail(errors.New("not leader"))
+		return
+	}
+	if transfer {
+		fail(ErrLeadershipTransfer)
+		return
+	}
+
+	nextIdx, err := c.nextIndex()
+	if err != nil {
+		fail(err)
+		return
+	}
+	entries := make([]LogEntry, len(batch))
+	for i, p := range batch {
+		e := p.entry
+		e.Term = term
+		e.Index = nextIdx + int64(i)
+		entries[i] = e
+		p.entry = e
+	}
+	// Register before appending so no apply can slip in between.
+	c.mu.Lock()
+	for _, p := range batch {
+		c.pendingProposals[p.entry.Index] = p
+	}
+	c.mu.Unlock()
+	if err := c.appendLogBatch(entries); err != nil {
+		c.log(slog.LevelError, "propose_append_failed", "err", err, "batch", len(entries))
+		for _, p := range batch {
+			c.resolveProposal(p.entry, ApplyResult{}, err)
+		}
+		return
+	}
+	last := entries[len(entries)-1].Index
+	c.log(slog.LevelDebug, "proposal_batch_appended", "first_index", nextIdx, "last_index", last, "batch", len(entries))
+
+	c.ensureReplicators()
+	c.kickReplicators()
+	c.recalculateCommitIndex()
+	go func() {
+		if err := c.waitForCommit(last, term, c.cfg.ApplyWaitTimeout); err != nil {
+			for _, p := range batch {
+				c.resolveProposal(p.entry, ApplyResult{}, err)
+			}
+			return
+		}
+		if aerr := c.applyCommitted(); aerr != nil {
+			c.log(slog.LevelError, "apply_committed_failed", "err", aerr)
+		}
+	}()
+}
+
+// resolveProposal hands the outcome of applying e to the local proposer
+// waiting for it, if any. A different entry at the proposal's index means a
+// new leader overwrote it.
+func (c *ConsensusImpl) resolveProposal(e LogEntry, res ApplyResult, err error) {
+	c.mu.Lock()
+	p, ok := c.pendingProposals[e.Index]
+	if ok {
+		delete(c.pendingProposals, e.Index)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	if p.entry.EventID != e.EventID || p.entry.Term != e.Term {
+		res, err = ApplyResult{}, errors.New("proposal overwritten by another leader")
+	}
+	p.done <- proposalResult{index: e.Index, result: res, err: err}
+}
+
+// failPendingProposals resolves every proposal at or after idx with err. It
+// is used when applying stops at idx, so their proposers do not wait for
+// entries that will not be applied until an operator intervenes. Operator
+// entries keep waiting: they are the intervention.
+func (c *ConsensusImpl) failPendingProposals(idx int64, err error) {
+	c.mu.Lock()
+	var failed []*proposal
+	for i, p := range c.pendingProposals {
+		if i >= idx && !p.admin {
+			failed = append(failed, p)
+			delete(c.pendingProposals, i)
+		}
+	}
+	c.mu.Unlock()
+	for _, p := range failed {
+		p.done <- proposalResult{index: p.entry.Index, err: err}
+	}
+}
+
+// forgetProposal drops p after its proposer gave up waiting.
+func (c *ConsensusImpl) forgetProposal(p *proposal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, q := range c.pendingProposals {
+		if q == p {
+			delete(c.pendingProposals, i)
+		}
+	}
+}
+
+// failQueuedProposals rejects proposals still queued when the node stops.
+func (c *ConsensusImpl) failQueuedProposals() {
+	for {
+		select {
+		case p := <-c.proposals:
+			p.done <- proposalResult{err: errConsensusStopped}
+		default:
+			return
+		}
+	}
+}
+
+// appendLogBatch writes consecutive entries in a single transaction.
+func (c *ConsensusImpl) appendLogBatch(entries []LogEntry) error {
+	return c.persistTx(func(tx *sql.Tx) error {
+		return appendLogTx(tx, entries)
+	})
+}
@@ -0,0 +1,115 @@
+package agendadistribuida
+
+import (
+	"log/slog"
+)
+
+// --- strict quorum ---
+//
+// Until a configuration is committed, majorities are computed over the peers
+// this node currently sees (votingPeers), and elections/pre-votes count only
+// the peers that answered, with a "degraded mode" for tiny clusters. That keeps
+// a partitioned minority available, but it can elect its own leader and accept
+// writes. With RAFT_STRICT_QUORUM=true:
+//
+//   - majorities are always computed over the full voter set: the committed
+//     configuration, or before there is one RAFT_INITIAL_VOTERS, which strict
+//     mode therefore requires (so unreachable peers keep counting, even
+//     across restarts);
+//   - elections and pre-votes need votes from a majority of that set;
+//   - CheckQuorum: a leader that has not heard from a quorum within an
+//     election timeout steps down, so a leader cut off in a minority stops
+//     accepting writes.
+
+// quorumVoters returns the peers (without this node) whose acknowledgements
+// form legacy majorities: the reachable voters, or in strict mode the initial
+// voters.
+func (c *ConsensusImpl) quorumVoters() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.quorumVotersLocked()
+}
+
+// quorumVotersLocked is quorumVoters for callers holding c.mu.
+func (c *ConsensusImpl) quorumVotersLocked() []string {
+	if !c.strictQuorum {
+		return c.votingPeers()
+	}
+	var out []string
+	for _, m := range parseInitialVoters(c.cfg.InitialVoters) {
+		if m.NodeID != c.nodeID {
+			out = append(out, m.NodeID)
+		}
+	}
+	return out
+}
+
+// voteMajority is the number of votes an election or pre-vote needs out of
+// total voters, of which reachable answered. Only strict mode ignores who
+// answered.
+func (c *ConsensusImpl) voteMajority(reachable, total int) int {
+	if c.strictQuorum {
+		return total/2 + 1
+	}
+	return reachable/2 + 1
+}
+
+// recordContact notes that peer answered an RPC of the current term.
+func (c *ConsensusImpl) recordContact(peer string) {
+	c.mu.Lock()
+	c.lastContact[peer] = c.clock.Now()
+	c.mu.Unlock()
+	c.NotePeerSeen(peer)
+}
+
+// checkQuorum steps a strict-mode leader down when fewer than a quorum of
+// voters answered within the last election timeout. It reports whether this
+// node is still leader.
+func (c *ConsensusImpl) checkQuorum() bool {
+	if !c.strictQuorum {
+		return true
+	}
+	cfg := c.committedConfig()
+	voters := c.quorumVoters()
+	window := c.electionTimeout()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.role != roleLeader {
+		return false
+	}
+	now := c.clock.Now()
+	if c.leaderTerm != c.state.CurrentTerm {
+		// first check of this term: give followers a full window
+		c.leaderTerm = c.state.CurrentTerm
+		c.leaderSince = now
+		return true
+	}
+	if now.Sub(c.leaderSince) < window {
+		return true
+	}
+	recent := func(id string) bool {
+		return id == c.nodeID || now.Sub(c.lastContact[id]) <= window
+	}
+	ok := false
+	if cfg != nil {
+		ok = cfg.HasQuorum(recent)
+	} else {
+		seen := 1
+		for _, id := range voters {
+			if recent(id) {
+				seen++
+			}
+		}
+		ok = seen >= (len(voters)+1)/2+1
+	}
+	if ok {
+		return true
+	}
+	c.role = roleFollower
+	c.peers.SetLeader("", c.state.CurrentTerm)
+	c.heartbeatFailures = 0
+	c.log(slog.LevelWarn, "leader_step_down_check_quorum", "term", c.state.CurrentTerm, "window", window)
+	c.audit("demotion", "leader stepped down: no quorum heard within election timeout", map[string]any{"term": c.state.CurrentTerm})
+	return false
+}
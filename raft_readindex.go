@@ -0,0 +1,182 @@
+package agendadistribuida
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- linearizable reads (ReadIndex) ---
+//
+// A read is linearizable if it observes every write committed before it
+// started. The leader records its commit index, confirms it is still leader
+// with a heartbeat round acknowledged by a quorum, and hands that index out as
+// the read index. Any node may then serve the read from local SQLite once its
+// LastApplied has reached the read index. Followers obtain the read index from
+// the leader through /raft/read-index.
+
+const OpRaftNoop = "raft.noop"
+
+var ErrNoLeader = errors.New("no known leader")
+
+// ReadIndexRequest is sent by a follower to the leader to obtain a read index.
+type ReadIndexRequest struct {
+	NodeID string `json:"node_id"`
+}
+
+type ReadIndexResponse struct {
+	Term      int64 `json:"term"`
+	ReadIndex int64 `json:"read_index"`
+}
+
+// ReadIndex returns an index such that, once LastApplied reaches it, local
+// state reflects every write committed before the call, and waits until this
+// node has applied it. It gives up after ApplyWaitTimeout.
+func (c *ConsensusImpl) ReadIndex(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.ApplyWaitTimeout)
+	defer cancel()
+	var (
+		idx int64
+		err error
+	)
+	if c.IsLeader() {
+		idx, err = c.leaderReadIndex(ctx)
+	} else {
+		idx, err = c.remoteReadIndex()
+	}
+	if err != nil {
+		c.log(slog.LevelWarn, "read_index_failed", "err", err)
+		return 0, err
+	}
+	if err := c.waitApplied(ctx, idx); err != nil {
+		return 0, err
+	}
+	return idx, nil
+}
+
+// leaderReadIndex implements the leader side of ReadIndex.
+func (c *ConsensusImpl) leaderReadIndex(ctx context.Context) (int64, error) {
+	c.mu.RLock()
+	term := c.state.CurrentTerm
+	commit := c.state.CommitIndex
+	c.mu.RUnlock()
+
+	// A new leader does not know which entries of previous terms are committed
+	// until it commits one of its own (Raft §6.4), so append a no-op first.
+	if appended, err := c.commitTermNoop(term, commit); err != nil {
+		return 0, err
+	} else if appended {
+		c.mu.RLock()
+		commit = c.state.CommitIndex
+		c.mu.RUnlock()
+	}
+
+	if err := c.broadcastAppendEntries(term, commit); err != nil {
+		return 0, err
+	}
+	c.mu.RLock()
+	stillLeader := c.role == roleLeader && c.state.CurrentTerm == term
+	c.mu.RUnlock()
+	if !stillLeader {
+		return 0, errors.New("lost leadership while confirming read index")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return commit, nil
+}
+
+// commitTermNoop commits a no-op of term, the leader's, unless commit is
+// already an index of that term. It reports whether it appended one.
+func (c *ConsensusImpl) commitTermNoop(term, commit int64) (bool, error) {
+	if t, err := c.logTermAt(commit); err == nil && t == term {
+		return false, nil
+	}
+	_, err := c.Propose(LogEntry{
+		EventID:     strconv.FormatInt(time.Now().UnixNano(), 10),
+		Aggregate:   "raft",
+		AggregateID: "noop",
+		Op:          OpRaftNoop,
+		Timestamp:   time.Now(),
+	})
+	return err == nil, err
+}
+
+// remoteReadIndex asks the current leader for a read index.
+func (c *ConsensusImpl) remoteReadIndex() (int64, error) {
+	leader := c.LeaderID()
+	if leader == "" || leader == c.nodeID {
+		return 0, ErrNoLeader
+	}
+	payload, _ := json.Marshal(ReadIndexRequest{NodeID: c.nodeID})
+	body, err := c.postJSONWithResponse("http://"+c.peerAddr(leader)+"/raft/read-index", payload)
+	if err != nil {
+		return 0, err
+	}
+	var resp ReadIndexResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, err
+	}
+	c.mu.RLock()
+	behind := resp.Term < c.state.CurrentTerm
+	c.mu.RUnlock()
+	if behind {
+		return 0, errors.New("read index from stale leader")
+	}
+	return resp.ReadIndex, nil
+}
+
+// HandleReadIndex serves /raft/read-index. Only the leader can answer.
+func (c *ConsensusImpl) HandleReadIndex(ctx context.Context, req ReadIndexRequest) (ReadIndexResponse, error) {
+	if !c.IsLeader() {
+		return ReadIndexResponse{}, errors.New("not leader")
+	}
+	idx, err := c.leaderReadIndex(ctx)
+	if err != nil {
+		return ReadIndexResponse{}, err
+	}
+	c.mu.RLock()
+	term := c.state.CurrentTerm
+	c.mu.RUnlock()
+	c.log(slog.LevelDebug, "read_index_served", "peer", req.NodeID, "read_index", idx)
+	return ReadIndexResponse{Term: term, ReadIndex: idx}, nil
+}
+
+// waitApplied blocks until LastApplied >= idx. Unlike waitForApplied it does
+// not require leadership, so followers can use it too.
+func (c *ConsensusImpl) waitApplied(ctx context.Context, idx int64) error {
+	for {
+		c.mu.RLock()
+		lastApplied := c.state.LastApplied
+		applyErr := c.applyErr
+		applyErrIndex := c.applyErrIndex
+		c.mu.RUnlock()
+		if lastApplied >= idx {
+			return nil
+		}
+		if applyErr != nil && applyErrIndex > 0 && applyErrIndex <= idx {
+			return errors.New("apply error while waiting for read index")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// wantsLinearizableRead reports whether a request opted into linearizable
+// consistency, via "X-Read-Consistency: linearizable" or
+// "?consistency=linearizable".
+func wantsLinearizableRead(r *http.Request) bool {
+	v := r.Header.Get("X-Read-Consistency")
+	if v == "" {
+		v = r.URL.Query().Get("consistency")
+	}
+	return strings.EqualFold(strings.TrimSpace(v), "linearizable")
+}
@@ -0,0 +1,355 @@
+package agendadistribuida
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// --- per-follower replication ---
+//
+// While leader, every follower has a long-lived replicator goroutine that owns
+// its nextIdx/matchIdx. It keeps up to pipelineDepth AppendEntries batches in
+// flight (at most maxBatch entries each), processes the replies in send order
+// and backs off exponentially, up to an election timeout, when the follower is
+// unreachable. Every acknowledgement recalculates the commit index, so Propose
+// only waits for its entry to be committed instead of driving a replication
+// round itself.
+
+type replicator struct {
+	c      *ConsensusImpl
+	peer   string
+	term   int64
+	notify chan struct{}
+	stop   chan struct{}
+}
+
+// appendResult is the outcome of one AppendEntries sent by a replicator.
+type appendResult struct {
+	lastIdx int64 // last index carried by the request (prev index for probes)
+	resp    AppendEntriesResponse
+	err     error
+}
+
+// ensureReplicators starts a replicator for every peer of the current term and
+// stops those of removed peers or older terms. It is a no-op on followers.
+func (c *ConsensusImpl) ensureReplicators() {
+	peers := c.replicationPeers()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.role != roleLeader {
+		return
+	}
+	term := c.state.CurrentTerm
+	want := make(map[string]bool, len(peers))
+	for _, id := range peers {
+		if id != c.nodeID {
+			want[id] = true
+		}
+	}
+	for id, r := range c.replicators {
+		if !want[id] || r.term != term {
+			close(r.stop)
+			delete(c.replicators, id)
+		}
+	}
+	for id := range want {
+		if _, ok := c.replicators[id]; ok {
+			continue
+		}
+		r := &replicator{c: c, peer: id, term: term, notify: make(chan struct{}, 1), stop: make(chan struct{})}
+		c.replicators[id] = r
+		go r.run()
+	}
+}
+
+// stopReplicators stops every replicator (on Stop).
+func (c *ConsensusImpl) stopReplicators() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, r := range c.replicators {
+		close(r.stop)
+		delete(c.replicators, id)
+	}
+}
+
+// kickReplicators wakes every replicator so new entries go out immediately.
+func (c *ConsensusImpl) kickReplicators() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, r := range c.replicators {
+		select {
+		case r.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitForCommit blocks until CommitIndex >= idx while still leader of term.
+func (c *ConsensusImpl) waitForCommit(idx, term int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.RLock()
+		commit := c.state.CommitIndex
+		stillLeader := c.role == roleLeader && c.state.CurrentTerm == term
+		c.mu.RUnlock()
+		if commit >= idx {
+			return nil
+		}
+		if !stillLeader {
+			return errors.New("lost leadership while waiting for commit")
+		}
+		if time.Now().After(deadline) {
+			return errors.New("append majority failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (r *replicator) leading() bool {
+	r.c.mu.RLock()
+	defer r.c.mu.RUnlock()
+	return r.c.role == roleLeader && r.c.state.CurrentTerm == r.term
+}
+
+func (r *replicator) run() {
+	c := r.c
+	c.log(slog.LevelDebug, "replicator_started", "peer", r.peer, "term", r.term)
+	defer func() {
+		c.mu.Lock()
+		if cur, ok := c.replicators[r.peer]; ok && cur == r {
+			delete(c.replicators, r.peer)
+		}
+		c.mu.Unlock()
+		c.log(slog.LevelDebug, "replicator_stopped", "peer", r.peer, "term", r.term)
+	}()
+
+	var backoff time.Duration
+	retryAt := time.Now()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-r.notify:
+			if backoff > 0 && time.Now().Before(retryAt) {
+				// Still backing off: new entries wait for the retry.
+				continue
+			}
+		case <-time.After(time.Until(retryAt)):
+		}
+		if !r.leading() {
+			return
+		}
+		if err := r.replicate(); err != nil {
+			if backoff == 0 {
+				backoff = 100 * time.Millisecond
+			} else if backoff *= 2; backoff > r.c.cfg.ElectionTimeout {
+				// A follower back online hears from us before it campaigns.
+				backoff = r.c.cfg.ElectionTimeout
+			}
+			c.log(slog.LevelDebug, "replicator_backoff", "peer", r.peer, "backoff", backoff, "err", err)
+			retryAt = time.Now().Add(backoff)
+			continue
+		}
+		backoff = 0
+		retryAt = time.Now().Add(c.cfg.HeartbeatInterval)
+	}
+}
+
+// replicate pipelines batches until the follower's matchIdx reaches the
+// leader's last index, or returns the error that interrupted it.
+func (r *replicator) replicate() error {
+	c := r.c
+	depth := int(c.pipelineDepth)
+	if depth < 1 {
+		depth = 1
+	}
+	var inflight []chan appendResult
+
+	c.mu.Lock()
+	next, ok := c.nextIdx[r.peer]
+	if !ok || next <= 0 {
+		lastIdx, _, _ := c.lastIndexTerm()
+		next = lastIdx + 1
+		c.nextIdx[r.peer] = next
+	}
+	c.mu.Unlock()
+
+	// drain waits for outstanding requests so their replies still update
+	// matchIdx before the caller resets nextIdx.
+	drain := func() {
+		for _, ch := range inflight {
+			if res := <-ch; res.err == nil && res.resp.Success {
+				r.onSuccess(res)
+			}
+		}
+		inflight = nil
+	}
+
+	for {
+		if !r.leading() {
+			drain()
+			return errors.New("not leader")
+		}
+		lastIdx, _, err := c.lastIndexTerm()
+		if err != nil {
+			drain()
+			return err
+		}
+		c.mu.RLock()
+		match := c.matchIdx[r.peer]
+		c.mu.RUnlock()
+
+		// Fill the window. A probe (no entries) is sent when the follower's
+		// position is unknown, i.e. nothing left to send but matchIdx < lastIdx.
+		for len(inflight) < depth && (next <= lastIdx || (len(inflight) == 0 && match < lastIdx)) {
+			if !c.hasLogTermAt(next - 1) {
+				if len(inflight) > 0 {
+					break
+				}
+				if err := c.sendSnapshot(r.peer, r.term); err != nil {
+					return err
+				}
+				c.mu.RLock()
+				next = c.nextIdx[r.peer]
+				c.mu.RUnlock()
+				c.recalculateCommitIndex()
+				continue
+			}
+			req, reqLast, err := r.buildRequest(next)
+			if err != nil {
+				drain()
+				return err
+			}
+			ch := make(chan appendResult, 1)
+			go func(req AppendEntriesRequest, last int64) {
+				resp, err := r.send(req)
+				ch <- appendResult{lastIdx: last, resp: resp, err: err}
+			}(req, reqLast)
+			inflight = append(inflight, ch)
+			next = reqLast + 1
+			if len(req.Entries) == 0 {
+				break
+			}
+		}
+		if len(inflight) == 0 {
+			return nil
+		}
+
+		res := <-inflight[0]
+		inflight = inflight[1:]
+		if res.err != nil {
+			// c.nextIdx only moves on acknowledgements, so the retry resends
+			// whatever was in flight.
+			drain()
+			return res.err
+		}
+		if res.resp.Term > r.term {
+			drain()
+			c.stepDownHigherTerm(res.resp.Term, r.term)
+			return errors.New("follower has higher term")
+		}
+		c.recordContact(r.peer)
+		if res.resp.Success {
+			r.onSuccess(res)
+			continue
+		}
+		// Rejected (log mismatch, or a batch overtaken by the previous one):
+		// let the outstanding replies land, then back up nextIdx. The follower
+		// reports its LastApplied, which is committed and therefore matches.
+		drain()
+		c.mu.Lock()
+		cur := c.nextIdx[r.peer]
+		cand := cur - 1
+		if hint := res.resp.MatchIndex + 1; hint < cand {
+			cand = hint
+		}
+		if floor := c.matchIdx[r.peer] + 1; cand < floor {
+			cand = floor
+		}
+		if cand < 1 {
+			cand = 1
+		}
+		c.nextIdx[r.peer] = cand
+		next = cand
+		c.mu.Unlock()
+		c.log(slog.LevelDebug, "replicator_rejected", "peer", r.peer, "next_index", cand)
+	}
+}
+
+// buildRequest builds an AppendEntries starting at next and returns the index
+// of its last entry (next-1 for a probe).
+func (r *replicator) buildRequest(next int64) (AppendEntriesRequest, int64, error) {
+	c := r.c
+	ents, err := c.loadLogEntriesFrom(next, c.maxBatch)
+	if err != nil {
+		return AppendEntriesRequest{}, 0, err
+	}
+	prevTerm, err := c.logTermAt(next - 1)
+	if err != nil {
+		return AppendEntriesRequest{}, 0, err
+	}
+	c.mu.RLock()
+	commit := c.state.CommitIndex
+	c.mu.RUnlock()
+	last := next - 1
+	if len(ents) > 0 {
+		last = ents[len(ents)-1].Index
+	}
+	return AppendEntriesRequest{
+		Term:         r.term,
+		LeaderID:     c.nodeID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  prevTerm,
+		Entries:      ents,
+		LeaderCommit: commit,
+	}, last, nil
+}
+
+func (r *replicator) send(req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	payload, _ := json.Marshal(req)
+	body, err := r.c.postJSONWithResponse("http://"+r.c.peerAddr(r.peer)+"/raft/append-entries", payload)
+	if err != nil {
+		return AppendEntriesResponse{}, err
+	}
+	var resp AppendEntriesResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return AppendEntriesResponse{}, err
+	}
+	return resp, nil
+}
+
+// onSuccess records an acknowledged batch and advances the commit index.
+func (r *replicator) onSuccess(res appendResult) {
+	c := r.c
+	match := res.lastIdx
+	if res.resp.MatchIndex > match {
+		match = res.resp.MatchIndex
+	}
+	c.mu.Lock()
+	if match > c.matchIdx[r.peer] {
+		c.matchIdx[r.peer] = match
+	}
+	if c.nextIdx[r.peer] <= match {
+		c.nextIdx[r.peer] = match + 1
+	}
+	c.mu.Unlock()
+	c.recalculateCommitIndex()
+}
+
+// stepDownHigherTerm turns the leader into a follower after a peer reported
+// a newer term.
+func (c *ConsensusImpl) stepDownHigherTerm(peerTerm, ourTerm int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if peerTerm <= c.state.CurrentTerm {
+		return
+	}
+	// step down even if the new term could not be stored
+	_ = c.setTermLocked(peerTerm, "")
+	c.role = roleFollower
+	c.peers.SetLeader("", c.state.CurrentTerm)
+	c.log(slog.LevelWarn, "leader_demoted_higher_term", "follower_term", peerTerm, "our_term", ourTerm)
+	c.audit("demotion", "leader demoted due to higher term from follower", map[string]any{"follower_term": peerTerm, "our_term": ourTerm})
+}
@@ -0,0 +1,145 @@
+package agendadistribuida
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- client sessions (exactly-once proposals) ---
+//
+// A client that may retry a write tags it with its client id and a sequence
+// number that grows with every new request (X-Client-ID / X-Request-Seq). The
+// pair travels in the log entry. When applying, the last sequence applied for
+// each client and its outcome are kept in raft_sessions (replicated and
+// snapshotted with the rest of the state machine), so a retried request that
+// reaches the log again, possibly through another leader, is answered with
+// the cached outcome instead of being applied twice. Entries without a client
+// id are applied as before.
+//
+// The session is written in the transaction of the entry (see txApplier), so
+// a crash never leaves the entry applied without its session. A session whose
+// last request is SessionRetention entries old expires when a later client
+// entry is applied; being tied to the log, expiry happens at the same index on
+// every replica.
+
+// ErrStaleRequest is returned for a request older than the last one applied
+// for its client; its outcome is no longer cached.
+var ErrStaleRequest = errors.New("apply conflict: request sequence already superseded")
+
+// ClientRequest identifies one request of a client session.
+type ClientRequest struct {
+	ClientID string
+	Seq      int64
+	// written records the entries proposed for the request, so its response
+	// reports their commit index (raft_staleness.go).
+	written *writeRecord
+}
+
+// ClientSession is the last request applied for a client and its outcome.
+type ClientSession struct {
+	ClientID string
+	Seq      int64
+	Index    int64
+	Result   ApplyResult
+	Err      string // domain error returned by the state machine, if any
+}
+
+// replayedError is the error cached for a duplicate request. It was benign
+// the first time, so it is never treated as an apply failure.
+type replayedError struct{ msg string }
+
+func (e *replayedError) Error() string { return e.msg }
+
+// clientRequestFromHTTP reads the client session headers. ok is false when the
+// request does not take part in a session.
+func clientRequestFromHTTP(r *http.Request) (ClientRequest, bool) {
+	id := strings.TrimSpace(r.Header.Get("X-Client-ID"))
+	seq, err := strconv.ParseInt(strings.TrimSpace(r.Header.Get("X-Request-Seq")), 10, 64)
+	if id == "" || err != nil || seq <= 0 {
+		return ClientRequest{}, false
+	}
+	return ClientRequest{ClientID: id, Seq: seq}, true
+}
+
+// stampClientRequest tags e with the client session headers of r, if any.
+func stampClientRequest(r *http.Request, e LogEntry) LogEntry {
+	cr, _ := clientRequestFromHTTP(r)
+	return cr.stamp(e)
+}
+
+// stamp tags e with the client session, if any.
+func (cr ClientRequest) stamp(e LogEntry) LogEntry {
+	if cr.ClientID != "" {
+		e.ClientID = cr.ClientID
+		e.Seq = cr.Seq
+	}
+	return e
+}
+
+// applyClientEntry applies e in t, deduplicating by client session.
+func (c *ConsensusImpl) applyClientEntry(t *entryTx, e LogEntry) (ApplyResult, error) {
+	if e.ClientID == "" {
+		return t.apply(e)
+	}
+	sess, err := t.store.GetClientSession(e.ClientID)
+	if err != nil {
+		return ApplyResult{Index: e.Index, Op: e.Op}, err
+	}
+	if sess != nil && e.Seq <= sess.Seq {
+		c.log(slog.LevelInfo, "client_request_deduplicated", "client_id", e.ClientID, "seq", e.Seq, "index", e.Index, "applied_index", sess.Index)
+		if e.Seq < sess.Seq {
+			return ApplyResult{Index: e.Index, Op: e.Op}, ErrStaleRequest
+		}
+		return sess.Result, sessionErr(sess)
+	}
+	res, err := t.apply(e)
+	if err != nil && !isIgnorableApplyError(e, err) {
+		// Not applied: the entry will be retried, so do not cache anything.
+		return res, err
+	}
+	next := &ClientSession{ClientID: e.ClientID, Seq: e.Seq, Index: e.Index, Result: res}
+	if err != nil {
+		next.Err = err.Error()
+	}
+	if serr := t.store.SaveClientSession(next); serr != nil {
+		return res, serr
+	}
+	if keep := c.cfg.SessionRetention; keep > 0 && e.Index > keep {
+		n, serr := t.store.ExpireClientSessions(e.Index - keep)
+		if serr != nil {
+			return res, serr
+		}
+		if n > 0 {
+			c.log(slog.LevelDebug, "client_sessions_expired", "count", n, "index", e.Index)
+		}
+	}
+	return res, err
+}
+
+// SessionResult returns the cached outcome of req if it was already applied
+// (found is true and err is the cached domain error). Callers use it before
+// validating a retried request, since validation would now see the effects of
+// the first attempt.
+func (c *ConsensusImpl) SessionResult(req ClientRequest) (res ApplyResult, found bool, err error) {
+	if req.ClientID == "" {
+		return ApplyResult{}, false, nil
+	}
+	sess, err := c.storage.GetClientSession(req.ClientID)
+	if err != nil {
+		return ApplyResult{}, false, err
+	}
+	if sess == nil || sess.Seq != req.Seq {
+		return ApplyResult{}, false, nil
+	}
+	return sess.Result, true, sessionErr(sess)
+}
+
+func sessionErr(sess *ClientSession) error {
+	if sess.Err == "" {
+		return nil
+	}
+	return &replayedError{msg: sess.Err}
+}
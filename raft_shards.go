@@ -0,0 +1,604 @@
+package agendadistribuida
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// --- multi-raft sharding ---
+//
+// With RAFT_SHARDS=N a node runs N+1 Raft groups instead of one: the meta
+// group, which owns the user accounts, and the shards s0..sN-1, which own the
+// groups and appointments. Each group elects its own leader and keeps its own
+// raft_log/raft_meta (and sessions, applied events and changes) in a database
+// of its own, next to the main one (agenda.db -> agenda.s0.db, see ShardDSN).
+// Every group applies its entries to the agenda tables of the main database,
+// so reads are unchanged.
+//
+// MultiRaft routes each entry by its key (routeEntry): group.* ops and group
+// appointments by group id, personal appointments by owner, updates,
+// deletions and invitations by the appointment they target, and everything
+// else (user.*) to the meta group. An entry whose group is led elsewhere is
+// forwarded to that leader (/raft/propose), and Propose returns once it is
+// applied locally too. Raft RPCs of a shard carry its name in X-Raft-Group and
+// are dispatched by RegisterShardHTTP. Every node must run the same number of
+// shards: changing it moves keys between groups.
+//
+// The meta group snapshots and compares only the tables it owns (metaTables),
+// so its log is compacted as without shards. Limits of this first version: a
+// shard snapshot would copy tables shared by every shard, so the shards take
+// no snapshots (their logs are not compacted) and run no digest checker;
+// backups do not include the shard logs.
+
+const (
+	// MetaGroup is the name of the group that owns the user accounts.
+	MetaGroup = "meta"
+	// RaftGroupHeader names the group a cluster request is meant for.
+	RaftGroupHeader = "X-Raft-Group"
+)
+
+// ErrNotAppliedLocally is returned, with the result, for an entry its group
+// leader committed but this node did not apply in time: the write succeeded,
+// only reading it back here may not find it yet.
+var ErrNotAppliedLocally = errors.New("committed but not applied on this node yet")
+
+// proposeFailed reports whether err means that a proposal did not commit.
+func proposeFailed(err error) bool {
+	return err != nil && !errors.Is(err, ErrNotAppliedLocally)
+}
+
+// ErrShardSnapshot is returned by the shard state machines, which cannot be
+// snapshotted on their own.
+var ErrShardSnapshot = errors.New("snapshots are not supported with shards")
+
+// ShardName returns the name of shard i.
+func ShardName(i int) string { return "s" + strconv.Itoa(i) }
+
+// shardFor returns the shard that owns key among n.
+func shardFor(key string, n int) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return ShardName(int(h.Sum32() % uint32(n)))
+}
+
+// ShardDSN returns the DSN of the database of group next to dsn
+// ("file:agenda.db?_fk=1" -> "file:agenda.s0.db?_fk=1").
+func ShardDSN(dsn, group string) string {
+	path, query, hasQuery := strings.Cut(dsn, "?")
+	prefix := ""
+	if strings.HasPrefix(path, "file:") {
+		prefix, path = "file:", strings.TrimPrefix(path, "file:")
+	}
+	ext := filepath.Ext(path)
+	out := prefix + strings.TrimSuffix(path, ext) + "." + group + ext
+	if hasQuery {
+		out += "?" + query
+	}
+	return out
+}
+
+// MultiRaft is the Consensus of a node running several Raft groups.
+type MultiRaft struct {
+	meta   *ConsensusImpl
+	shards []*ConsensusImpl
+	groups map[string]*ConsensusImpl
+	state  *Storage // main database, where every group applies its entries
+}
+
+// NewMultiRaft creates the meta group over storage and cfg.Shards shard groups
+// whose logs live in databases derived from dsn (see ShardDSN).
+func NewMultiRaft(nodeID string, storage *Storage, dsn string, peers PeerStore, cfg ConsensusConfig) (*MultiRaft, error) {
+	if cfg.Shards <= 0 {
+		return nil, errors.New("shards must be positive")
+	}
+	m := &MultiRaft{groups: map[string]*ConsensusImpl{}, state: storage}
+	m.meta = NewConsensus(nodeID, storage, peers, cfg)
+	m.meta.group = MetaGroup
+	m.meta.SetStateMachine(newMetaStateMachine(storage))
+	m.groups[MetaGroup] = m.meta
+	// The shards share the agenda tables, so none can snapshot or compare
+	// them on its own.
+	cfg.SnapshotThreshold = 0
+	cfg.DigestInterval = 0
+	for i := 0; i < int(cfg.Shards); i++ {
+		name := ShardName(i)
+		logStore, err := NewStorage(ShardDSN(dsn, name))
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("shard %s: %w", name, err)
+		}
+		g := NewConsensus(nodeID, logStore, &groupPeers{PeerStore: peers}, cfg)
+		g.group = name
+		g.SetStateMachine(&shardStateMachine{state: NewSQLiteStateMachine(storage), log: logStore})
+		m.shards = append(m.shards, g)
+		m.groups[name] = g
+	}
+	return m, nil
+}
+
+// Meta returns the meta group.
+func (m *MultiRaft) Meta() *ConsensusImpl { return m.meta }
+
+// Groups returns every group, the meta group first.
+func (m *MultiRaft) Groups() []*ConsensusImpl {
+	return append([]*ConsensusImpl{m.meta}, m.shards...)
+}
+
+// Group returns the group called name, or nil.
+func (m *MultiRaft) Group(name string) *ConsensusImpl { return m.groups[name] }
+
+func (m *MultiRaft) NodeID() string { return m.meta.NodeID() }
+
+// IsLeader reports true: any node accepts writes, since Propose forwards each
+// entry to the leader of its group. Leader-only background work uses
+// Background instead.
+func (m *MultiRaft) IsLeader() bool { return true }
+
+// LeaderID returns the leader of the meta group.
+func (m *MultiRaft) LeaderID() string { return m.meta.LeaderID() }
+
+// SetAdvertiseAddr sets the address of this node in every group. It must be
+// called before Start.
+func (m *MultiRaft) SetAdvertiseAddr(addr string) {
+	for _, g := range m.Groups() {
+		g.SetAdvertiseAddr(addr)
+	}
+}
+
+// NotePeerSeen forwards a contact to every group: they share their peers.
+func (m *MultiRaft) NotePeerSeen(id string) {
+	for _, g := range m.Groups() {
+		g.NotePeerSeen(id)
+	}
+}
+
+// Propose replicates entry through the group that owns it and returns once
+// this node applied it.
+func (m *MultiRaft) Propose(entry LogEntry) (ApplyResult, error) {
+	if entry.Op == OpApptCreatePersonal || entry.Op == OpApptCreateGroup {
+		var err error
+		if entry, err = m.withOwnerUsername(entry); err != nil {
+			return ApplyResult{}, err
+		}
+	}
+	name, err := m.routeEntry(entry)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	g := m.groups[name]
+	propose := g.forwardPropose
+	if g.IsLeader() {
+		propose = g.Propose
+	}
+	res, err := propose(entry)
+	res.Group = name
+	return res, err
+}
+
+// Raft RPCs without a group header belong to the meta group.
+
+func (m *MultiRaft) HandleAppendEntries(req AppendEntriesRequest) (AppendEntriesResponse, error) {
+	return m.meta.HandleAppendEntries(req)
+}
+
+func (m *MultiRaft) HandleRequestVote(req RequestVoteRequest) (RequestVoteResponse, error) {
+	return m.meta.HandleRequestVote(req)
+}
+
+func (m *MultiRaft) HandleInstallSnapshot(req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	return m.meta.HandleInstallSnapshot(req)
+}
+
+// ReadIndex waits until this node applied every write committed in any group
+// before the call, and returns the read index of the meta group.
+func (m *MultiRaft) ReadIndex(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.meta.cfg.ApplyWaitTimeout)
+	defer cancel()
+	for _, g := range m.shards {
+		if _, err := g.ReadIndex(ctx); err != nil {
+			return 0, fmt.Errorf("%s: %w", g.group, err)
+		}
+	}
+	return m.meta.ReadIndex(ctx)
+}
+
+// SessionResult looks the request up in every group: each keeps the sessions
+// of the entries it applied, and a request went to a single group.
+func (m *MultiRaft) SessionResult(req ClientRequest) (ApplyResult, bool, error) {
+	for _, g := range m.Groups() {
+		if res, found, err := g.SessionResult(req); found || err != nil {
+			res.Group = g.group
+			return res, found, err
+		}
+	}
+	return ApplyResult{}, false, nil
+}
+
+func (m *MultiRaft) Start() error {
+	for _, g := range m.Groups() {
+		if err := g.Start(); err != nil {
+			return fmt.Errorf("start group %s: %w", g.group, err)
+		}
+	}
+	return nil
+}
+
+func (m *MultiRaft) Stop() error {
+	for _, g := range m.Groups() {
+		g.Stop()
+	}
+	return nil
+}
+
+// Close closes the databases of the shard logs, after Stop.
+func (m *MultiRaft) Close() error {
+	var errs []error
+	for _, g := range m.shards {
+		errs = append(errs, g.storage.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Background returns m reporting the leadership of the meta group, for the
+// reconcilers, which must run on a single node.
+func (m *MultiRaft) Background() Consensus { return metaLed{m} }
+
+type metaLed struct{ *MultiRaft }
+
+func (m metaLed) IsLeader() bool { return m.meta.IsLeader() }
+
+// --- routing ---
+
+// routeEntry returns the group that owns e.
+func (m *MultiRaft) routeEntry(e LogEntry) (string, error) {
+	key, err := m.shardKey(e)
+	if err != nil {
+		return "", fmt.Errorf("route %s: %w", e.Op, err)
+	}
+	if key == "" {
+		return MetaGroup, nil
+	}
+	return shardFor(key, len(m.shards)), nil
+}
+
+// shardKey returns the group or user id e is routed by, or "" for the meta
+// group.
+func (m *MultiRaft) shardKey(e LogEntry) (string, error) {
+	var keys struct {
+		GroupID       string    `json:"group_id"`
+		OwnerID       string    `json:"owner_id"`
+		UserID        string    `json:"user_id"`
+		AppointmentID string    `json:"appointment_id"`
+		Name          string    `json:"name"`
+		CreatorUser   string    `json:"creator_username"`
+		GroupType     GroupType `json:"group_type"`
+	}
+	switch e.Op {
+	case OpGroupCreate, OpGroupUpdate, OpGroupDelete, OpGroupMemberAdd, OpGroupMemberUpdate, OpGroupMemberRemove,
+		OpRepairEnsureGroupMember, OpApptCreateGroup, OpApptCreatePersonal,
+		OpApptUpdate, OpApptDelete, OpInvitationAccept, OpInvitationReject, OpRepairEnsureParticipant,
+		OpRepairEnsureNotification:
+	default:
+		return "", nil
+	}
+	up, err := upcastEntry(e)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(up.Payload), &keys); err != nil {
+		return "", err
+	}
+	switch e.Op {
+	case OpGroupCreate:
+		// The id the group will get when applied.
+		return GroupIDFromSignature(keys.GroupType, keys.CreatorUser, keys.Name), nil
+	case OpApptCreatePersonal:
+		return keys.OwnerID, nil
+	case OpRepairEnsureNotification:
+		return keys.UserID, nil
+	case OpApptUpdate, OpApptDelete, OpInvitationAccept, OpInvitationReject, OpRepairEnsureParticipant:
+		return m.appointmentKey(context.Background(), keys.AppointmentID)
+	default:
+		return keys.GroupID, nil
+	}
+}
+
+// withOwnerUsername resolves the username of the owner of an appointment
+// creation into its payload (see apptCreatePayload): the shard applying it
+// must not wait for the meta group to apply the owner. The owner was created
+// before the request, so a meta ReadIndex brings it here if it is missing.
+func (m *MultiRaft) withOwnerUsername(e LogEntry) (LogEntry, error) {
+	up, err := upcastEntry(e)
+	if err != nil {
+		return e, err
+	}
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(up.Payload), &p); err != nil {
+		return e, err
+	}
+	var owner, username string
+	_ = json.Unmarshal(p["owner_id"], &owner)
+	_ = json.Unmarshal(p["owner_username"], &username)
+	if username != "" {
+		return up, nil
+	}
+	u, err := m.state.GetUserByID(owner)
+	if err != nil || u == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), m.meta.cfg.ApplyWaitTimeout)
+		defer cancel()
+		if _, err := m.meta.ReadIndex(ctx); err != nil {
+			return e, fmt.Errorf("resolve owner %s: %w", owner, err)
+		}
+		if u, err = m.state.GetUserByID(owner); err != nil || u == nil {
+			return e, fmt.Errorf("%w: owner %s not found", ErrInvalidInput, owner)
+		}
+	}
+	p["owner_username"], _ = json.Marshal(u.Username)
+	b, err := json.Marshal(p)
+	if err != nil {
+		return e, err
+	}
+	up.Payload = string(b)
+	return up, nil
+}
+
+// appointmentShardKey returns the key an appointment is routed by: its group,
+// or its owner for personal appointments. Deleted appointments keep theirs.
+func (s *Storage) appointmentShardKey(id string) (string, error) {
+	var owner string
+	var group sql.NullString
+	err := s.db.QueryRow(`SELECT owner_id, group_id FROM appointments WHERE id=?`, id).Scan(&owner, &group)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("appointment %s: %w", id, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	if group.Valid && group.String != "" {
+		return group.String, nil
+	}
+	return owner, nil
+}
+
+// appointmentKey returns the shard key of appointment id. An appointment
+// this node has not applied yet is looked up again once every shard caught up
+// with its leader, rather than reporting it missing.
+func (m *MultiRaft) appointmentKey(ctx context.Context, id string) (string, error) {
+	key, err := m.state.appointmentShardKey(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return key, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.meta.cfg.ApplyWaitTimeout)
+	defer cancel()
+	for _, g := range m.shards {
+		if _, err := g.ReadIndex(ctx); err != nil {
+			return "", fmt.Errorf("look up appointment %s in %s: %w", id, g.group, err)
+		}
+	}
+	return m.state.appointmentShardKey(id)
+}
+
+// requestGroup returns the group an API request is about, if it can tell
+// from the path: account requests belong to the meta group, /api/groups/{id}
+// and /api/appointments/{id} to the shard that owns them.
+func (m *MultiRaft) requestGroup(r *http.Request) *ConsensusImpl {
+	path := r.URL.Path
+	if path == "/register" || path == "/login" || path == "/api/me" || strings.HasPrefix(path, "/api/me/") {
+		return m.meta
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/api/"), "/")
+	if !strings.HasPrefix(path, "/api/") || len(parts) < 2 || parts[1] == "" {
+		return nil
+	}
+	switch parts[0] {
+	case "groups":
+		return m.groups[shardFor(parts[1], len(m.shards))]
+	case "appointments":
+		key, err := m.appointmentKey(r.Context(), parts[1])
+		if err != nil {
+			return nil
+		}
+		return m.groups[shardFor(key, len(m.shards))]
+	}
+	return nil
+}
+
+// progressGroup is the group named by the X-Raft-Group header of r, else
+// about, the group r is about (requestGroup), else the meta group (see
+// raft_staleness.go).
+func (m *MultiRaft) progressGroup(r *http.Request, about *ConsensusImpl) *ConsensusImpl {
+	if g := m.groups[r.Header.Get(RaftGroupHeader)]; g != nil {
+		return g
+	}
+	if about != nil {
+		return about
+	}
+	return m.meta
+}
+
+// serveSharded is LeaderWriteMiddleware for a node running shards: requests
+// about one group are proxied to its leader (reads included, like with a
+// single group), the rest are served here and their entries forwarded by
+// Propose.
+func (m *MultiRaft) serveSharded(w http.ResponseWriter, r *http.Request, next http.Handler, leaderAddrResolver func(string) string) {
+	g := m.requestGroup(r)
+	if serveBoundedRead(w, r, next, m.progressGroup(r, g), leaderAddrResolver) {
+		return
+	}
+	if g == nil || g.IsLeader() || (r.Method == http.MethodGet && wantsLinearizableRead(r)) {
+		next.ServeHTTP(w, r)
+		return
+	}
+	if leaderID := g.LeaderID(); leaderID != "" {
+		proxyRequestToLeader(w, r, g, leaderAddrResolver(leaderID))
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// --- forwarding ---
+
+// ProposeResponse answers a proposal forwarded to a group leader.
+type ProposeResponse struct {
+	Result ApplyResult `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// forwardPropose proposes entry through the leader of c's group and waits
+// until this node applied it, so the caller reads its own write. If it does
+// not get there in time the committed result comes with ErrNotAppliedLocally.
+func (c *ConsensusImpl) forwardPropose(entry LogEntry) (ApplyResult, error) {
+	leader := c.LeaderID()
+	if leader == "" || leader == c.nodeID {
+		return ApplyResult{}, ErrNoLeader
+	}
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	body, err := c.postJSONWithResponse("http://"+c.peerAddr(leader)+"/raft/propose", payload)
+	if err != nil {
+		c.log(slog.LevelWarn, "propose_forward_failed", "leader", leader, "op", entry.Op, "err", err)
+		return ApplyResult{}, fmt.Errorf("propose through %s: %w", leader, err)
+	}
+	var resp ProposeResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ApplyResult{}, err
+	}
+	if resp.Error != "" {
+		return resp.Result, remoteProposeError(resp.Error)
+	}
+	if resp.Result.Index > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.ApplyWaitTimeout)
+		defer cancel()
+		if err := c.waitApplied(ctx, resp.Result.Index); err != nil {
+			c.log(slog.LevelWarn, "propose_forward_not_applied", "index", resp.Result.Index, "op", entry.Op, "err", err)
+			return resp.Result, fmt.Errorf("%w: index %d: %v", ErrNotAppliedLocally, resp.Result.Index, err)
+		}
+	}
+	return resp.Result, nil
+}
+
+// remoteProposeError restores the sentinel errors callers check for.
+func remoteProposeError(msg string) error {
+	for _, known := range []error{ErrStaleRequest, ErrEntrySkipped, ErrLeadershipTransfer, ErrNoLeader} {
+		if msg == known.Error() {
+			return known
+		}
+	}
+	return errors.New(msg)
+}
+
+// --- shard plumbing ---
+
+// groupPeers is the PeerStore of a shard: the peers of the node, but a leader
+// of its own.
+type groupPeers struct {
+	PeerStore
+
+	mu         sync.RWMutex
+	leader     string
+	leaderTerm int64
+}
+
+func (p *groupPeers) SetLeader(id string, term int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if term < p.leaderTerm {
+		return false
+	}
+	p.leader, p.leaderTerm = id, term
+	return true
+}
+
+func (p *groupPeers) GetLeader() string { p.mu.RLock(); defer p.mu.RUnlock(); return p.leader }
+
+func (p *groupPeers) Leader() (string, int64) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.leader, p.leaderTerm
+}
+
+// shardStateMachine applies a shard's entries to the main database while
+// the shard keeps its applied events in its own.
+type shardStateMachine struct {
+	state *SQLiteStateMachine
+	log   *Storage
+}
+
+func (m *shardStateMachine) Apply(e LogEntry) (ApplyResult, error) { return m.state.Apply(e) }
+
+func (m *shardStateMachine) Snapshot() ([]byte, error) { return nil, ErrShardSnapshot }
+
+func (m *shardStateMachine) Restore([]byte) error { return ErrShardSnapshot }
+
+// LastAppliedIndex reads the shard's own raft_applied.
+func (m *shardStateMachine) LastAppliedIndex() int64 {
+	return NewSQLiteStateMachine(m.log).LastAppliedIndex()
+}
+
+// RegisterShardHTTP serves the Raft endpoints of the shards of m: /raft/
+// requests naming a shard in X-Raft-Group go to that group, the rest to the
+// routes of the meta group registered on r.
+func RegisterShardHTTP(r *mux.Router, m *MultiRaft) {
+	routers := map[string]http.Handler{}
+	for _, g := range m.shards {
+		sr := mux.NewRouter()
+		RegisterRaftHTTP(sr, g, m.state)
+		routers[g.group] = sr
+	}
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			name := req.Header.Get(RaftGroupHeader)
+			if name == "" || name == MetaGroup || !strings.HasPrefix(req.URL.Path, "/raft/") {
+				next.ServeHTTP(w, req)
+				return
+			}
+			h, ok := routers[name]
+			if !ok {
+				http.Error(w, "unknown raft group "+name, http.StatusNotFound)
+				return
+			}
+			h.ServeHTTP(w, req)
+		})
+	})
+}
+
+// groupConsensus narrows cons to the group a cluster request names, if any.
+func groupConsensus(cons Consensus, r *http.Request) Consensus {
+	if m, ok := cons.(*MultiRaft); ok {
+		if g := m.Group(r.Header.Get(RaftGroupHeader)); g != nil {
+			return g
+		}
+	}
+	return cons
+}
+
+// membershipChange applies a join/leave/promote to every group; followers
+// relay it to the leader of each group.
+func (m *MultiRaft) membershipChange(path string, body any, forwarded bool, apply func(*ConsensusImpl) error) string {
+	var statuses, out []string
+	for _, g := range m.Groups() {
+		status := membershipChange(g, path, body, forwarded, apply)
+		statuses = append(statuses, status)
+		out = append(out, g.group+"="+status)
+	}
+	for _, status := range statuses[1:] {
+		if status != statuses[0] {
+			return strings.Join(out, " ")
+		}
+	}
+	return statuses[0]
+}
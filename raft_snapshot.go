@@ -0,0 +1,353 @@
+package agendadistribuida
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// --- log compaction / snapshots ---
+//
+// The state machine (see stateMachineTables) is periodically serialized into
+// raft_snapshot at LastApplied and raft_log is truncated below that index. A
+// follower whose nextIdx points into the compacted prefix receives the snapshot
+// through /raft/install-snapshot instead of the (no longer available) entries.
+
+// snapshotMeta returns the index/term covered by the latest snapshot. It reads
+// raft_meta directly so it is safe to call with c.mu held.
+func (c *ConsensusImpl) snapshotMeta() (int64, int64, error) {
+	rows, err := c.storage.db.Query(`SELECT key, value FROM raft_meta WHERE key IN ('snapshotIndex','snapshotTerm')`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	var idx, term int64
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return 0, 0, err
+		}
+		switch k {
+		case "snapshotIndex":
+			idx = parseInt64Default(v, 0)
+		case "snapshotTerm":
+			term = parseInt64Default(v, 0)
+		}
+	}
+	return idx, term, rows.Err()
+}
+
+// hasLogTermAt reports whether the term of idx is still known locally, either
+// from raft_log or because idx is exactly the snapshot boundary.
+func (c *ConsensusImpl) hasLogTermAt(idx int64) bool {
+	if idx <= 0 {
+		return true
+	}
+	var dummy int
+	err := c.storage.db.QueryRow(`SELECT 1 FROM raft_log WHERE idx=?`, idx).Scan(&dummy)
+	if err == nil {
+		return true
+	}
+	snapIdx, _, serr := c.snapshotMeta()
+	return serr == nil && idx == snapIdx
+}
+
+func (c *ConsensusImpl) loadSnapshot() (int64, int64, []byte, error) {
+	var idx, term int64
+	var data []byte
+	err := c.storage.db.QueryRow(`SELECT last_index, last_term, data FROM raft_snapshot WHERE id=1`).Scan(&idx, &term, &data)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil, nil
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return idx, term, data, nil
+}
+
+func persistMetaTx(tx *sql.Tx, key, val string) error {
+	_, err := tx.Exec(`INSERT INTO raft_meta(key,value) VALUES(?,?)
+        ON CONFLICT(key) DO UPDATE SET value=excluded.value`, key, val)
+	return err
+}
+
+func saveSnapshotTx(tx *sql.Tx, idx, term int64, data []byte) error {
+	if _, err := tx.Exec(`INSERT INTO raft_snapshot(id, last_index, last_term, data, created_at) VALUES(1,?,?,?,?)
+        ON CONFLICT(id) DO UPDATE SET last_index=excluded.last_index, last_term=excluded.last_term, data=excluded.data, created_at=excluded.created_at`,
+		idx, term, data, time.Now()); err != nil {
+		return err
+	}
+	if err := persistMetaTx(tx, "snapshotIndex", intToString(idx)); err != nil {
+		return err
+	}
+	return persistMetaTx(tx, "snapshotTerm", intToString(term))
+}
+
+// maybeSnapshot takes a snapshot once enough entries were applied since the
+// previous one. It is called from the main loop after applying entries.
+func (c *ConsensusImpl) maybeSnapshot() {
+	if c.snapshotThreshold <= 0 {
+		return
+	}
+	c.mu.RLock()
+	pending := c.state.LastApplied - c.state.SnapshotIndex
+	c.mu.RUnlock()
+	if pending < c.snapshotThreshold {
+		return
+	}
+	if _, err := c.takeSnapshot(); err != nil {
+		c.log(slog.LevelWarn, "snapshot_failed", "err", err)
+	}
+}
+
+// takeSnapshot serializes the state machine at LastApplied, stores it and
+// compacts raft_log. It returns the snapshot index.
+func (c *ConsensusImpl) takeSnapshot() (int64, error) {
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	c.mu.RLock()
+	applied := c.state.LastApplied
+	prevSnap := c.state.SnapshotIndex
+	sm := c.sm
+	c.mu.RUnlock()
+	if applied <= prevSnap {
+		return prevSnap, nil
+	}
+	if sm == nil {
+		return 0, errNoStateMachine
+	}
+	term, err := c.logTermAt(applied)
+	if err != nil {
+		return 0, err
+	}
+	data, err := sm.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.storage.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if err := saveSnapshotTx(tx, applied, term, data); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	// Keep a short tail below the snapshot so followers that are only a few
+	// entries behind can still be served with AppendEntries.
+	res, err := tx.Exec(`DELETE FROM raft_log WHERE idx <= ?`, applied-c.snapshotTrailing)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if keep := c.cfg.ChangeRetention; keep > 0 && applied > keep {
+		if err := compactChangesTx(tx, applied-keep); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	compacted, _ := res.RowsAffected()
+
+	c.mu.Lock()
+	c.state.SnapshotIndex = applied
+	c.state.SnapshotTerm = term
+	c.mu.Unlock()
+	c.log(slog.LevelInfo, "snapshot_taken", "index", applied, "term", term, "bytes", len(data), "compacted", compacted)
+	c.audit("snapshot", "state machine snapshot taken", map[string]any{"index": applied, "term": term, "compacted": compacted})
+	return applied, nil
+}
+
+// sendSnapshot installs the latest local snapshot on a follower and advances
+// its nextIdx/matchIdx past it.
+func (c *ConsensusImpl) sendSnapshot(pid string, term int64) error {
+	idx, snapTerm, data, err := c.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	if idx == 0 {
+		return errors.New("no snapshot available")
+	}
+	req := InstallSnapshotRequest{
+		Term:              term,
+		LeaderID:          c.nodeID,
+		LastIncludedIndex: idx,
+		LastIncludedTerm:  snapTerm,
+		Data:              data,
+		Config:            c.committedConfig(),
+	}
+	payload, _ := json.Marshal(req)
+	respBody, err := c.postJSONWithResponse("http://"+c.peerAddr(pid)+"/raft/install-snapshot", payload)
+	if err != nil {
+		return err
+	}
+	var resp InstallSnapshotResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return err
+	}
+	if resp.Term > term {
+		c.mu.Lock()
+		if resp.Term > c.state.CurrentTerm {
+			// step down even if the new term could not be stored
+			_ = c.setTermLocked(resp.Term, "")
+			c.role = roleFollower
+			c.peers.SetLeader("", c.state.CurrentTerm)
+			c.log(slog.LevelWarn, "leader_demoted_higher_term", "follower_term", resp.Term, "our_term", term)
+			c.audit("demotion", "leader demoted due to higher term from follower", map[string]any{"follower_term": resp.Term, "our_term": term})
+		}
+		c.mu.Unlock()
+		return errors.New("follower has higher term")
+	}
+	if !resp.Success {
+		return errors.New("snapshot rejected")
+	}
+	c.mu.Lock()
+	if c.matchIdx[pid] < idx {
+		c.matchIdx[pid] = idx
+	}
+	c.nextIdx[pid] = idx + 1
+	c.mu.Unlock()
+	c.log(slog.LevelInfo, "install_snapshot_sent", "peer", pid, "index", idx, "bytes", len(data))
+	c.audit("snapshot", "snapshot installed on follower", map[string]any{"peer": pid, "index": idx, "term": snapTerm})
+	return nil
+}
+
+// HandleInstallSnapshot replaces the local state machine with the leader's
+// snapshot. Log entries after the snapshot are kept only if the entry at
+// LastIncludedIndex matches LastIncludedTerm; otherwise the whole log is dropped.
+func (c *ConsensusImpl) HandleInstallSnapshot(req InstallSnapshotRequest) (InstallSnapshotResponse, error) {
+	c.mu.Lock()
+	if req.Term < c.state.CurrentTerm {
+		resp := InstallSnapshotResponse{Term: c.state.CurrentTerm, Success: false}
+		c.mu.Unlock()
+		c.log(slog.LevelDebug, "install_snapshot_reject_old_term", "term", req.Term)
+		return resp, nil
+	}
+	if req.Term > c.state.CurrentTerm {
+		if err := c.setTermLocked(req.Term, ""); err != nil {
+			resp := InstallSnapshotResponse{Term: c.state.CurrentTerm, Success: false}
+			c.mu.Unlock()
+			return resp, err
+		}
+	}
+	if c.role != roleFollower && req.LeaderID != c.nodeID {
+		c.log(slog.LevelWarn, "leader_demoted_install_snapshot", "leader_id", req.LeaderID, "term", req.Term)
+		c.role = roleFollower
+		c.heartbeatFailures = 0
+	}
+	c.peers.SetLeader(req.LeaderID, req.Term)
+	term := c.state.CurrentTerm
+	c.mu.Unlock()
+
+	select {
+	case c.resetElectionTimer <- struct{}{}:
+	default:
+	}
+
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	c.mu.RLock()
+	applied := c.state.LastApplied
+	sm := c.sm
+	c.mu.RUnlock()
+	if req.LastIncludedIndex <= applied {
+		// We already applied everything the snapshot covers.
+		return InstallSnapshotResponse{Term: term, Success: true}, nil
+	}
+	if sm == nil {
+		return InstallSnapshotResponse{Term: term, Success: false}, errNoStateMachine
+	}
+
+	var localTerm int64
+	keepSuffix := false
+	if err := c.storage.db.QueryRow(`SELECT term FROM raft_log WHERE idx=?`, req.LastIncludedIndex).Scan(&localTerm); err == nil {
+		keepSuffix = localTerm == req.LastIncludedTerm
+	}
+
+	tx, err := c.storage.db.Begin()
+	if err != nil {
+		return InstallSnapshotResponse{Term: term, Success: false}, err
+	}
+	fail := func(err error) (InstallSnapshotResponse, error) {
+		tx.Rollback()
+		c.log(slog.LevelError, "install_snapshot_failed", "index", req.LastIncludedIndex, "err", err)
+		return InstallSnapshotResponse{Term: term, Success: false}, err
+	}
+	// A state machine living outside SQLite cannot join the transaction and
+	// is restored on its own.
+	if r, ok := sm.(txRestorer); ok {
+		err = r.restoreTx(tx, req.Data)
+	} else {
+		err = sm.Restore(req.Data)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := saveSnapshotTx(tx, req.LastIncludedIndex, req.LastIncludedTerm, req.Data); err != nil {
+		return fail(err)
+	}
+	if keepSuffix {
+		_, err = tx.Exec(`DELETE FROM raft_log WHERE idx <= ?`, req.LastIncludedIndex)
+	} else {
+		_, err = tx.Exec(`DELETE FROM raft_log`)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	c.mu.RLock()
+	commit := c.state.CommitIndex
+	c.mu.RUnlock()
+	if commit < req.LastIncludedIndex {
+		commit = req.LastIncludedIndex
+	}
+	if err := persistMetaTx(tx, "commitIndex", intToString(commit)); err != nil {
+		return fail(err)
+	}
+	if err := persistMetaTx(tx, "lastApplied", intToString(req.LastIncludedIndex)); err != nil {
+		return fail(err)
+	}
+	// The entries the snapshot replaces were never applied here, so their
+	// changes cannot be published.
+	if err := compactChangesTx(tx, req.LastIncludedIndex); err != nil {
+		return fail(err)
+	}
+	if req.Config != nil {
+		b, err := json.Marshal(req.Config)
+		if err != nil {
+			return fail(err)
+		}
+		if err := persistMetaTx(tx, "config", string(b)); err != nil {
+			return fail(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fail(err)
+	}
+
+	c.mu.Lock()
+	if req.Config != nil {
+		c.config = req.Config
+	}
+	c.state.SnapshotIndex = req.LastIncludedIndex
+	c.state.SnapshotTerm = req.LastIncludedTerm
+	c.state.LastApplied = req.LastIncludedIndex
+	if c.state.CommitIndex < commit {
+		c.state.CommitIndex = commit
+	}
+	if c.applyErr != nil && c.applyErrIndex <= req.LastIncludedIndex {
+		c.applyErr = nil
+		c.applyErrIndex = 0
+	}
+	c.mu.Unlock()
+	// Consumers waiting for changes now find their checkpoint compacted.
+	c.notifyChanges()
+	c.log(slog.LevelInfo, "install_snapshot_applied", "leader_id", req.LeaderID, "index", req.LastIncludedIndex, "term", req.LastIncludedTerm, "kept_suffix", keepSuffix)
+	c.audit("snapshot", "snapshot installed from leader", map[string]any{"leader_id": req.LeaderID, "index": req.LastIncludedIndex, "term": req.LastIncludedTerm})
+	return InstallSnapshotResponse{Term: term, Success: true}, nil
+}
@@ -0,0 +1,241 @@
+package agendadistribuida
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- bounded-staleness reads ---
+//
+// Every API response tells the client how far the node that answered has
+// got: X-Raft-Applied-Index and X-Raft-Term (and X-Raft-Group with shards).
+// A write response also carries X-Raft-Commit-Index, the index its entry was
+// committed at. A read may then bound how stale it can be served:
+//
+//   - X-Min-Applied-Index: only once the node applied that index, typically
+//     the commit index of the client's last write (read-your-writes).
+//   - X-Max-Staleness: only if the node applied everything the leader had
+//     committed when it last heard from it, at most that long ago ("500ms",
+//     "2s" or milliseconds).
+//
+// Followers serve such reads locally once they meet the bound and send them
+// to the leader if they do not within boundedReadWait. The leader is never
+// stale, so only X-Min-Applied-Index can hold it up.
+
+const (
+	AppliedIndexHeader    = "X-Raft-Applied-Index"
+	TermHeader            = "X-Raft-Term"
+	CommitIndexHeader     = "X-Raft-Commit-Index"
+	MinAppliedIndexHeader = "X-Min-Applied-Index"
+	MaxStalenessHeader    = "X-Max-Staleness"
+)
+
+// boundedReadWait is how long a node waits to meet a read bound before it
+// sends the read to the leader.
+const boundedReadWait = 2 * time.Second
+
+var errReadBound = errors.New("read bound not met")
+
+// readBound is the staleness a read accepts.
+type readBound struct {
+	minIndex    int64
+	maxStale    time.Duration
+	hasMaxStale bool
+}
+
+// readBoundFromHTTP reads the bound headers of r. ok is false when r sets
+// none of them.
+func readBoundFromHTTP(r *http.Request) (b readBound, ok bool, err error) {
+	if v := strings.TrimSpace(r.Header.Get(MinAppliedIndexHeader)); v != "" {
+		b.minIndex, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || b.minIndex < 0 {
+			return b, false, fmt.Errorf("invalid %s %q", MinAppliedIndexHeader, v)
+		}
+		ok = true
+	}
+	if v := strings.TrimSpace(r.Header.Get(MaxStalenessHeader)); v != "" {
+		b.maxStale, err = time.ParseDuration(v)
+		if err != nil {
+			ms, perr := strconv.ParseInt(v, 10, 64)
+			b.maxStale, err = time.Duration(ms)*time.Millisecond, perr
+		}
+		if err != nil || b.maxStale < 0 {
+			return b, false, fmt.Errorf("invalid %s %q", MaxStalenessHeader, v)
+		}
+		b.hasMaxStale = true
+		ok = true
+	}
+	return b, ok, nil
+}
+
+// noteLeaderContactLocked records that the leader, with commit index commit,
+// was just heard from. Caller holds c.mu.
+func (c *ConsensusImpl) noteLeaderContactLocked(commit int64) {
+	c.contactAt = c.clock.Now()
+	c.contactCommit = commit
+	if c.state.LastApplied >= commit {
+		c.freshAt = c.contactAt
+	}
+}
+
+// meetsBound reports whether local state is fresh enough for a read bounded
+// by b.
+func (c *ConsensusImpl) meetsBound(b readBound) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.state.LastApplied < b.minIndex {
+		return false
+	}
+	if !b.hasMaxStale || c.role == roleLeader {
+		return true
+	}
+	return !c.freshAt.IsZero() && c.clock.Now().Sub(c.freshAt) <= b.maxStale
+}
+
+// waitBound blocks until local state meets b.
+func (c *ConsensusImpl) waitBound(ctx context.Context, b readBound) error {
+	for !c.meetsBound(b) {
+		select {
+		case <-ctx.Done():
+			return errReadBound
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// serveBoundedRead serves a GET carrying a read bound here once g meets it,
+// or through the leader of g. It reports false, having done nothing, when r
+// is not such a read.
+func serveBoundedRead(w http.ResponseWriter, r *http.Request, next http.Handler, g *ConsensusImpl, leaderAddrResolver func(string) string) bool {
+	if r.Method != http.MethodGet || g == nil {
+		return false
+	}
+	b, ok, err := readBoundFromHTTP(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	if !ok {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), boundedReadWait)
+	defer cancel()
+	if err := g.waitBound(ctx, b); err == nil {
+		next.ServeHTTP(w, r)
+		return true
+	}
+	leaderID := g.LeaderID()
+	if leaderID == "" || leaderID == g.nodeID {
+		g.log(slog.LevelWarn, "bounded_read_failed", "path", r.URL.Path, "min_index", b.minIndex, "max_staleness", b.maxStale)
+		http.Error(w, errReadBound.Error(), http.StatusServiceUnavailable)
+		return true
+	}
+	g.log(slog.LevelDebug, "bounded_read_to_leader", "path", r.URL.Path, "leader", leaderID, "min_index", b.minIndex, "max_staleness", b.maxStale)
+	proxyRequestToLeader(w, r, g, leaderAddrResolver(leaderID))
+	return true
+}
+
+// progressGroup is the group whose progress a response to r reports and
+// whose bounds r is served under.
+func progressGroup(cons Consensus, r *http.Request) *ConsensusImpl {
+	switch c := cons.(type) {
+	case *ConsensusImpl:
+		return c
+	case *MultiRaft:
+		return c.progressGroup(r, c.requestGroup(r))
+	}
+	return nil
+}
+
+// --- reporting progress ---
+
+type writeRecordKey struct{}
+
+// writeRecord is the last entry a request wrote.
+type writeRecord struct {
+	index int64
+	group string
+}
+
+func withWriteRecord(ctx context.Context, rec *writeRecord) context.Context {
+	return context.WithValue(ctx, writeRecordKey{}, rec)
+}
+
+func writeRecordFrom(ctx context.Context) *writeRecord {
+	rec, _ := ctx.Value(writeRecordKey{}).(*writeRecord)
+	return rec
+}
+
+// note records res as written; rec may be nil.
+func (rec *writeRecord) note(res ApplyResult) {
+	if rec != nil && res.Index > 0 {
+		rec.index = res.Index
+		rec.group = res.Group
+	}
+}
+
+// noteWrite records res as the write of the request of ctx.
+func noteWrite(ctx context.Context, res ApplyResult) {
+	writeRecordFrom(ctx).note(res)
+}
+
+// progressWriter sets the progress headers just before the response header
+// is written, so a write response reports state after the write.
+type progressWriter struct {
+	http.ResponseWriter
+	set     func(http.Header)
+	written bool
+}
+
+func (pw *progressWriter) WriteHeader(status int) {
+	if !pw.written {
+		pw.written = true
+		pw.set(pw.Header())
+	}
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	if !pw.written {
+		pw.WriteHeader(http.StatusOK)
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *progressWriter) Unwrap() http.ResponseWriter { return pw.ResponseWriter }
+
+// trackRaftProgress makes the response to r report the progress of cons and
+// the entry r writes, if any.
+func trackRaftProgress(w http.ResponseWriter, r *http.Request, cons Consensus) (http.ResponseWriter, *http.Request) {
+	rec := &writeRecord{}
+	pw := &progressWriter{ResponseWriter: w, set: func(h http.Header) {
+		// Already set by the leader that answered through proxyRequestToLeader.
+		if h.Get(AppliedIndexHeader) != "" {
+			return
+		}
+		g := progressGroup(cons, r)
+		if rec.index > 0 {
+			if m, ok := cons.(*MultiRaft); ok && m.Group(rec.group) != nil {
+				g = m.Group(rec.group)
+			}
+			h.Set(CommitIndexHeader, strconv.FormatInt(rec.index, 10))
+		}
+		if g == nil {
+			return
+		}
+		st := g.Status()
+		h.Set(AppliedIndexHeader, strconv.FormatInt(st.LastApplied, 10))
+		h.Set(TermHeader, strconv.FormatInt(st.Term, 10))
+		if g.group != "" {
+			h.Set(RaftGroupHeader, g.group)
+		}
+	}}
+	return pw, r.WithContext(withWriteRecord(r.Context(), rec))
+}
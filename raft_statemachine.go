@@ -0,0 +1,174 @@
+package agendadistribuida
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+)
+
+// --- replicated state machine ---
+//
+// The consensus engine only orders log entries; what they mean is up to the
+// StateMachine plugged in with SetStateMachine. Entries are applied in index
+// order, once each (raft_applied deduplicates by event id), while applyMu keeps
+// Snapshot and Restore from running concurrently with Apply. Raft's own entries
+// (configuration changes, no-ops and skips) never reach the state machine.
+
+var errNoStateMachine = errors.New("no state machine configured")
+
+// ApplyResult is what the state machine produced for one log entry.
+type ApplyResult struct {
+	Index int64  `json:"index"`
+	Op    string `json:"op"`
+	// ID of the entity created or affected by the entry, when there is one.
+	ID string `json:"id,omitempty"`
+	// Participants computed for a group appointment.
+	Participants []Participant `json:"participants,omitempty"`
+	// Group is the Raft group that committed the entry, set by
+	// MultiRaft.Propose ("" without shards).
+	Group string `json:"group,omitempty"`
+}
+
+type StateMachine interface {
+	// Apply executes a committed entry. An error leaves the entry unapplied
+	// unless it is one of the benign conflicts of isIgnorableApplyError.
+	Apply(e LogEntry) (ApplyResult, error)
+	// Snapshot serializes the whole state as of the last applied entry.
+	Snapshot() ([]byte, error)
+	// Restore replaces the whole state with a Snapshot.
+	Restore(data []byte) error
+	// LastAppliedIndex is the index of the latest entry reflected in the state.
+	LastAppliedIndex() int64
+}
+
+// txApplier is implemented by state machines living in the same SQLite
+// database as the Raft metadata, so that an entry, its client session and its
+// bookkeeping are written in one transaction: a crash leaves all or none of
+// them.
+type txApplier interface {
+	applyTx(tx *sql.Tx, e LogEntry) (ApplyResult, error)
+}
+
+// entryTx is the transaction a client entry is applied in, with the storage
+// its session, change and raft_applied row are kept in bound to it. Without
+// one (a state machine in another database, such as a shard's) every write
+// commits on its own.
+type entryTx struct {
+	tx    *sql.Tx
+	store *Storage
+	apply func(LogEntry) (ApplyResult, error)
+}
+
+// beginEntry starts the transaction of an entry applied through sm.
+//
+// Without a transaction, a crash may leave the first entry applied after a
+// restart in the state machine but not in raft_applied. Applying it again then
+// conflicts with itself, so for that entry a conflict counts as applied.
+func (c *ConsensusImpl) beginEntry(sm StateMachine) (*entryTx, error) {
+	a, ok := sm.(txApplier)
+	if !ok {
+		t := &entryTx{store: c.storage, apply: sm.Apply}
+		if !c.applyStarted {
+			t.apply = func(e LogEntry) (ApplyResult, error) {
+				res, err := sm.Apply(e)
+				if err != nil && isIgnorableApplyError(e, err) {
+					c.log(slog.LevelWarn, "apply_resumed_conflict", "index", e.Index, "op", e.Op, "err", err.Error())
+					return res, nil
+				}
+				return res, err
+			}
+		}
+		return t, nil
+	}
+	tx, err := c.storage.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &entryTx{
+		tx:    tx,
+		store: c.storage.withTx(tx),
+		apply: func(e LogEntry) (ApplyResult, error) { return a.applyTx(tx, e) },
+	}, nil
+}
+
+func (t *entryTx) commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Commit()
+}
+
+func (t *entryTx) rollback() {
+	if t.tx != nil {
+		t.tx.Rollback()
+	}
+}
+
+// txRestorer is implemented by state machines living in the same SQLite
+// database as the Raft metadata, so that installing a snapshot and recording
+// its index happen in one transaction.
+type txRestorer interface {
+	restoreTx(tx *sql.Tx, data []byte) error
+}
+
+// applyTx applies e within tx. A failed entry leaves no partial writes.
+func (m *SQLiteStateMachine) applyTx(tx *sql.Tx, e LogEntry) (ApplyResult, error) {
+	store := m.store.withTx(tx)
+	sp, err := store.begin()
+	if err != nil {
+		return ApplyResult{Index: e.Index, Op: e.Op}, err
+	}
+	res, err := (&SQLiteStateMachine{store: store, tables: m.tables}).Apply(e)
+	if err != nil {
+		sp.Rollback()
+		return res, err
+	}
+	return res, sp.Commit()
+}
+
+func (m *SQLiteStateMachine) Snapshot() ([]byte, error) {
+	snap, err := m.store.dumpTables(m.snapshotTables())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(snap)
+}
+
+func (m *SQLiteStateMachine) Restore(data []byte) error {
+	tx, err := m.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := m.restoreTx(tx, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *SQLiteStateMachine) restoreTx(tx *sql.Tx, data []byte) error {
+	var snap StateSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	return restoreStateMachineTx(tx, &snap, m.snapshotTables())
+}
+
+// snapshotTables are the tables m snapshots and restores.
+func (m *SQLiteStateMachine) snapshotTables() []string {
+	if m.tables != nil {
+		return m.tables
+	}
+	return stateMachineTables
+}
+
+// LastAppliedIndex reads the highest index recorded in raft_applied, which is
+// part of the snapshot and therefore survives a restore.
+func (m *SQLiteStateMachine) LastAppliedIndex() int64 {
+	var idx sql.NullInt64
+	if err := m.store.db.QueryRow(`SELECT MAX(idx) FROM raft_applied`).Scan(&idx); err != nil {
+		return 0
+	}
+	return idx.Int64
+}
@@ -0,0 +1,170 @@
+package agendadistribuida
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// --- leadership transfer (TimeoutNow) ---
+//
+// TransferLeadership hands leadership to a follower without waiting for an
+// election timeout: the leader stops accepting proposals, brings the target's
+// log up to date and then sends it TimeoutNow, which makes the target start an
+// election right away (skipping pre-vote). The target wins because its log is
+// as up to date as any other; the old leader steps down on its RequestVote.
+
+var ErrLeadershipTransfer = errors.New("leadership transfer in progress")
+
+type TimeoutNowRequest struct {
+	Term     int64  `json:"term"`
+	LeaderID string `json:"leader_id"`
+}
+
+type TimeoutNowResponse struct {
+	Term    int64 `json:"term"`
+	Success bool  `json:"success"`
+}
+
+// TransferLeadership moves leadership to target. An empty target picks the
+// follower with the highest matchIdx. It returns once another node is leader
+// or the transfer timed out (in which case this node stays leader).
+func (c *ConsensusImpl) TransferLeadership(target string) (string, error) {
+	c.mu.Lock()
+	if c.role != roleLeader {
+		c.mu.Unlock()
+		return "", errors.New("not leader")
+	}
+	if c.transferTarget != "" {
+		c.mu.Unlock()
+		return "", ErrLeadershipTransfer
+	}
+	if target == "" {
+		target = c.pickTransferTargetLocked()
+		if target == "" {
+			c.mu.Unlock()
+			return "", errors.New("no follower available for leadership transfer")
+		}
+	}
+	if target == c.nodeID {
+		c.mu.Unlock()
+		return "", errors.New("node is already leader")
+	}
+	if cfg := c.committedConfigLocked(); cfg != nil && !cfg.IsVoter(target) {
+		c.mu.Unlock()
+		return "", ErrNotVoter
+	}
+	c.transferTarget = target
+	term := c.state.CurrentTerm
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.transferTarget = ""
+		c.mu.Unlock()
+	}()
+
+	c.log(slog.LevelInfo, "leadership_transfer_started", "target", target, "term", term)
+	c.audit("leadership_transfer", "leadership transfer started", map[string]any{"target": target, "term": term})
+
+	if err := c.catchUpPeer(target, term); err != nil {
+		c.log(slog.LevelWarn, "leadership_transfer_catch_up_failed", "target", target, "err", err)
+		return "", err
+	}
+
+	payload, _ := json.Marshal(TimeoutNowRequest{Term: term, LeaderID: c.nodeID})
+	body, err := c.postJSONWithResponse("http://"+c.peerAddr(target)+"/raft/timeout-now", payload)
+	if err != nil {
+		return "", err
+	}
+	var resp TimeoutNowResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", err
+	}
+	if !resp.Success {
+		return "", errors.New("target rejected TimeoutNow")
+	}
+
+	// Wait for the target's RequestVote (or AppendEntries) to depose us.
+	deadline := time.Now().Add(c.electionTimeout())
+	for time.Now().Before(deadline) {
+		c.mu.RLock()
+		stillLeader := c.role == roleLeader && c.state.CurrentTerm == term
+		c.mu.RUnlock()
+		if !stillLeader {
+			c.log(slog.LevelInfo, "leadership_transferred", "target", target, "term", term)
+			c.audit("leadership_transfer", "leadership transferred", map[string]any{"target": target, "term": term})
+			return target, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.log(slog.LevelWarn, "leadership_transfer_timeout", "target", target)
+	return "", errors.New("timeout waiting for target to take over leadership")
+}
+
+// pickTransferTargetLocked returns the most up-to-date voter follower.
+// Callers must hold c.mu.
+func (c *ConsensusImpl) pickTransferTargetLocked() string {
+	cfg := c.committedConfigLocked()
+	best, bestMatch := "", int64(-1)
+	for id, m := range c.matchIdx {
+		if id == c.nodeID || (cfg != nil && !cfg.IsVoter(id)) {
+			continue
+		}
+		if m > bestMatch || (m == bestMatch && id < best) {
+			best, bestMatch = id, m
+		}
+	}
+	return best
+}
+
+// catchUpPeer waits, at most two election timeouts, until target's replicator
+// has brought its matchIdx to the leader's last index. Proposals are blocked
+// by transferTarget, so the last index is fixed.
+func (c *ConsensusImpl) catchUpPeer(target string, term int64) error {
+	lastIdx, _, err := c.lastIndexTerm()
+	if err != nil {
+		return err
+	}
+	c.ensureReplicators()
+	deadline := time.Now().Add(2 * c.cfg.ElectionTimeout)
+	for time.Now().Before(deadline) {
+		c.mu.RLock()
+		match := c.matchIdx[target]
+		stillLeader := c.role == roleLeader && c.state.CurrentTerm == term
+		c.mu.RUnlock()
+		if !stillLeader {
+			return errors.New("lost leadership during transfer")
+		}
+		if match >= lastIdx {
+			return nil
+		}
+		c.kickReplicators()
+		time.Sleep(50 * time.Millisecond)
+	}
+	return errors.New("target did not catch up")
+}
+
+// HandleTimeoutNow makes this follower start an election immediately when
+// asked by the leader of the current term.
+func (c *ConsensusImpl) HandleTimeoutNow(req TimeoutNowRequest) (TimeoutNowResponse, error) {
+	c.mu.RLock()
+	term := c.state.CurrentTerm
+	role := c.role
+	c.mu.RUnlock()
+	if req.Term != term || role == roleLeader {
+		c.log(slog.LevelWarn, "timeout_now_rejected", "leader", req.LeaderID, "term", req.Term, "our_term", term)
+		return TimeoutNowResponse{Term: term, Success: false}, nil
+	}
+	if cfg := c.committedConfig(); cfg != nil && !cfg.IsVoter(c.nodeID) {
+		return TimeoutNowResponse{Term: term, Success: false}, nil
+	}
+	c.log(slog.LevelInfo, "timeout_now_received", "leader", req.LeaderID, "term", req.Term)
+	c.audit("leadership_transfer", "starting election on leader request", map[string]any{"leader": req.LeaderID, "term": req.Term})
+	go func() {
+		if err := c.campaign(false); err != nil {
+			c.log(slog.LevelWarn, "timeout_now_election_failed", "err", err)
+		}
+	}()
+	return TimeoutNowResponse{Term: term, Success: true}, nil
+}
@@ -0,0 +1,114 @@
+package agendadistribuida
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// --- payload versions ---
+//
+// Every log entry records the schema version of its payload (LogEntry.Version,
+// stamped by propose). The state machine only decodes the current version of
+// each op; an older payload goes through a chain of upcasters, one per version
+// step, that rewrite its JSON into the current shape before it is applied. A
+// freshly restored node replaying old entries therefore keeps working after a
+// payload struct changes.
+//
+// To change a payload struct: bump its op in currentPayloadVersions, register
+// an upcaster from the previous version (registerUpcaster, from an init
+// function) and add a fixture of the old version to raft_upcast_test.go.
+// Entries written before payloads were versioned have version 0 and the same
+// shape as version 1.
+
+// currentPayloadVersions is the payload version each op is proposed and
+// applied with.
+var currentPayloadVersions = map[string]int{
+	OpApptCreatePersonal:            2,
+	OpApptCreateGroup:               2,
+	OpApptUpdate:                    1,
+	OpApptDelete:                    1,
+	OpUserCreate:                    1,
+	OpUserUpdateProfile:             1,
+	OpUserUpdatePassword:            1,
+	OpGroupCreate:                   1,
+	OpGroupUpdate:                   1,
+	OpGroupDelete:                   1,
+	OpGroupMemberAdd:                1,
+	OpGroupMemberUpdate:             1,
+	OpGroupMemberRemove:             1,
+	OpInvitationAccept:              1,
+	OpInvitationReject:              1,
+	OpRepairUserClearEmailIfMatches: 1,
+	OpRepairEnsureUser:              1,
+	OpRepairEnsureGroupMember:       1,
+	OpRepairEnsureParticipant:       1,
+	OpRepairEnsureNotification:      1,
+	OpRaftConfig:                    1,
+	OpRaftSkip:                      1,
+}
+
+func init() {
+	// Version 2 of the appointment creations adds owner_username, which a
+	// version 1 payload leaves to be looked up when applied.
+	unchanged := func(p json.RawMessage) (json.RawMessage, error) { return p, nil }
+	registerUpcaster(OpApptCreatePersonal, 1, unchanged)
+	registerUpcaster(OpApptCreateGroup, 1, unchanged)
+}
+
+// upcaster rewrites a payload of one version into the next one.
+type upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+type upcasterKey struct {
+	op   string
+	from int
+}
+
+var upcasters = map[upcasterKey]upcaster{}
+
+// registerUpcaster registers the conversion of op payloads from version from
+// to from+1.
+func registerUpcaster(op string, from int, up upcaster) {
+	key := upcasterKey{op: op, from: from}
+	if _, dup := upcasters[key]; dup {
+		panic(fmt.Sprintf("duplicate upcaster for %s v%d", op, from))
+	}
+	upcasters[key] = up
+}
+
+// currentPayloadVersion returns the version new payloads of op are written
+// with (1 for ops without a registered version, e.g. raft.noop).
+func currentPayloadVersion(op string) int {
+	if v, ok := currentPayloadVersions[op]; ok {
+		return v
+	}
+	return 1
+}
+
+// upcastEntry returns e with its payload converted to the current version of
+// its op. A payload newer than this binary understands is an error: the node
+// has to be upgraded before it can apply the entry.
+func upcastEntry(e LogEntry) (LogEntry, error) {
+	cur := currentPayloadVersion(e.Op)
+	v := e.Version
+	if v == 0 {
+		v = 1 // written before payloads were versioned
+	}
+	if v > cur {
+		return e, fmt.Errorf("%s payload version %d is newer than supported version %d", e.Op, v, cur)
+	}
+	payload := json.RawMessage(e.Payload)
+	for ; v < cur; v++ {
+		up, ok := upcasters[upcasterKey{op: e.Op, from: v}]
+		if !ok {
+			return e, fmt.Errorf("no upcaster for %s payload version %d", e.Op, v)
+		}
+		out, err := up(payload)
+		if err != nil {
+			return e, fmt.Errorf("upcast %s payload from version %d: %w", e.Op, v, err)
+		}
+		payload = out
+	}
+	e.Payload = string(payload)
+	e.Version = cur
+	return e, nil
+}
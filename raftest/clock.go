@@ -0,0 +1,79 @@
+package raftest
+
+import (
+	"sync"
+	"time"
+
+	ad "distributed-agenda"
+)
+
+// Clock is a manual ad.Clock: time only moves when Advance is called, and
+// tickers fire then. Each harness node has its own, so a test decides which
+// node's election timer runs out.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+// NewClock returns a clock stopped at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) NewTicker(d time.Duration) ad.Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &ticker{clock: c, ch: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires every ticker that came due.
+// Like time.Ticker, a ticker whose last tick was not received yet drops the
+// new ones.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		if t.stopped || t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.ch <- c.now:
+		default:
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+type ticker struct {
+	clock   *Clock
+	ch      chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *ticker) C() <-chan time.Time { return t.ch }
+
+func (t *ticker) Reset(d time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.period, t.next, t.stopped = d, t.clock.now.Add(d), false
+}
+
+func (t *ticker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
@@ -0,0 +1,427 @@
+// Package raftest runs several Raft nodes in one process for tests: each node
+// is a real ad.ConsensusImpl with the SQLite state machine over an in-memory
+// database, the nodes talk through an in-memory Network, and every node has a
+// manual Clock, so the test decides when elections and heartbeats happen.
+//
+//	c := raftest.NewCluster(t, 3)
+//	c.ElectLeader("n1")
+//	c.Propose(raftest.Noop("a"))
+//	c.Isolate("n1")
+//	c.ElectLeader("n2")
+//	c.Heal()
+//	c.AssertConverged()
+package raftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ad "distributed-agenda"
+)
+
+// WaitTimeout bounds every wait of the harness (in real time).
+var WaitTimeout = 10 * time.Second
+
+// Node is one member of a Cluster.
+type Node struct {
+	ID        string
+	Addr      string // host:port other nodes reach it at on the Network
+	Consensus *ad.ConsensusImpl
+	Storage   *ad.Storage
+	Peers     *ad.EnvPeerStore
+	Clock     *Clock
+	Handler   http.Handler // serves the requests sent to the node
+}
+
+// Cluster is a set of nodes connected by an in-memory network.
+type Cluster struct {
+	t     testing.TB
+	Net   *Network
+	Nodes []*Node
+	Cfg   ad.ConsensusConfig
+	seq   int64
+}
+
+// Option changes the consensus settings of every node.
+type Option func(*ad.ConsensusConfig)
+
+// Legacy runs the nodes without initial voters nor strict quorum: no
+// configuration is ever bootstrapped and majorities are counted over the
+// peers each node currently sees.
+func Legacy(cfg *ad.ConsensusConfig) {
+	cfg.StrictQuorum = false
+	cfg.InitialVoters = nil
+}
+
+// dbSeq keeps the in-memory databases of different clusters apart.
+var dbSeq atomic.Int64
+
+// start is the time every node clock starts at.
+var start = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NewCluster starts n nodes, n1..nN, all followers and all initial voters.
+// Node IDs are not addresses: nI is reached at nI.raftest:8080, which the
+// other nodes know from their peer store, as discovery would tell them.
+// Nodes run with strict quorum (plain Raft majorities) and without the
+// periodic digest checker unless an option says otherwise. The cluster is
+// stopped when the test ends.
+func NewCluster(t testing.TB, n int, opts ...Option) *Cluster {
+	t.Helper()
+	t.Setenv("CLUSTER_HMAC_SECRET", "raftest")
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "n" + strconv.Itoa(i+1)
+	}
+	cfg := ad.DefaultConsensusConfig()
+	cfg.StrictQuorum = true
+	cfg.DigestInterval = 0
+	cfg.InitialVoters = append([]string(nil), ids...)
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("raftest: %v", err)
+	}
+
+	c := &Cluster{t: t, Net: NewNetwork(), Cfg: cfg, seq: dbSeq.Add(1)}
+	for _, id := range ids {
+		c.Nodes = append(c.Nodes, c.newNode(id, ids))
+	}
+	for _, node := range c.Nodes {
+		if err := node.Consensus.Start(); err != nil {
+			t.Fatalf("raftest: start %s: %v", node.ID, err)
+		}
+	}
+	t.Cleanup(c.close)
+	return c
+}
+
+// Addr returns the address of node id.
+func Addr(id string) string { return id + ".raftest:8080" }
+
+// newNode builds (without starting it) node id, whose peer store knows the
+// addresses of peers.
+func (c *Cluster) newNode(id string, peers []string) *Node {
+	st, err := ad.NewStorage(fmt.Sprintf("file:raftest-%d-%s?mode=memory&cache=shared&_busy_timeout=5000", c.seq, id))
+	if err != nil {
+		c.t.Fatalf("raftest: storage %s: %v", id, err)
+	}
+	ps := ad.NewEnvPeerStore(id, nil)
+	for _, o := range peers {
+		if o != id {
+			ps.UpsertPeer(o, Addr(o))
+		}
+	}
+	cons := ad.NewConsensus(id, st, ps, c.Cfg)
+	cons.SetStateMachine(ad.NewSQLiteStateMachine(st))
+	clock := NewClock(start)
+	cons.SetClock(clock)
+	cons.SetTransport(c.Net.Transport(Addr(id)))
+	cons.SetAdvertiseAddr(Addr(id))
+
+	r := mux.NewRouter()
+	r.Use(ad.FaultMiddleware(cons))
+	ad.RegisterRaftHTTP(r, cons, st)
+	ad.RegisterClusterHTTP(r, st, ps, cons)
+	c.Net.Attach(Addr(id), r)
+	return &Node{ID: id, Addr: Addr(id), Consensus: cons, Storage: st, Peers: ps, Clock: clock, Handler: r}
+}
+
+// AddNode starts one more node, not an initial voter. It knows the other
+// nodes, but they only learn about it once it joins (see Post).
+func (c *Cluster) AddNode() *Node {
+	c.t.Helper()
+	id := "n" + strconv.Itoa(len(c.Nodes)+1)
+	var peers []string
+	for _, n := range c.Nodes {
+		peers = append(peers, n.ID)
+	}
+	node := c.newNode(id, peers)
+	if err := node.Consensus.Start(); err != nil {
+		c.t.Fatalf("raftest: start %s: %v", id, err)
+	}
+	c.Nodes = append(c.Nodes, node)
+	return node
+}
+
+// Post sends a signed cluster request from one node to another through the
+// network and decodes the JSON answer into out (unless nil).
+func (c *Cluster) Post(from, to, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+c.Node(to).Addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte("raftest"))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Cluster-Signature", hex.EncodeToString(mac.Sum(nil)))
+	resp, err := c.Net.Transport(c.Node(from).Addr).RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", to, path, resp.Status, bytes.TrimSpace(b))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Cluster) close() {
+	for _, n := range c.Nodes {
+		n.Consensus.Stop()
+	}
+	c.Net.Close()
+	for _, n := range c.Nodes {
+		n.Storage.Close()
+	}
+}
+
+// Node returns the node with the given id.
+func (c *Cluster) Node(id string) *Node {
+	for _, n := range c.Nodes {
+		if n.ID == id {
+			return n
+		}
+	}
+	c.t.Fatalf("raftest: no node %q", id)
+	return nil
+}
+
+// Leader returns the leader of the highest term, or nil.
+func (c *Cluster) Leader() *Node {
+	var leader *Node
+	var term int64
+	for _, n := range c.Nodes {
+		if st := n.Consensus.Status(); st.IsLeader && st.Term > term {
+			leader, term = n, st.Term
+		}
+	}
+	return leader
+}
+
+// Tick advances the clock of every node by d.
+func (c *Cluster) Tick(d time.Duration) {
+	for _, n := range c.Nodes {
+		n.Clock.Advance(d)
+	}
+}
+
+// electionSpan is the longest election timeout a node can draw.
+func (c *Cluster) electionSpan() time.Duration {
+	return c.Cfg.ElectionTimeout + c.Cfg.ElectionJitter + 3*c.Cfg.ElectionBackoff + time.Millisecond
+}
+
+// ElectLeader runs out the election timer of id until it wins an election,
+// then heartbeats so every reachable node learns about it.
+func (c *Cluster) ElectLeader(id string) *Node {
+	c.t.Helper()
+	n := c.Node(id)
+	deadline := time.Now().Add(WaitTimeout)
+	for !n.Consensus.IsLeader() {
+		if time.Now().After(deadline) {
+			c.t.Fatalf("raftest: %s did not become leader: %+v", id, n.Consensus.Status())
+		}
+		n.Clock.Advance(c.electionSpan())
+		c.poll(time.Second, n.Consensus.IsLeader)
+	}
+	c.Heartbeat()
+	return n
+}
+
+// Heartbeat runs out the heartbeat timer of the leader.
+func (c *Cluster) Heartbeat() {
+	if l := c.Leader(); l != nil {
+		l.Clock.Advance(c.Cfg.HeartbeatInterval)
+	}
+}
+
+// Partition splits the network: nodes talk only to the nodes of their own
+// group. Nodes in no group are cut off from everyone.
+func (c *Cluster) Partition(groups ...[]string) {
+	group := map[string]int{}
+	for i, g := range groups {
+		for _, id := range g {
+			group[id] = i + 1
+		}
+	}
+	c.Net.Heal()
+	for _, a := range c.Nodes {
+		for _, b := range c.Nodes {
+			if a != b && (group[a.ID] == 0 || group[a.ID] != group[b.ID]) {
+				c.Net.Cut(a.Addr, b.Addr)
+			}
+		}
+	}
+}
+
+// Isolate cuts id off from every other node.
+func (c *Cluster) Isolate(id string) {
+	var rest []string
+	for _, n := range c.Nodes {
+		if n.ID != id {
+			rest = append(rest, n.ID)
+		}
+	}
+	c.Partition([]string{id}, rest)
+}
+
+// Heal reconnects every node.
+func (c *Cluster) Heal() {
+	c.Net.Heal()
+}
+
+// Propose proposes e through the current leader.
+func (c *Cluster) Propose(e ad.LogEntry) (ad.ApplyResult, error) {
+	c.t.Helper()
+	l := c.Leader()
+	if l == nil {
+		c.t.Fatal("raftest: no leader to propose to")
+	}
+	return l.Consensus.Propose(e)
+}
+
+// MustPropose is Propose failing the test on error.
+func (c *Cluster) MustPropose(e ad.LogEntry) ad.ApplyResult {
+	c.t.Helper()
+	res, err := c.Propose(e)
+	if err != nil {
+		c.t.Fatalf("raftest: propose %s: %v", e.Op, err)
+	}
+	return res
+}
+
+// Noop returns a no-op entry with the given event id.
+func Noop(eventID string) ad.LogEntry {
+	return ad.LogEntry{EventID: eventID, Aggregate: "raft", AggregateID: "noop", Op: ad.OpRaftNoop, Timestamp: time.Now()}
+}
+
+// WaitFor waits until cond holds, failing the test after WaitTimeout.
+func (c *Cluster) WaitFor(what string, cond func() bool) {
+	c.t.Helper()
+	if !c.poll(WaitTimeout, cond) {
+		c.t.Fatalf("raftest: timed out waiting for %s", what)
+	}
+}
+
+func (c *Cluster) poll(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// WaitApplied waits until the given nodes (all when none) applied index.
+func (c *Cluster) WaitApplied(index int64, ids ...string) {
+	c.t.Helper()
+	nodes := c.Nodes
+	if len(ids) > 0 {
+		nodes = nil
+		for _, id := range ids {
+			nodes = append(nodes, c.Node(id))
+		}
+	}
+	for _, n := range nodes {
+		c.WaitFor(fmt.Sprintf("%s to apply index %d", n.ID, index), func() bool {
+			return n.Consensus.Status().LastApplied >= index
+		})
+	}
+}
+
+// WaitConfig waits until the committed configuration of every node satisfies
+// cond, heartbeating meanwhile so followers learn about new entries.
+func (c *Cluster) WaitConfig(what string, cond func(cfg *ad.ClusterConfig) bool) {
+	c.t.Helper()
+	c.WaitFor(what, func() bool {
+		c.Heartbeat()
+		for _, n := range c.Nodes {
+			if cfg := n.Consensus.Configuration(); cfg == nil || !cond(cfg) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// AssertConverged waits until every node applied the leader's commit index
+// and checks that all state machines have the same digest there.
+func (c *Cluster) AssertConverged() {
+	c.t.Helper()
+	deadline := time.Now().Add(WaitTimeout)
+	for {
+		err := c.compareDigests()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("raftest: not converged: %v", err)
+		}
+		// Lagging nodes and entries committed meanwhile are retried.
+		c.Heartbeat()
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (c *Cluster) compareDigests() error {
+	leader := c.Leader()
+	if leader == nil {
+		c.Tick(c.Cfg.HeartbeatInterval)
+		return errors.New("no leader")
+	}
+	// A heartbeat carries the commit index to the followers.
+	c.Heartbeat()
+	index := leader.Consensus.Status().CommitIndex
+	for _, n := range c.Nodes {
+		if n.Consensus.Status().LastApplied < index {
+			return fmt.Errorf("%s has not applied index %d", n.ID, index)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	want, err := leader.Consensus.StateDigestAt(ctx, ad.DigestRequest{Index: index})
+	if err != nil {
+		return fmt.Errorf("digest of %s: %w", leader.ID, err)
+	}
+	for _, n := range c.Nodes {
+		if n == leader {
+			continue
+		}
+		got, err := n.Consensus.StateDigestAt(ctx, ad.DigestRequest{Index: index})
+		if err != nil {
+			return fmt.Errorf("digest of %s: %w", n.ID, err)
+		}
+		for i, td := range want.Tables {
+			if got.Tables[i].Hash != td.Hash {
+				c.t.Fatalf("raftest: %s differs from leader %s at index %d in table %s (%d rows vs %d)",
+					n.ID, leader.ID, index, td.Table, got.Tables[i].Rows, td.Rows)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package raftest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ad "distributed-agenda"
+)
+
+func userEntry(t *testing.T, username string) ad.LogEntry {
+	t.Helper()
+	e, err := ad.BuildEntryUserCreate(&ad.User{Username: username, Email: username + "@example.com", PasswordHash: "h", DisplayName: username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestNoElectionWithoutTicks(t *testing.T) {
+	c := NewCluster(t, 3)
+	time.Sleep(200 * time.Millisecond)
+	if l := c.Leader(); l != nil {
+		t.Fatalf("%s became leader while the clocks stood still", l.ID)
+	}
+}
+
+func TestReplicateAndConverge(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	for _, name := range []string{"alice", "bob", "carol"} {
+		c.MustPropose(userEntry(t, name))
+	}
+	c.AssertConverged()
+	for _, n := range c.Nodes {
+		if _, err := n.Storage.GetUserByUsername("bob"); err != nil {
+			t.Errorf("%s: bob: %v", n.ID, err)
+		}
+	}
+}
+
+func TestIsolatedLeaderIsReplaced(t *testing.T) {
+	c := NewCluster(t, 3, func(cfg *ad.ConsensusConfig) { cfg.ApplyWaitTimeout = 300 * time.Millisecond })
+	old := c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+	c.AssertConverged()
+
+	c.Isolate("n1")
+	if _, err := old.Consensus.Propose(userEntry(t, "lost")); err == nil {
+		t.Fatal("isolated leader committed an entry")
+	}
+	c.ElectLeader("n2")
+	c.MustPropose(userEntry(t, "bob"))
+
+	c.Heal()
+	c.Heartbeat()
+	c.WaitFor("n1 to follow n2", func() bool {
+		c.Heartbeat()
+		return !old.Consensus.IsLeader() && old.Consensus.LeaderID() == "n2"
+	})
+	c.AssertConverged()
+	for _, n := range c.Nodes {
+		if _, err := n.Storage.GetUserByUsername("bob"); err != nil {
+			t.Errorf("%s: bob: %v", n.ID, err)
+		}
+		if _, err := n.Storage.GetUserByUsername("lost"); err == nil {
+			t.Errorf("%s applied an entry that was never committed", n.ID)
+		}
+	}
+}
+
+func TestMinorityCannotElect(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	c.Partition([]string{"n1", "n2"}, []string{"n3"})
+	n3 := c.Node("n3")
+	for i := 0; i < 3; i++ {
+		n3.Clock.Advance(c.electionSpan())
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n3.Consensus.IsLeader() {
+		t.Fatal("n3 won an election without a majority")
+	}
+	c.MustPropose(userEntry(t, "alice"))
+
+	c.Heal()
+	c.AssertConverged()
+}
+
+func TestNetworkCutIsDirectional(t *testing.T) {
+	c := NewCluster(t, 2)
+	n1, n2 := c.Node("n1"), c.Node("n2")
+	c.Net.Cut(n1.Addr, n2.Addr)
+	if _, err := c.Net.Transport(n1.Addr).RoundTrip(mustRequest(t, "http://"+n2.Addr+"/raft/health")); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("n1 -> n2: got %v, want ErrUnreachable", err)
+	}
+	resp, err := c.Net.Transport(n2.Addr).RoundTrip(mustRequest(t, "http://"+n1.Addr+"/raft/health"))
+	if !errors.Is(err, ErrUnreachable) {
+		t.Errorf("n2 -> n1 with the answer cut: got %v, want ErrUnreachable", err)
+	} else if resp != nil {
+		t.Error("got a response through a cut link")
+	}
+	c.Heal()
+	resp, err = c.Net.Transport(n2.Addr).RoundTrip(mustRequest(t, "http://"+n1.Addr+"/raft/health"))
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("after heal: %v %v", resp, err)
+	}
+}
+
+func mustRequest(t *testing.T, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestChangesArePublished(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+	c.MustPropose(userEntry(t, "bob"))
+	dup, err := ad.BuildEntryUserCreate(&ad.User{Username: "alice2", Email: "alice@example.com", PasswordHash: "h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Propose(dup); err == nil {
+		t.Fatal("user with a taken email was created")
+	}
+	c.AssertConverged()
+
+	var last int64
+	for _, n := range c.Nodes {
+		page, err := n.Consensus.Changes(context.Background(), ad.ChangeQuery{})
+		if err != nil {
+			t.Fatalf("%s: %v", n.ID, err)
+		}
+		var got []string
+		for _, ev := range page.Changes {
+			got = append(got, ev.Status)
+			if ev.Op != ad.OpUserCreate || strings.Contains(string(ev.Payload), `"h"`) {
+				t.Errorf("%s: change %d: op %s, payload %s", n.ID, ev.Index, ev.Op, ev.Payload)
+			}
+		}
+		if want := []string{ad.ChangeApplied, ad.ChangeApplied, ad.ChangeRejected}; !slices.Equal(got, want) {
+			t.Fatalf("%s: statuses %v, want %v", n.ID, got, want)
+		}
+		last = page.Next
+	}
+
+	// A consumer waiting at its checkpoint gets the next change.
+	done := make(chan ad.ChangePage, 1)
+	go func() {
+		page, err := c.Node("n3").Consensus.Changes(context.Background(), ad.ChangeQuery{After: last, WaitMS: 5000})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- page
+	}()
+	time.Sleep(50 * time.Millisecond)
+	res := c.MustPropose(userEntry(t, "carol"))
+	c.Heartbeat()
+	page := <-done
+	if len(page.Changes) != 1 || page.Changes[0].EntityID != res.ID {
+		t.Fatalf("long poll got %+v, want the change creating %s", page.Changes, res.ID)
+	}
+}
+
+func TestClientSessionsDeduplicateAndExpire(t *testing.T) {
+	c := NewCluster(t, 3, func(cfg *ad.ConsensusConfig) { cfg.SessionRetention = 2 })
+	c.ElectLeader("n1")
+	request := func(username, client string, seq int64) ad.LogEntry {
+		e := userEntry(t, username)
+		e.ClientID, e.Seq = client, seq
+		return e
+	}
+	first := c.MustPropose(request("alice", "c1", 1))
+	// A retry, with a new event id, gets the outcome of the first attempt.
+	if retry := c.MustPropose(request("alice", "c1", 1)); retry.ID != first.ID || retry.Index != first.Index {
+		t.Fatalf("retry got %+v, want %+v", retry, first)
+	}
+	c.MustPropose(request("bob", "c2", 1))
+	c.MustPropose(request("carol", "c2", 2))
+	c.AssertConverged()
+
+	// c1 was last seen more than two entries ago.
+	for _, n := range c.Nodes {
+		if sess, err := n.Storage.GetClientSession("c1"); err != nil || sess != nil {
+			t.Errorf("%s: session c1 = %+v, %v; want expired", n.ID, sess, err)
+		}
+		if sess, err := n.Storage.GetClientSession("c2"); err != nil || sess == nil || sess.Seq != 2 {
+			t.Errorf("%s: session c2 = %+v, %v; want seq 2", n.ID, sess, err)
+		}
+	}
+}
+
+func TestDigestCheckSkipsReplicaPastIndex(t *testing.T) {
+	c := NewCluster(t, 3, func(cfg *ad.ConsensusConfig) { cfg.DigestInterval = time.Hour })
+	c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+	c.AssertConverged()
+
+	// n3 answers every digest request as a node that already applied past the
+	// index asked for.
+	var asked atomic.Int32
+	n3 := c.Node("n3")
+	c.Net.Attach(n3.Addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/raft/digest" {
+			asked.Add(1)
+			http.Error(w, ad.ErrDigestIndexPassed.Error(), http.StatusConflict)
+			return
+		}
+		n3.Handler.ServeHTTP(w, r)
+	}))
+
+	// The periodic check runs on the node's clock.
+	c.Node("n1").Clock.Advance(time.Hour)
+	c.WaitFor("the periodic digest check", func() bool { return asked.Load() > 0 })
+	// An hour without contact made n1 step down and win again; the no-op of
+	// its new term must reach n2 before n2 can answer at the leader's index.
+	c.AssertConverged()
+
+	asked.Store(0)
+	report, err := c.Node("n1").Consensus.CheckReplicas(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, r := range report.Replicas {
+		got[r.NodeID] = r.Status
+	}
+	if got["n2"] != ad.DigestStatusMatch || got["n3"] != ad.DigestStatusSkipped || report.Diverged {
+		t.Fatalf("report %+v, want n2 matching and n3 skipped", report)
+	}
+	if n := asked.Load(); n < 2 {
+		t.Errorf("n3 asked %d times, want it asked again at a newer index", n)
+	}
+}
+
+func TestBackupSeedsNewNode(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	for _, name := range []string{"alice", "bob"} {
+		c.MustPropose(userEntry(t, name))
+	}
+	c.AssertConverged()
+
+	n2 := c.Node("n2")
+	info, err := n2.Consensus.Backup(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := n2.Consensus.Status(); info.Index != st.LastApplied || info.Term != st.Term || info.NodeID != "n2" {
+		t.Fatalf("backup %+v, node %+v", info, st)
+	}
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "new.db") + "?_fk=1"
+	if _, err := ad.SeedFromBackup(context.Background(), info.Path, dsn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ad.SeedFromBackup(context.Background(), info.Path, dsn); !errors.Is(err, ad.ErrDatabaseExists) {
+		t.Fatalf("seeding twice: got %v, want ErrDatabaseExists", err)
+	}
+	st, err := ad.NewStorage(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	want, err := n2.Storage.DigestStateMachine(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.DigestStateMachine(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, td := range want {
+		if got[i].Hash != td.Hash {
+			t.Errorf("seeded %s differs: %d rows, want %d", td.Table, got[i].Rows, td.Rows)
+		}
+	}
+	seeded := ad.NewConsensus("n4", st, ad.NewEnvPeerStore("n4", nil), c.Cfg)
+	if err := seeded.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer seeded.Stop()
+	if s := seeded.Status(); s.CommitIndex != info.Index || s.LastApplied != info.Index {
+		t.Fatalf("seeded node starts at %+v, want index %d", s, info.Index)
+	}
+}
+
+func TestReadIndex(t *testing.T) {
+	c := NewCluster(t, 3, func(cfg *ad.ConsensusConfig) { cfg.ApplyWaitTimeout = 300 * time.Millisecond })
+	leader := c.ElectLeader("n1")
+	res := c.MustPropose(userEntry(t, "alice"))
+
+	idx, err := leader.Consensus.ReadIndex(context.Background())
+	if err != nil || idx < res.Index {
+		t.Fatalf("leader read index %d, %v; want at least %d", idx, err, res.Index)
+	}
+	// A follower gets the index from the leader and waits until it applied it.
+	n2 := c.Node("n2")
+	idx, err = n2.Consensus.ReadIndex(context.Background())
+	if err != nil || idx < res.Index {
+		t.Fatalf("follower read index %d, %v; want at least %d", idx, err, res.Index)
+	}
+	if _, err := n2.Storage.GetUserByUsername("alice"); err != nil {
+		t.Fatalf("n2 answered a read index before applying alice: %v", err)
+	}
+
+	// A leader cut off from the majority cannot confirm it still leads.
+	c.Isolate("n1")
+	if idx, err := leader.Consensus.ReadIndex(context.Background()); err == nil {
+		t.Fatalf("isolated leader served read index %d", idx)
+	}
+}
+
+func TestLeadershipTransfer(t *testing.T) {
+	c := NewCluster(t, 3)
+	old := c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+
+	if _, err := c.Node("n3").Consensus.TransferLeadership("n2"); err == nil {
+		t.Fatal("a follower started a leadership transfer")
+	}
+	got, err := old.Consensus.TransferLeadership("n2")
+	if err != nil || got != "n2" {
+		t.Fatalf("transfer: %q, %v", got, err)
+	}
+	if old.Consensus.IsLeader() {
+		t.Fatal("n1 still leads after the transfer")
+	}
+	c.WaitFor("n2 to lead", c.Node("n2").Consensus.IsLeader)
+	c.MustPropose(userEntry(t, "bob"))
+	c.AssertConverged()
+}
+
+func TestPoisonedEntryIsSkipped(t *testing.T) {
+	c := NewCluster(t, 3, func(cfg *ad.ConsensusConfig) { cfg.ApplyWaitTimeout = time.Second })
+	leader := c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+
+	// No state machine knows this op: every node stops at it.
+	bad := ad.LogEntry{EventID: "bad", Aggregate: "test", AggregateID: "bad", Op: "test.unknown", Payload: "{}", Timestamp: time.Now()}
+	if _, err := c.Propose(bad); err == nil {
+		t.Fatal("an entry no state machine can apply was applied")
+	}
+	pe, err := leader.Consensus.PoisonedEntry()
+	if err != nil || pe == nil {
+		t.Fatalf("poisoned entry %+v, %v", pe, err)
+	}
+	if _, err := c.Propose(userEntry(t, "bob")); err == nil {
+		t.Fatal("a blocked leader accepted a proposal")
+	}
+
+	if _, err := leader.Consensus.SkipEntry(pe.Index, "unknown op"); err != nil {
+		t.Fatal(err)
+	}
+	c.MustPropose(userEntry(t, "bob"))
+	c.AssertConverged()
+	for _, n := range c.Nodes {
+		if pe, err := n.Consensus.PoisonedEntry(); err != nil || pe != nil {
+			t.Errorf("%s still blocked: %+v, %v", n.ID, pe, err)
+		}
+		if _, err := n.Storage.GetUserByUsername("bob"); err != nil {
+			t.Errorf("%s: bob: %v", n.ID, err)
+		}
+	}
+}
+
+func TestLegacyMajorityDropsStalePeers(t *testing.T) {
+	c := NewCluster(t, 3, Legacy, func(cfg *ad.ConsensusConfig) { cfg.ApplyWaitTimeout = 300 * time.Millisecond })
+	leader := c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+
+	// n2 and n3 were heard from moments ago: they still count, and without
+	// them there is no majority.
+	c.Isolate("n1")
+	if _, err := leader.Consensus.Propose(userEntry(t, "bob")); err == nil {
+		t.Fatal("n1 committed without the peers it just heard from")
+	}
+	// Once they have not been heard from for PeerStaleness on n1's clock,
+	// the legacy majority is counted without them.
+	leader.Clock.Advance(c.Cfg.PeerStaleness + time.Second)
+	if _, err := leader.Consensus.Propose(userEntry(t, "carol")); err != nil {
+		t.Fatalf("n1 after its peers went stale: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package raftest
+
+import (
+	"testing"
+
+	ad "distributed-agenda"
+)
+
+func TestBootstrapThenLeaderChangeReplicates(t *testing.T) {
+	c := NewCluster(t, 3)
+	// Discovery also knows n2 under an alias; it must not become a voter.
+	c.Node("n1").Peers.UpsertPeer("dns:"+Addr("n2"), Addr("n2"))
+	c.ElectLeader("n1")
+	c.WaitConfig("the initial configuration", func(cfg *ad.ClusterConfig) bool { return len(cfg.Voters) > 0 })
+	for _, n := range c.Nodes {
+		cfg := n.Consensus.Configuration()
+		if len(cfg.Voters) != 3 || len(cfg.Learners) != 0 {
+			t.Fatalf("%s: configuration %+v, want the three initial voters", n.ID, cfg)
+		}
+		for _, m := range cfg.Voters {
+			if m.Address != Addr(m.NodeID) {
+				t.Errorf("%s: voter %s recorded at %q, want %q", n.ID, m.NodeID, m.Address, Addr(m.NodeID))
+			}
+		}
+	}
+
+	c.Isolate("n1")
+	c.ElectLeader("n2")
+	c.MustPropose(userEntry(t, "alice"))
+	c.Heal()
+	c.WaitFor("n1 to follow n2", func() bool {
+		c.Heartbeat()
+		return c.Node("n1").Consensus.LeaderID() == "n2"
+	})
+	c.AssertConverged()
+	if _, err := c.Node("n1").Storage.GetUserByUsername("alice"); err != nil {
+		t.Fatalf("n1: alice: %v", err)
+	}
+}
+
+type joinAnswer struct {
+	Membership string `json:"membership"`
+}
+
+func TestGossipJoinAddsLearnerUntilPromoted(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	c.MustPropose(userEntry(t, "alice"))
+	c.WaitConfig("the initial configuration", func(cfg *ad.ClusterConfig) bool { return len(cfg.Voters) == 3 })
+
+	n4 := c.AddNode()
+	join := map[string]any{"node_id": n4.ID, "address": n4.Addr, "source": "gossip"}
+	// Gossip can reach any node; a follower relays it to the leader.
+	var got joinAnswer
+	if err := c.Post("n4", "n2", "/cluster/join", join, &got); err != nil || got.Membership != "forwarded" {
+		t.Fatalf("join through n2: %+v, %v", got, err)
+	}
+	c.WaitConfig("n4 to join as a learner", func(cfg *ad.ClusterConfig) bool { return cfg.IsLearner("n4") })
+
+	// Discovery keeps announcing n4; that does not make it a voter.
+	if err := c.Post("n4", "n1", "/cluster/join", join, &got); err != nil || got.Membership != "committed" {
+		t.Fatalf("repeated join: %+v, %v", got, err)
+	}
+	if cfg := c.Node("n1").Consensus.Configuration(); !cfg.IsLearner("n4") {
+		t.Fatalf("repeated join changed n4: %+v", cfg)
+	}
+
+	c.WaitFor("n4 to catch up", func() bool {
+		c.Heartbeat()
+		_, err := n4.Storage.GetUserByUsername("alice")
+		return err == nil
+	})
+	if err := c.Post("n1", "n1", "/cluster/promote", map[string]any{"node_id": "n4"}, &got); err != nil || got.Membership != "committed" {
+		t.Fatalf("promote: %+v, %v", got, err)
+	}
+	c.WaitConfig("n4 to vote", func(cfg *ad.ClusterConfig) bool { return cfg.IsVoter("n4") && !cfg.IsJoint() })
+	c.MustPropose(userEntry(t, "bob"))
+	c.AssertConverged()
+}
+
+func TestRemovedNodeStaysOutUntilReadmitted(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	c.WaitConfig("the initial configuration", func(cfg *ad.ClusterConfig) bool { return len(cfg.Voters) == 3 })
+
+	var got joinAnswer
+	if err := c.Post("n1", "n1", "/cluster/leave", map[string]any{"node_id": "n3"}, &got); err != nil || got.Membership != "committed" {
+		t.Fatalf("leave: %+v, %v", got, err)
+	}
+	cfg := c.Node("n1").Consensus.Configuration()
+	if cfg.IsVoter("n3") || !cfg.IsRemoved("n3") || len(cfg.Voters) != 2 {
+		t.Fatalf("after leave: %+v", cfg)
+	}
+
+	// n3's discovery keeps announcing it.
+	join := map[string]any{"node_id": "n3", "address": Addr("n3"), "source": "gossip"}
+	if err := c.Post("n3", "n1", "/cluster/join", join, &got); err != nil || got.Membership != "error: "+ad.ErrNodeRemoved.Error() {
+		t.Fatalf("gossip join of a removed node: %+v, %v", got, err)
+	}
+	if cfg := c.Node("n1").Consensus.Configuration(); cfg.IsLearner("n3") || cfg.IsVoter("n3") {
+		t.Fatalf("gossip readded n3: %+v", cfg)
+	}
+
+	join["readmit"] = true
+	if err := c.Post("n3", "n1", "/cluster/join", join, &got); err != nil || got.Membership != "committed" {
+		t.Fatalf("readmit: %+v, %v", got, err)
+	}
+	c.WaitConfig("n3 to be back as a learner", func(cfg *ad.ClusterConfig) bool {
+		return cfg.IsLearner("n3") && !cfg.IsRemoved("n3")
+	})
+	c.MustPropose(userEntry(t, "alice"))
+	c.AssertConverged()
+}
+
+func TestLaggingLearnerIsNotPromoted(t *testing.T) {
+	c := NewCluster(t, 3, func(cfg *ad.ConsensusConfig) { cfg.LearnerCatchUpLag = 1 })
+	c.ElectLeader("n1")
+	c.WaitConfig("the initial configuration", func(cfg *ad.ClusterConfig) bool { return len(cfg.Voters) == 3 })
+	n4 := c.AddNode()
+	var got joinAnswer
+	if err := c.Post("n4", "n1", "/cluster/join", map[string]any{"node_id": n4.ID, "address": n4.Addr, "learner": true}, &got); err != nil || got.Membership != "committed" {
+		t.Fatalf("join: %+v, %v", got, err)
+	}
+
+	c.Partition([]string{"n1", "n2", "n3"})
+	for _, name := range []string{"alice", "bob", "carol"} {
+		c.MustPropose(userEntry(t, name))
+	}
+	promote := map[string]any{"node_id": "n4"}
+	if err := c.Post("n1", "n1", "/cluster/promote", promote, &got); err != nil || got.Membership != "error: "+ad.ErrLearnerNotCaughtUp.Error() {
+		t.Fatalf("promoting a lagging learner: %+v, %v", got, err)
+	}
+
+	c.Heal()
+	c.WaitFor("n4 to catch up", func() bool {
+		c.Heartbeat()
+		_, err := n4.Storage.GetUserByUsername("carol")
+		return err == nil
+	})
+	if err := c.Post("n1", "n1", "/cluster/promote", promote, &got); err != nil || got.Membership != "committed" {
+		t.Fatalf("promote: %+v, %v", got, err)
+	}
+	c.WaitConfig("n4 to vote", func(cfg *ad.ClusterConfig) bool { return cfg.IsVoter("n4") && !cfg.IsJoint() })
+}
@@ -0,0 +1,157 @@
+package raftest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// ErrUnreachable is returned for requests between nodes that are cut off.
+var ErrUnreachable = errors.New("raftest: node unreachable")
+
+// Network is an in-memory transport between the nodes of a harness. Each node
+// has an inbox channel; a request is delivered to the inbox of the node named
+// by its URL host and served by that node's router, and the response comes
+// back on a reply channel. Links can be cut in one or both directions.
+type Network struct {
+	mu    sync.RWMutex
+	nodes map[string]*endpoint
+	cut   map[link]bool
+}
+
+type link struct{ from, to string }
+
+type endpoint struct {
+	handler http.Handler
+	inbox   chan delivery
+	done    chan struct{}
+}
+
+type delivery struct {
+	req   *http.Request
+	reply chan *http.Response
+}
+
+func NewNetwork() *Network {
+	return &Network{nodes: map[string]*endpoint{}, cut: map[link]bool{}}
+}
+
+// Attach serves the requests sent to addr with h until Close, replacing the
+// handler attached there before, if any.
+func (n *Network) Attach(addr string, h http.Handler) {
+	ep := &endpoint{handler: h, inbox: make(chan delivery, 64), done: make(chan struct{})}
+	n.mu.Lock()
+	if old := n.nodes[addr]; old != nil {
+		close(old.done)
+	}
+	n.nodes[addr] = ep
+	n.mu.Unlock()
+	go ep.serve()
+}
+
+func (ep *endpoint) serve() {
+	for {
+		select {
+		case <-ep.done:
+			return
+		case d := <-ep.inbox:
+			go func() {
+				rec := httptest.NewRecorder()
+				ep.handler.ServeHTTP(rec, d.req)
+				d.reply <- rec.Result()
+			}()
+		}
+	}
+}
+
+// Close stops delivering requests to every node.
+func (n *Network) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for addr, ep := range n.nodes {
+		close(ep.done)
+		delete(n.nodes, addr)
+	}
+}
+
+// Cut drops the requests from one node to another (and their responses).
+func (n *Network) Cut(from, to string) {
+	n.mu.Lock()
+	n.cut[link{from, to}] = true
+	n.mu.Unlock()
+}
+
+// Heal restores every link.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	n.cut = map[link]bool{}
+	n.mu.Unlock()
+}
+
+func (n *Network) reachable(from, to string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return !n.cut[link{from, to}]
+}
+
+// Transport returns the transport of the node at addr.
+func (n *Network) Transport(addr string) http.RoundTripper {
+	return roundTripper{net: n, from: addr}
+}
+
+type roundTripper struct {
+	net  *Network
+	from string
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	to := req.URL.Host
+	if !rt.net.reachable(rt.from, to) {
+		return nil, fmt.Errorf("%s -> %s: %w", rt.from, to, ErrUnreachable)
+	}
+	rt.net.mu.RLock()
+	ep, ok := rt.net.nodes[to]
+	rt.net.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", to, ErrUnreachable)
+	}
+
+	// The server side gets its own copy of the body and of the request.
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	in := req.Clone(req.Context())
+	in.Body = io.NopCloser(bytes.NewReader(body))
+	in.ContentLength = int64(len(body))
+	in.RequestURI = req.URL.RequestURI()
+	in.RemoteAddr = rt.from
+
+	d := delivery{req: in, reply: make(chan *http.Response, 1)}
+	select {
+	case ep.inbox <- d:
+	case <-ep.done:
+		return nil, fmt.Errorf("%s: %w", to, ErrUnreachable)
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	select {
+	case resp := <-d.reply:
+		if !rt.net.reachable(to, rt.from) {
+			// the request went through, the answer is lost
+			return nil, fmt.Errorf("%s -> %s: %w", to, rt.from, ErrUnreachable)
+		}
+		resp.Request = req
+		return resp, nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
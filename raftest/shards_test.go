@@ -0,0 +1,202 @@
+package raftest
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ad "distributed-agenda"
+)
+
+// shardedNode is a node running a meta group and two shards, with a manual
+// clock per group.
+type shardedNode struct {
+	id      string
+	raft    *ad.MultiRaft
+	storage *ad.Storage
+	clocks  map[string]*Clock
+}
+
+func newShardedNodes(t *testing.T, cfg ad.ConsensusConfig, ids ...string) []*shardedNode {
+	t.Helper()
+	net := NewNetwork()
+	seq := dbSeq.Add(1)
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	var nodes []*shardedNode
+	for _, id := range ids {
+		dsn := fmt.Sprintf("file:raftest-shards-%d-%s?mode=memory&cache=shared&_busy_timeout=5000", seq, id)
+		st, err := ad.NewStorage(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps := ad.NewEnvPeerStore(id, nil)
+		for _, o := range ids {
+			if o != id {
+				ps.UpsertPeer(o, Addr(o))
+			}
+		}
+		mr, err := ad.NewMultiRaft(id, st, dsn, ps, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mr.SetAdvertiseAddr(Addr(id))
+		n := &shardedNode{id: id, raft: mr, storage: st, clocks: map[string]*Clock{}}
+		for _, name := range []string{ad.MetaGroup, ad.ShardName(0), ad.ShardName(1)} {
+			g := mr.Group(name)
+			n.clocks[name] = NewClock(start)
+			g.SetClock(n.clocks[name])
+			g.SetTransport(net.Transport(Addr(id)))
+		}
+		r := mux.NewRouter()
+		r.Use(ad.FaultMiddleware(mr.Meta()))
+		ad.RegisterRaftHTTP(r, mr.Meta(), st)
+		ad.RegisterShardHTTP(r, mr)
+		ad.RegisterClusterHTTP(r, st, ps, mr)
+		net.Attach(Addr(id), r)
+		nodes = append(nodes, n)
+	}
+	for _, n := range nodes {
+		if err := n.raft.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.raft.Stop()
+		}
+		net.Close()
+		for _, n := range nodes {
+			n.raft.Close()
+			n.storage.Close()
+		}
+	})
+	return nodes
+}
+
+func TestShardsRouteByKey(t *testing.T) {
+	t.Setenv("CLUSTER_HMAC_SECRET", "raftest")
+	cfg := ad.DefaultConsensusConfig()
+	cfg.StrictQuorum = true
+	cfg.InitialVoters = []string{"n1", "n2", "n3"}
+	cfg.Shards = 2
+	c := &Cluster{t: t, Cfg: cfg}
+	nodes := newShardedNodes(t, cfg, "n1", "n2", "n3")
+
+	// A different leader for each group.
+	leaders := map[string]*shardedNode{ad.MetaGroup: nodes[0], ad.ShardName(0): nodes[1], ad.ShardName(1): nodes[2]}
+	for name, n := range leaders {
+		g := n.raft.Group(name)
+		c.WaitFor(n.id+" to lead "+name, func() bool {
+			n.clocks[name].Advance(c.electionSpan())
+			return c.poll(time.Second, g.IsLeader)
+		})
+	}
+	// Leaders heartbeat until the test ends, so followers learn commits.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				for name, n := range leaders {
+					n.clocks[name].Advance(cfg.HeartbeatInterval)
+				}
+			}
+		}
+	}()
+	for name, n := range leaders {
+		for _, other := range nodes {
+			g := other.raft.Group(name)
+			c.WaitFor(other.id+" to follow "+n.id+" in "+name, func() bool { return g.LeaderID() == n.id })
+		}
+	}
+
+	// Proposed on n3, which leads neither the meta group nor the group of
+	// most entries: Propose forwards them and returns once n3 applied them.
+	n3 := nodes[2]
+	alice, err := n3.raft.Propose(userEntry(t, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n3.storage.GetUserByID(alice.ID); err != nil {
+		t.Fatalf("alice not applied on n3: %v", err)
+	}
+	var groups []string
+	for i := range 4 {
+		e, err := ad.BuildEntryGroupCreate(&ad.Group{Name: fmt.Sprintf("g%d", i), CreatorID: alice.ID, CreatorUserName: "alice", GroupType: ad.GroupTypeNonHierarchical})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := n3.raft.Propose(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := n3.storage.GetGroupByID(res.ID); err != nil {
+			t.Fatalf("group %s not applied on n3: %v", res.ID, err)
+		}
+		groups = append(groups, res.ID)
+	}
+	appt, err := ad.BuildEntryApptCreateGroup(alice.ID, ad.Appointment{Title: "standup", GroupID: &groups[0], Start: time.Now(), End: time.Now().Add(time.Hour), Privacy: ad.PrivacyFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apptRes, err := n3.raft.Propose(appt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every node ends up with everything, each entry committed by one group.
+	for _, n := range nodes {
+		c.WaitFor(n.id+" to apply the groups and the appointment", func() bool {
+			for _, id := range groups {
+				if _, err := n.storage.GetGroupByID(id); err != nil {
+					return false
+				}
+			}
+			_, err := n.storage.GetAppointmentByID(apptRes.ID)
+			return err == nil
+		})
+	}
+	ops := map[string][]string{}
+	for _, name := range []string{ad.MetaGroup, ad.ShardName(0), ad.ShardName(1)} {
+		page, err := nodes[0].raft.Group(name).Changes(context.Background(), ad.ChangeQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ev := range page.Changes {
+			ops[name] = append(ops[name], ev.Op)
+		}
+	}
+	if want := []string{ad.OpUserCreate}; !slices.Equal(ops[ad.MetaGroup], want) {
+		t.Fatalf("meta group applied %v, want %v", ops[ad.MetaGroup], want)
+	}
+	sharded := append(ops[ad.ShardName(0)], ops[ad.ShardName(1)]...)
+	if len(ops[ad.ShardName(0)]) == 0 || len(ops[ad.ShardName(1)]) == 0 || len(sharded) != 5 {
+		t.Fatalf("shards applied %v", ops)
+	}
+	if !slices.Contains(sharded, ad.OpApptCreateGroup) || strings.Count(strings.Join(sharded, ","), ad.OpGroupCreate) != 4 {
+		t.Fatalf("shards applied %v", ops)
+	}
+
+	// The meta group compares only the tables it owns, which the shards never
+	// write.
+	report, err := nodes[0].raft.Meta().CheckReplicas(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Diverged || len(report.Replicas) != 2 {
+		t.Fatalf("meta digest report %+v", report)
+	}
+	for _, r := range report.Replicas {
+		if r.Status != ad.DigestStatusMatch {
+			t.Fatalf("meta digest report %+v", report)
+		}
+	}
+}
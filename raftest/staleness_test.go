@@ -0,0 +1,73 @@
+package raftest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	ad "distributed-agenda"
+)
+
+func TestBoundedStalenessReads(t *testing.T) {
+	c := NewCluster(t, 3)
+	c.ElectLeader("n1")
+	res := c.MustPropose(userEntry(t, "alice"))
+	c.AssertConverged()
+	n2 := c.Node("n2")
+
+	// Stands in for the leader's API; a read proxied there (through n2's
+	// transport) is answered here.
+	c.Net.Attach("leader-api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ad.AppliedIndexHeader, "999")
+		fmt.Fprint(w, "leader")
+	}))
+	r := mux.NewRouter()
+	r.Use(ad.LeaderWriteMiddleware(n2.Consensus, func(string) string { return "leader-api" }))
+	r.HandleFunc("/api/agenda", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "local") })
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/agenda", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Fresh enough: served by the follower, which reports its progress.
+	var rec *httptest.ResponseRecorder
+	c.WaitFor("n2 to serve the read", func() bool {
+		rec = get(map[string]string{ad.MinAppliedIndexHeader: strconv.FormatInt(res.Index, 10), ad.MaxStalenessHeader: "1s"})
+		return rec.Body.String() == "local"
+	})
+	st := n2.Consensus.Status()
+	if got := rec.Header().Get(ad.AppliedIndexHeader); got != strconv.FormatInt(st.LastApplied, 10) {
+		t.Errorf("applied index header %q, want %d", got, st.LastApplied)
+	}
+	if got := rec.Header().Get(ad.TermHeader); got != strconv.FormatInt(st.Term, 10) {
+		t.Errorf("term header %q, want %d", got, st.Term)
+	}
+
+	// An index n2 has not applied, or no word from the leader for longer than
+	// allowed: the read goes to the leader, whose progress is reported.
+	c.Isolate("n2")
+	n2.Clock.Advance(10 * time.Millisecond)
+	for _, h := range []map[string]string{
+		{ad.MinAppliedIndexHeader: strconv.FormatInt(st.LastApplied+1, 10)},
+		{ad.MaxStalenessHeader: "5ms"},
+	} {
+		rec := get(h)
+		if rec.Body.String() != "leader" || rec.Header().Get(ad.AppliedIndexHeader) != "999" {
+			t.Errorf("read with %v: got %q, applied index %q, want the leader's answer", h, rec.Body.String(), rec.Header().Get(ad.AppliedIndexHeader))
+		}
+	}
+
+	if rec := get(map[string]string{ad.MaxStalenessHeader: "soon"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed bound: status %d, want 400", rec.Code)
+	}
+}
@@ -14,7 +14,7 @@ import (
 func StartGroupReconciler(store *Storage, cons Consensus, peers PeerStore) {
 	// Reduced interval for faster reconciliation to avoid leader changes interrupting it
 	interval := 10 * time.Second
-	client := &http.Client{Timeout: 3 * time.Second}
+	client := clusterHTTPClient(cons, 3*time.Second)
 	secret := strings.TrimSpace(os.Getenv("CLUSTER_HMAC_SECRET"))
 	if secret == "" {
 		Logger().Warn("group_reconciler_disabled_no_secret")
@@ -164,7 +164,7 @@ func StartGroupReconciler(store *Storage, cons Consensus, peers PeerStore) {
 							Logger().Warn("group_reconcile_build_entry_failed", "peer", id, "group_name", p.Name, "creator_username", p.CreatorUsername, "err", err)
 							continue
 						}
-						if err := cons.Propose(entry); err != nil {
+						if _, err := cons.Propose(entry); err != nil {
 							Logger().Warn("group_reconcile_propose_failed", "peer", id, "group_name", p.Name, "creator_username", p.CreatorUsername, "err", err)
 							continue
 						}
@@ -254,7 +254,7 @@ func StartGroupReconciler(store *Storage, cons Consensus, peers PeerStore) {
 							Logger().Warn("group_member_reconcile_build_entry_failed", "peer", id, "group_id", p.GroupID, "username", p.Username, "err", err)
 							continue
 						}
-						if err := cons.Propose(entry); err != nil {
+						if _, err := cons.Propose(entry); err != nil {
 							Logger().Warn("group_member_reconcile_propose_failed", "peer", id, "group_id", p.GroupID, "username", p.Username, "err", err)
 							continue
 						}
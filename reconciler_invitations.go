@@ -15,7 +15,7 @@ import (
 func StartInvitationReconciler(store *Storage, cons Consensus, peers PeerStore) {
 	// Reduced interval for faster reconciliation to avoid leader changes interrupting it
 	interval := 10 * time.Second
-	client := &http.Client{Timeout: 3 * time.Second}
+	client := clusterHTTPClient(cons, 3*time.Second)
 	secret := strings.TrimSpace(os.Getenv("CLUSTER_HMAC_SECRET"))
 	if secret == "" {
 		Logger().Warn("invitation_reconciler_disabled_no_secret")
@@ -210,7 +210,7 @@ func StartInvitationReconciler(store *Storage, cons Consensus, peers PeerStore)
 						Logger().Warn("invitation_reconcile_build_entry_failed", "peer", id, "appointment_id", localAppointmentID, "username", p.Username, "status", p.Status, "err", err)
 						continue
 					}
-					if err := cons.Propose(entry); err != nil {
+					if _, err := cons.Propose(entry); err != nil {
 						Logger().Warn("invitation_reconcile_propose_failed", "peer", id, "appointment_id", localAppointmentID, "username", p.Username, "status", p.Status, "err", err)
 						continue
 					}
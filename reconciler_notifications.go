@@ -15,7 +15,7 @@ import (
 func StartNotificationReconciler(store *Storage, cons Consensus, peers PeerStore) {
 	// Reduced interval for faster reconciliation to avoid leader changes interrupting it
 	interval := 10 * time.Second
-	client := &http.Client{Timeout: 3 * time.Second}
+	client := clusterHTTPClient(cons, 3*time.Second)
 	secret := strings.TrimSpace(os.Getenv("CLUSTER_HMAC_SECRET"))
 	if secret == "" {
 		Logger().Warn("notification_reconciler_disabled_no_secret")
@@ -151,7 +151,7 @@ func StartNotificationReconciler(store *Storage, cons Consensus, peers PeerStore
 						Logger().Warn("notification_reconcile_build_entry_failed", "peer", id, "username", p.Username, "type", p.Type, "err", err)
 						continue
 					}
-					if err := cons.Propose(entry); err != nil {
+					if _, err := cons.Propose(entry); err != nil {
 						Logger().Warn("notification_reconcile_propose_failed", "peer", id, "username", p.Username, "type", p.Type, "err", err)
 						continue
 					}
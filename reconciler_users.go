@@ -15,7 +15,7 @@ import (
 func StartUserReconciler(store *Storage, cons Consensus, peers PeerStore) {
 	// Reduced interval for faster reconciliation to avoid leader changes interrupting it
 	interval := 10 * time.Second
-	client := &http.Client{Timeout: 3 * time.Second}
+	client := clusterHTTPClient(cons, 3*time.Second)
 	secret := strings.TrimSpace(os.Getenv("CLUSTER_HMAC_SECRET"))
 	if secret == "" {
 		// Without a shared secret we cannot safely call cluster endpoints.
@@ -150,7 +150,7 @@ func StartUserReconciler(store *Storage, cons Consensus, peers PeerStore) {
 						Logger().Warn("user_reconcile_build_entry_failed", "peer", id, "username", username, "email", email, "err", err)
 						continue
 					}
-					if err := cons.Propose(entryLog); err != nil {
+					if _, err := cons.Propose(entryLog); err != nil {
 						Logger().Warn("user_reconcile_propose_failed", "peer", id, "username", username, "email", email, "err", err)
 						continue
 					}
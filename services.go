@@ -366,6 +366,8 @@ type appointmentService struct {
 	events EventBus
 	repl   ReplicationService
 	cons   Consensus
+	// sesión de cliente de la petición en curso (ver WithClientRequest)
+	req ClientRequest
 }
 
 func NewAppointmentService(
@@ -383,11 +385,50 @@ func (s *appointmentService) SetConsensus(c Consensus) {
 	s.cons = c
 }
 
+// WithClientRequest returns a copy of the service whose proposals carry req,
+// so a retried request is applied only once.
+func (s *appointmentService) WithClientRequest(req ClientRequest) AppointmentService {
+	bound := *s
+	bound.req = req
+	return &bound
+}
+
+// replayed returns the cached outcome of the bound client request if the
+// state machine already applied it.
+func (s *appointmentService) replayed() (ApplyResult, bool, error) {
+	if s.cons == nil || s.req.ClientID == "" || !s.cons.IsLeader() {
+		return ApplyResult{}, false, nil
+	}
+	res, found, err := s.cons.SessionResult(s.req)
+	if found && err == nil {
+		s.req.written.note(res)
+	}
+	return res, found, err
+}
+
+// propose replicates entry tagged with the bound client request. Check the
+// error with proposeFailed: a committed entry may come with
+// ErrNotAppliedLocally.
+func (s *appointmentService) propose(entry LogEntry) (ApplyResult, error) {
+	res, err := s.cons.Propose(s.req.stamp(entry))
+	if !proposeFailed(err) {
+		s.req.written.note(res)
+	}
+	return res, err
+}
+
 // 🔥 MODIFICADO: cita personal
 func (s *appointmentService) CreatePersonalAppointment(ownerID string, a Appointment) (*Appointment, error) {
 	if a.Start.After(a.End) {
 		return nil, ErrInvalidInput
 	}
+	// Retry of a request already applied: answer with what it created.
+	if res, found, err := s.replayed(); found || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return s.apps.GetAppointmentByID(res.ID)
+	}
 	// conflicto
 	conflict, err := s.apps.HasConflict(ownerID, a.Start, a.End)
 	if err != nil {
@@ -404,21 +445,20 @@ func (s *appointmentService) CreatePersonalAppointment(ownerID string, a Appoint
 		if err != nil {
 			return nil, err
 		}
-		if err := s.cons.Propose(entry); err != nil {
+		res, err := s.propose(entry)
+		if proposeFailed(err) {
 			return nil, err
 		}
-		// Try to retrieve the created appointment heuristically
-		// using a tight time window around now
-		windowStart := a.Start.Add(-1 * time.Second)
-		windowEnd := a.End.Add(1 * time.Second)
-		agenda, err := s.apps.GetUserAgenda(ownerID, windowStart, windowEnd)
 		if err == nil {
-			for _, cand := range agenda {
-				if cand.Title == a.Title && cand.Start.Equal(a.Start) && cand.End.Equal(a.End) {
-					a = cand
-					break
-				}
+			// Load exactly the appointment the state machine created
+			created, err := s.apps.GetAppointmentByID(res.ID)
+			if err != nil {
+				return nil, err
 			}
+			a = *created
+		} else {
+			// Committed but not applied here yet: answer with what was proposed.
+			a.ID = res.ID
 		}
 	} else {
 		if err := s.apps.CreateAppointment(&a); err != nil {
@@ -483,40 +523,44 @@ func (s *appointmentService) CreateGroupAppointment(ownerID string, a Appointmen
 		if err != nil {
 			return nil, nil, err
 		}
-		if err := s.cons.Propose(entry); err != nil {
+		res, found, err := s.replayed()
+		if !found && err == nil {
+			res, err = s.propose(entry)
+		}
+		if proposeFailed(err) {
 			return nil, nil, err
 		}
-		// After commit, try to reload the created appointment and its participants
-		// using a small time window and matching by title/group.
-		windowStart := a.Start.Add(-1 * time.Second)
-		windowEnd := a.End.Add(1 * time.Second)
-		apps, err := s.apps.GetGroupAgenda(*a.GroupID, windowStart, windowEnd)
-		if err == nil {
-			for _, cand := range apps {
-				if cand.Title == a.Title && cand.Start.Equal(a.Start) && cand.End.Equal(a.End) {
-					// Found the created appointment; load participants
-					partsDetails, err := s.apps.GetAppointmentParticipants(cand.ID)
-					if err != nil {
-						return &cand, nil, nil
-					}
-					parts := make([]Participant, 0, len(partsDetails))
-					for _, pd := range partsDetails {
-						parts = append(parts, Participant{
-							ID:            pd.ID,
-							AppointmentID: pd.AppointmentID,
-							UserID:        pd.UserID,
-							Status:        pd.Status,
-							IsOptional:    pd.IsOptional,
-							CreatedAt:     pd.CreatedAt,
-							UpdatedAt:     pd.UpdatedAt,
-						})
-					}
-					return &cand, parts, nil
-				}
+		if err != nil {
+			// Committed but not applied here yet: answer with what was proposed.
+			a.ID = res.ID
+			return &a, res.Participants, nil
+		}
+		created, err := s.apps.GetAppointmentByID(res.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts := res.Participants
+		if parts == nil {
+			// Replay of an existing appointment: the state machine created
+			// nothing, so report the participants it already has.
+			partsDetails, err := s.apps.GetAppointmentParticipants(created.ID)
+			if err != nil {
+				return created, nil, nil
+			}
+			parts = make([]Participant, 0, len(partsDetails))
+			for _, pd := range partsDetails {
+				parts = append(parts, Participant{
+					ID:            pd.ID,
+					AppointmentID: pd.AppointmentID,
+					UserID:        pd.UserID,
+					Status:        pd.Status,
+					IsOptional:    pd.IsOptional,
+					CreatedAt:     pd.CreatedAt,
+					UpdatedAt:     pd.UpdatedAt,
+				})
 			}
 		}
-		// If we cannot reliably reload, return the original struct without participants
-		return &a, nil, nil
+		return created, parts, nil
 	}
 
 	// Fallback: single-node / no-consensus path
@@ -579,7 +623,7 @@ func (s *appointmentService) UpdateAppointment(ownerID string, a Appointment) (*
 		if err != nil {
 			return nil, err
 		}
-		if err := s.cons.Propose(entry); err != nil {
+		if _, err := s.propose(entry); proposeFailed(err) {
 			return nil, err
 		}
 	} else {
@@ -603,6 +647,9 @@ func (s *appointmentService) UpdateAppointment(ownerID string, a Appointment) (*
 
 // DeleteAppointment deletes an appointment
 func (s *appointmentService) DeleteAppointment(ownerID string, appointmentID string) error {
+	if _, found, err := s.replayed(); found || err != nil {
+		return err
+	}
 	// First, get the existing appointment to verify ownership
 	existing, err := s.apps.GetAppointmentByID(appointmentID)
 	if err != nil {
@@ -618,7 +665,7 @@ func (s *appointmentService) DeleteAppointment(ownerID string, appointmentID str
 		if err != nil {
 			return err
 		}
-		if err := s.cons.Propose(entry); err != nil {
+		if _, err := s.propose(entry); proposeFailed(err) {
 			return err
 		}
 	} else {
@@ -3,6 +3,7 @@ package agendadistribuida
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -13,6 +14,80 @@ import (
 
 type Storage struct {
 	db *sql.DB
+	// tx, when set, is the transaction every statement runs in (see withTx).
+	tx *sql.Tx
+}
+
+// sqlConn is what the statements of a Storage run on: its database or the
+// transaction it is bound to.
+type sqlConn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (s *Storage) conn() sqlConn {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// withTx returns a Storage running every statement in tx, which the caller
+// commits or rolls back. The state machine applies an entry through it, so
+// the entry and its bookkeeping are written together.
+func (s *Storage) withTx(tx *sql.Tx) *Storage {
+	return &Storage{db: s.db, tx: tx}
+}
+
+// storageTx is a transaction of a Storage: a transaction of its own, or a
+// savepoint of the transaction the Storage is bound to.
+type storageTx struct {
+	*sql.Tx
+	savepoint bool
+	done      bool
+}
+
+// begin starts a transaction, nested as a savepoint when s is bound to one.
+func (s *Storage) begin() (*storageTx, error) {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		return &storageTx{Tx: tx}, nil
+	}
+	if _, err := s.tx.Exec(`SAVEPOINT storage_tx`); err != nil {
+		return nil, err
+	}
+	return &storageTx{Tx: s.tx, savepoint: true}, nil
+}
+
+func (t *storageTx) Commit() error {
+	if !t.savepoint {
+		return t.Tx.Commit()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Exec(`RELEASE storage_tx`)
+	return err
+}
+
+func (t *storageTx) Rollback() error {
+	if !t.savepoint {
+		return t.Tx.Rollback()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	if _, err := t.Exec(`ROLLBACK TO storage_tx`); err != nil {
+		return err
+	}
+	_, err := t.Exec(`RELEASE storage_tx`)
+	return err
 }
 
 // 🔥 NUEVO: aseguramos que Storage cumple con todas las interfaces
@@ -27,7 +102,7 @@ var (
 
 // Inicializa conexión y migraciones
 func NewStorage(dsn string) (*Storage, error) {
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := sql.Open("sqlite3", durableDSN(dsn))
 	if err != nil {
 		return nil, err
 	}
@@ -38,162 +113,9 @@ func NewStorage(dsn string) (*Storage, error) {
 	return s, nil
 }
 
-// ====================
-// Migraciones
-// ====================
-func (s *Storage) migrate() error {
-	schema := `
-DROP TABLE IF EXISTS users;
-DROP TABLE IF EXISTS groups;
-DROP TABLE IF EXISTS group_members;
-DROP TABLE IF EXISTS appointments;
-DROP TABLE IF EXISTS participants;
-DROP TABLE IF EXISTS notifications;
-DROP TABLE IF EXISTS events;
-DROP TABLE IF EXISTS cluster_nodes;
-DROP TABLE IF EXISTS audit_logs;
-DROP TABLE IF EXISTS raft_log;
-DROP TABLE IF EXISTS raft_meta;
-DROP TABLE IF EXISTS raft_applied;
-
-CREATE TABLE IF NOT EXISTS users (
-	id TEXT PRIMARY KEY,
-	username TEXT UNIQUE NOT NULL,
-	email TEXT UNIQUE,
-	password_hash TEXT NOT NULL,
-	display_name TEXT,
-	created_at DATETIME NOT NULL,
-	updated_at DATETIME NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS groups (
-    id TEXT PRIMARY KEY,
-    name TEXT NOT NULL,
-    description TEXT,
-    created_at DATETIME NOT NULL,
-    updated_at DATETIME NOT NULL,
-    creator_id TEXT,
-    creator_username TEXT,
-    group_type TEXT NOT NULL DEFAULT 'hierarchical'
-);
-
-CREATE TABLE IF NOT EXISTS group_members (
-	group_id TEXT NOT NULL,
-	user_id TEXT NOT NULL,
-	rank INTEGER NOT NULL DEFAULT 0,
-	added_by TEXT,
-	created_at DATETIME NOT NULL,
-	PRIMARY KEY (group_id, user_id)
-);
-
-CREATE TABLE IF NOT EXISTS appointments (
-	id TEXT PRIMARY KEY,
-	title TEXT NOT NULL,
-	description TEXT,
-	owner_id TEXT NOT NULL,
-	group_id TEXT,
-	start_ts INTEGER NOT NULL,
-	end_ts INTEGER NOT NULL,
-	privacy TEXT NOT NULL,
-	status TEXT NOT NULL,
-	version INTEGER DEFAULT 1,
-	origin_node TEXT,
-	deleted INTEGER DEFAULT 0,
-	created_at DATETIME NOT NULL,
-	updated_at DATETIME NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS participants (
-	id TEXT PRIMARY KEY,
-	appointment_id TEXT NOT NULL,
-	user_id TEXT NOT NULL,
-	status TEXT NOT NULL,
-	is_optional INTEGER DEFAULT 0,
-	created_at DATETIME NOT NULL,
-	updated_at DATETIME NOT NULL
-);
-
-CREATE UNIQUE INDEX IF NOT EXISTS participants_appt_user_uniq ON participants(appointment_id, user_id);
-
-CREATE TABLE IF NOT EXISTS notifications (
-	id TEXT PRIMARY KEY,
-	user_id TEXT NOT NULL,
-	type TEXT NOT NULL,
-	payload TEXT,
-	read_at DATETIME,
-	created_at DATETIME NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS events (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	entity TEXT NOT NULL,
-	entity_id TEXT NOT NULL,
-	action TEXT NOT NULL,
-	payload TEXT NOT NULL,
-	created_at DATETIME NOT NULL,
-	origin_node TEXT,
-	version INTEGER
-);
-
-CREATE TABLE IF NOT EXISTS cluster_nodes (
-    node_id TEXT PRIMARY KEY,
-    address TEXT NOT NULL,
-    source TEXT,
-    last_seen DATETIME NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS cluster_nodes_last_seen_idx ON cluster_nodes(last_seen);
-
-CREATE TABLE IF NOT EXISTS audit_logs (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    component TEXT NOT NULL,
-    action TEXT NOT NULL,
-    level TEXT NOT NULL,
-    message TEXT NOT NULL,
-    actor_id TEXT,
-    request_id TEXT,
-    node_id TEXT,
-    payload TEXT,
-    occurred_at DATETIME NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS audit_component_idx ON audit_logs(component, action);
-
--- Raft / Consenso: log replicado y metadatos persistentes
-CREATE TABLE IF NOT EXISTS raft_log (
-    term INTEGER NOT NULL,
-    idx INTEGER NOT NULL,
-    event_id TEXT UNIQUE,
-    aggregate TEXT NOT NULL,
-    aggregate_id TEXT NOT NULL,
-    op TEXT NOT NULL,
-    payload TEXT NOT NULL,
-    ts DATETIME NOT NULL,
-    PRIMARY KEY(term, idx)
-);
-
-CREATE INDEX IF NOT EXISTS raft_log_idx ON raft_log(idx);
-
-CREATE TABLE IF NOT EXISTS raft_meta (
-    key TEXT PRIMARY KEY,
-    value TEXT NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS raft_applied (
-    event_id TEXT PRIMARY KEY,
-    idx INTEGER NOT NULL,
-    applied_at DATETIME NOT NULL
-);
-
--- Inicialización básica de claves si no existen
-INSERT OR IGNORE INTO raft_meta(key, value) VALUES
-    ('currentTerm', '0'),
-    ('votedFor', ''),
-    ('commitIndex', '0'),
-    ('lastApplied', '0');
-`
-	_, err := s.db.Exec(schema)
-	return err
+// Close closes the database.
+func (s *Storage) Close() error {
+	return s.db.Close()
 }
 
 // ====================
@@ -205,7 +127,7 @@ func (s *Storage) CreateUser(u *User) error {
 	if strings.TrimSpace(u.ID) == "" {
 		u.ID = UserIDFromUsername(u.Username)
 	}
-	_, err := s.db.Exec(`INSERT INTO users(id,username,email,password_hash,display_name,created_at,updated_at)
+	_, err := s.conn().Exec(`INSERT INTO users(id,username,email,password_hash,display_name,created_at,updated_at)
 		VALUES(?,?,?,?,?,?,?)`, u.ID, u.Username, u.Email, u.PasswordHash, u.DisplayName, now, now)
 	if err != nil {
 		return err
@@ -216,7 +138,7 @@ func (s *Storage) CreateUser(u *User) error {
 }
 
 func (s *Storage) GetUserByUsername(username string) (*User, error) {
-	row := s.db.QueryRow(`SELECT id, username, email, password_hash, display_name, created_at, updated_at 
+	row := s.conn().QueryRow(`SELECT id, username, email, password_hash, display_name, created_at, updated_at 
 		FROM users WHERE username=?`, username)
 	var u User
 	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.DisplayName, &u.CreatedAt, &u.UpdatedAt); err != nil {
@@ -226,7 +148,7 @@ func (s *Storage) GetUserByUsername(username string) (*User, error) {
 }
 
 func (s *Storage) GetUserByEmail(email string) (*User, error) {
-	row := s.db.QueryRow(`SELECT id, username, email, password_hash, display_name, created_at, updated_at 
+	row := s.conn().QueryRow(`SELECT id, username, email, password_hash, display_name, created_at, updated_at 
 		FROM users WHERE email=?`, email)
 	var u User
 	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.DisplayName, &u.CreatedAt, &u.UpdatedAt); err != nil {
@@ -236,7 +158,7 @@ func (s *Storage) GetUserByEmail(email string) (*User, error) {
 }
 
 func (s *Storage) GetUserByID(id string) (*User, error) {
-	row := s.db.QueryRow(`SELECT id, username, email, password_hash, display_name, created_at, updated_at FROM users WHERE id=?`, id)
+	row := s.conn().QueryRow(`SELECT id, username, email, password_hash, display_name, created_at, updated_at FROM users WHERE id=?`, id)
 	var u User
 	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.DisplayName, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		return nil, err
@@ -246,7 +168,7 @@ func (s *Storage) GetUserByID(id string) (*User, error) {
 
 func (s *Storage) UpdateUser(user *User) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE users 
+	_, err := s.conn().Exec(`UPDATE users 
 		SET username=?, email=?, display_name=?, updated_at=?
 		WHERE id=?`,
 		user.Username, user.Email, user.DisplayName, now, user.ID)
@@ -259,7 +181,7 @@ func (s *Storage) UpdateUser(user *User) error {
 
 func (s *Storage) UpdatePassword(userID string, newPasswordHash string) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE users 
+	_, err := s.conn().Exec(`UPDATE users 
 		SET password_hash=?, updated_at=?
 		WHERE id=?`,
 		newPasswordHash, now, userID)
@@ -359,7 +281,7 @@ func (s *Storage) EnsureUser(u *User) error {
 }
 
 func (s *Storage) ClearUserEmailIfMatches(userID string, email string) error {
-	_, err := s.db.Exec(`UPDATE users SET email=NULL, updated_at=? WHERE id=? AND email=?`, time.Now(), userID, email)
+	_, err := s.conn().Exec(`UPDATE users SET email=NULL, updated_at=? WHERE id=? AND email=?`, time.Now(), userID, email)
 	return err
 }
 
@@ -372,7 +294,7 @@ func (s *Storage) CreateGroup(g *Group) error {
 	if strings.TrimSpace(g.ID) == "" {
 		g.ID = GroupIDFromSignature(g.GroupType, g.CreatorUserName, g.Name)
 	}
-	_, err := s.db.Exec(`INSERT INTO groups(id, name, description, created_at, updated_at, creator_id, creator_username, group_type) VALUES(?,?,?,?,?,?,?,?)`,
+	_, err := s.conn().Exec(`INSERT INTO groups(id, name, description, created_at, updated_at, creator_id, creator_username, group_type) VALUES(?,?,?,?,?,?,?,?)`,
 		g.ID, g.Name, g.Description, now, now, g.CreatorID, g.CreatorUserName, g.GroupType)
 	if err != nil {
 		return err
@@ -396,7 +318,7 @@ func (s *Storage) CreateGroup(g *Group) error {
 
 func (s *Storage) AddGroupMember(groupID, userID string, rank int, addedBy *string) error {
 	now := time.Now()
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO group_members(group_id,user_id,rank,added_by,created_at)
+	_, err := s.conn().Exec(`INSERT OR REPLACE INTO group_members(group_id,user_id,rank,added_by,created_at)
 		VALUES(?,?,?,?,?)`, groupID, userID, rank, addedBy, now)
 	if err != nil {
 		return err
@@ -424,7 +346,7 @@ func (s *Storage) EnsureGroupMember(groupID, userID string, rank int, addedBy *s
 }
 
 func (s *Storage) GetMemberRank(groupID, userID string) (int, error) {
-	row := s.db.QueryRow(`SELECT rank FROM group_members WHERE group_id=? AND user_id=?`, groupID, userID)
+	row := s.conn().QueryRow(`SELECT rank FROM group_members WHERE group_id=? AND user_id=?`, groupID, userID)
 	var r int
 	if err := row.Scan(&r); err != nil {
 		return 0, err
@@ -445,7 +367,7 @@ func (s *Storage) IsSuperior(groupID, userA, userB string) (bool, error) {
 }
 
 func (s *Storage) GetGroupMembers(groupID string) ([]GroupMember, error) {
-	rows, err := s.db.Query(`
+	rows, err := s.conn().Query(`
         SELECT gm.group_id, gm.user_id, gm.rank, gm.added_by, gm.created_at, u.username
         FROM group_members gm
         LEFT JOIN users u ON gm.user_id = u.id
@@ -471,7 +393,7 @@ func (s *Storage) GetGroupMembers(groupID string) ([]GroupMember, error) {
 
 // Fetch group by ID
 func (s *Storage) GetGroupByID(id string) (*Group, error) {
-	row := s.db.QueryRow(`SELECT id,name,description,created_at,updated_at,creator_id,creator_username,group_type FROM groups WHERE id=?`, id)
+	row := s.conn().QueryRow(`SELECT id,name,description,created_at,updated_at,creator_id,creator_username,group_type FROM groups WHERE id=?`, id)
 	var g Group
 	if err := row.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &g.UpdatedAt, &g.CreatorID, &g.CreatorUserName, &g.GroupType); err != nil {
 		return nil, err
@@ -483,7 +405,7 @@ func (s *Storage) GetGroupByID(id string) (*Group, error) {
 // already exists based on creator and name/group_type.
 func (s *Storage) FindGroupBySignature(name string, creatorID string, groupType GroupType) (string, error) {
 	var id string
-	err := s.db.QueryRow(`SELECT id FROM groups WHERE name=? AND creator_id=? AND group_type=? LIMIT 1`,
+	err := s.conn().QueryRow(`SELECT id FROM groups WHERE name=? AND creator_id=? AND group_type=? LIMIT 1`,
 		name, creatorID, groupType).Scan(&id)
 	if err == sql.ErrNoRows {
 		return "", nil
@@ -493,7 +415,7 @@ func (s *Storage) FindGroupBySignature(name string, creatorID string, groupType
 
 // List all groups the user belongs to
 func (s *Storage) GetGroupsForUser(userID string) ([]Group, error) {
-	rows, err := s.db.Query(`
+	rows, err := s.conn().Query(`
 		SELECT g.id, g.name, g.description, g.created_at, g.updated_at, g.creator_id, g.creator_username, g.group_type
 		FROM groups g
 		JOIN group_members gm ON gm.group_id = g.id
@@ -518,7 +440,7 @@ func (s *Storage) GetGroupsForUser(userID string) ([]Group, error) {
 // UpdateGroup updates group information
 func (s *Storage) UpdateGroup(g *Group) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE groups 
+	_, err := s.conn().Exec(`UPDATE groups 
 		SET name=?, description=?, updated_at=?
 		WHERE id=?`,
 		g.Name, g.Description, now, g.ID)
@@ -532,7 +454,7 @@ func (s *Storage) UpdateGroup(g *Group) error {
 // DeleteGroup deletes a group and all its members
 func (s *Storage) DeleteGroup(groupID string) error {
 	// Start transaction to ensure atomicity
-	tx, err := s.db.Begin()
+	tx, err := s.begin()
 	if err != nil {
 		return err
 	}
@@ -566,7 +488,7 @@ func (s *Storage) DeleteGroup(groupID string) error {
 
 // UpdateGroupMember updates a member's rank
 func (s *Storage) UpdateGroupMember(groupID, userID string, rank int) error {
-	_, err := s.db.Exec(`UPDATE group_members 
+	_, err := s.conn().Exec(`UPDATE group_members 
 		SET rank=?
 		WHERE group_id=? AND user_id=?`,
 		rank, groupID, userID)
@@ -576,7 +498,7 @@ func (s *Storage) UpdateGroupMember(groupID, userID string, rank int) error {
 // RemoveGroupMember removes a member from a group
 func (s *Storage) RemoveGroupMember(groupID, userID string) error {
 	// Start transaction to ensure atomicity
-	tx, err := s.db.Begin()
+	tx, err := s.begin()
 	if err != nil {
 		return err
 	}
@@ -610,7 +532,7 @@ func (s *Storage) RemoveGroupMember(groupID, userID string) error {
 
 func (s *Storage) UpdateParticipantStatus(appointmentID, userID string, status ApptStatus) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE participants 
+	_, err := s.conn().Exec(`UPDATE participants 
 		SET status=?, updated_at=?
 		WHERE appointment_id=? AND user_id=?`,
 		status, now, appointmentID, userID)
@@ -666,7 +588,7 @@ func (s *Storage) UpdateParticipantStatus(appointmentID, userID string, status A
 
 // GetParticipantByAppointmentAndUser gets a specific participant
 func (s *Storage) GetParticipantByAppointmentAndUser(appointmentID, userID string) (*Participant, error) {
-	row := s.db.QueryRow(`SELECT id, appointment_id, user_id, status, is_optional, created_at, updated_at 
+	row := s.conn().QueryRow(`SELECT id, appointment_id, user_id, status, is_optional, created_at, updated_at 
 		FROM participants WHERE appointment_id=? AND user_id=?`, appointmentID, userID)
 	var p Participant
 	if err := row.Scan(&p.ID, &p.AppointmentID, &p.UserID, &p.Status, &p.IsOptional, &p.CreatedAt, &p.UpdatedAt); err != nil {
@@ -681,7 +603,7 @@ func (s *Storage) GetParticipantByAppointmentAndUser(appointmentID, userID strin
 func (s *Storage) FindAppointmentBySignature(ownerID string, groupID *string, start, end time.Time, title string) (string, error) {
 	if groupID == nil {
 		var id string
-		err := s.db.QueryRow(`SELECT id FROM appointments
+		err := s.conn().QueryRow(`SELECT id FROM appointments
 			WHERE owner_id=? AND group_id IS NULL AND start_ts=? AND end_ts=? AND title=? AND deleted=0
 			ORDER BY created_at DESC LIMIT 1`, ownerID, start.Unix(), end.Unix(), title).Scan(&id)
 		if err == sql.ErrNoRows {
@@ -690,7 +612,7 @@ func (s *Storage) FindAppointmentBySignature(ownerID string, groupID *string, st
 		return id, err
 	}
 	var id string
-	err := s.db.QueryRow(`SELECT id FROM appointments
+	err := s.conn().QueryRow(`SELECT id FROM appointments
 		WHERE owner_id=? AND group_id=? AND start_ts=? AND end_ts=? AND title=? AND deleted=0
 		ORDER BY created_at DESC LIMIT 1`, ownerID, *groupID, start.Unix(), end.Unix(), title).Scan(&id)
 	if err == sql.ErrNoRows {
@@ -717,7 +639,7 @@ func (s *Storage) CreateAppointment(a *Appointment) error {
 		}
 		a.ID = AppointmentIDFromSignature(ownerUsername, groupSig, a.Start, a.End, a.Title)
 	}
-	_, err := s.db.Exec(`INSERT INTO appointments(id,title,description,owner_id,group_id,start_ts,end_ts,privacy,status,version,origin_node,deleted,created_at,updated_at)
+	_, err := s.conn().Exec(`INSERT INTO appointments(id,title,description,owner_id,group_id,start_ts,end_ts,privacy,status,version,origin_node,deleted,created_at,updated_at)
 		VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 		a.ID, a.Title, a.Description, a.OwnerID, a.GroupID,
 		a.Start.Unix(), a.End.Unix(), a.Privacy, a.Status,
@@ -762,7 +684,7 @@ func (s *Storage) CreateAppointment(a *Appointment) error {
 
 func (s *Storage) UpdateAppointment(a *Appointment) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE appointments 
+	_, err := s.conn().Exec(`UPDATE appointments 
 		SET title=?, description=?, start_ts=?, end_ts=?, privacy=?, updated_at=?, version=version+1
 		WHERE id=? AND deleted=0`,
 		a.Title, a.Description, a.Start.Unix(), a.End.Unix(), a.Privacy, now, a.ID)
@@ -775,7 +697,7 @@ func (s *Storage) UpdateAppointment(a *Appointment) error {
 
 func (s *Storage) DeleteAppointment(appointmentID string) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE appointments 
+	_, err := s.conn().Exec(`UPDATE appointments 
 		SET deleted=1, updated_at=?, version=version+1
 		WHERE id=?`,
 		now, appointmentID)
@@ -787,7 +709,7 @@ func (s *Storage) AddParticipant(p *Participant) error {
 	if strings.TrimSpace(p.ID) == "" {
 		p.ID = stableID("participant", p.AppointmentID+":"+p.UserID)
 	}
-	_, err := s.db.Exec(`INSERT INTO participants(id,appointment_id,user_id,status,is_optional,created_at,updated_at)
+	_, err := s.conn().Exec(`INSERT INTO participants(id,appointment_id,user_id,status,is_optional,created_at,updated_at)
 		VALUES(?,?,?,?,?,?,?)`,
 		p.ID, p.AppointmentID, p.UserID, p.Status, p.IsOptional, now, now)
 	if err != nil {
@@ -818,7 +740,7 @@ WHERE p.user_id = ?
   AND p.status IN ('accepted','auto')
   AND NOT (a.end_ts <= ? OR a.start_ts >= ?)`
 	var cnt int
-	row := s.db.QueryRow(q, userID, start.Unix(), end.Unix())
+	row := s.conn().QueryRow(q, userID, start.Unix(), end.Unix())
 	if err := row.Scan(&cnt); err != nil {
 		return false, err
 	}
@@ -836,7 +758,7 @@ WHERE p.user_id = ?
   AND p.status IN ('accepted','auto')
   AND NOT (a.end_ts <= ? OR a.start_ts >= ?)`
 	var cnt int
-	row := s.db.QueryRow(q, userID, excludeAppointmentID, start.Unix(), end.Unix())
+	row := s.conn().QueryRow(q, userID, excludeAppointmentID, start.Unix(), end.Unix())
 	if err := row.Scan(&cnt); err != nil {
 		return false, err
 	}
@@ -857,7 +779,7 @@ func (s *Storage) CreateGroupAppointment(a *Appointment) ([]Participant, error)
 		a.ID = AppointmentIDFromSignature(ownerUsername, *a.GroupID, a.Start, a.End, a.Title)
 	}
 
-	tx, err := s.db.Begin()
+	tx, err := s.begin()
 	if err != nil {
 		return nil, err
 	}
@@ -1002,7 +924,7 @@ func (s *Storage) AddNotification(n *Notification) error {
 	if strings.TrimSpace(n.ID) == "" {
 		n.ID = stableID("notification", n.UserID+":"+n.Type+":"+n.Payload)
 	}
-	_, err := s.db.Exec(`INSERT INTO notifications(id,user_id,type,payload,read_at,created_at)
+	_, err := s.conn().Exec(`INSERT INTO notifications(id,user_id,type,payload,read_at,created_at)
 		VALUES(?,?,?,?,?,?)`,
 		n.ID, n.UserID, n.Type, n.Payload, n.ReadAt, now)
 	if err != nil {
@@ -1030,7 +952,7 @@ func (s *Storage) AddNotification(n *Notification) error {
 }
 
 func (s *Storage) FindNotificationBySignature(userID string, nType, payload string) (string, error) {
-	row := s.db.QueryRow(`SELECT id FROM notifications WHERE user_id=? AND type=? AND payload=? LIMIT 1`, userID, nType, payload)
+	row := s.conn().QueryRow(`SELECT id FROM notifications WHERE user_id=? AND type=? AND payload=? LIMIT 1`, userID, nType, payload)
 	var id string
 	if err := row.Scan(&id); err != nil {
 		if err == sql.ErrNoRows {
@@ -1053,7 +975,7 @@ func (s *Storage) EnsureNotification(n *Notification) error {
 }
 
 func (s *Storage) GetUserNotifications(userID string) ([]Notification, error) {
-	rows, err := s.db.Query(`SELECT id,user_id,type,payload,read_at,created_at FROM notifications WHERE user_id=?`, userID)
+	rows, err := s.conn().Query(`SELECT id,user_id,type,payload,read_at,created_at FROM notifications WHERE user_id=?`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -1072,14 +994,14 @@ func (s *Storage) GetUserNotifications(userID string) ([]Notification, error) {
 // 🔥 NUEVO: marcar notificación como leída
 func (s *Storage) MarkNotificationRead(notificationID string) error {
 	now := time.Now()
-	_, err := s.db.Exec(`UPDATE notifications SET read_at=? WHERE id=?`, now, notificationID)
+	_, err := s.conn().Exec(`UPDATE notifications SET read_at=? WHERE id=?`, now, notificationID)
 	return err
 }
 
 // 🔥 NUEVO: obtener solo notificaciones no leídas
 
 func (s *Storage) GetUnreadNotifications(userID string) ([]Notification, error) {
-	rows, err := s.db.Query(`SELECT id,user_id,type,payload,read_at,created_at FROM notifications WHERE user_id=? AND read_at IS NULL`, userID)
+	rows, err := s.conn().Query(`SELECT id,user_id,type,payload,read_at,created_at FROM notifications WHERE user_id=? AND read_at IS NULL`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -1113,7 +1035,7 @@ WHERE p.user_id = ?
   AND a.deleted = 0
   AND NOT (a.end_ts <= ? OR a.start_ts >= ?)
 ORDER BY a.start_ts ASC`
-	rows, err := s.db.Query(q, userID, start.Unix(), end.Unix())
+	rows, err := s.conn().Query(q, userID, start.Unix(), end.Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -1147,7 +1069,7 @@ WHERE a.group_id = ?
   AND a.deleted = 0
   AND NOT (a.end_ts <= ? OR a.start_ts >= ?)
 ORDER BY a.start_ts ASC`
-	rows, err := s.db.Query(q, groupID, start.Unix(), end.Unix())
+	rows, err := s.conn().Query(q, groupID, start.Unix(), end.Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -1176,7 +1098,7 @@ ORDER BY a.start_ts ASC`
 // 🔥 NUEVO: AppendEvent para EventRepository
 func (s *Storage) AppendEvent(e *Event) error {
 	now := time.Now()
-	res, err := s.db.Exec(`INSERT INTO events(entity, entity_id, action, payload, created_at, origin_node, version)
+	res, err := s.conn().Exec(`INSERT INTO events(entity, entity_id, action, payload, created_at, origin_node, version)
 		VALUES(?,?,?,?,?,?,?)`,
 		e.Entity, e.EntityID, e.Action, e.Payload, now, e.OriginNode, e.Version)
 	if err != nil {
@@ -1210,7 +1132,7 @@ func (s *Storage) ListEvents(filter EventFilter) ([]Event, error) {
 		qry += " LIMIT ?"
 		args = append(args, filter.Limit)
 	}
-	rows, err := s.db.Query(qry, args...)
+	rows, err := s.conn().Query(qry, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1234,7 +1156,7 @@ func (s *Storage) AppendAudit(entry *AuditLog) error {
 	if entry.OccurredAt.IsZero() {
 		entry.OccurredAt = time.Now()
 	}
-	res, err := s.db.Exec(`INSERT INTO audit_logs(component, action, level, message, actor_id, request_id, node_id, payload, occurred_at)
+	res, err := s.conn().Exec(`INSERT INTO audit_logs(component, action, level, message, actor_id, request_id, node_id, payload, occurred_at)
 		VALUES(?,?,?,?,?,?,?,?,?)`,
 		entry.Component, entry.Action, entry.Level, entry.Message, entry.ActorID, entry.RequestID, entry.NodeID, entry.Payload, entry.OccurredAt)
 	if err != nil {
@@ -1285,7 +1207,7 @@ func (s *Storage) ListAuditLogs(filter AuditFilter) ([]AuditLog, error) {
 	query += " LIMIT ?"
 	args = append(args, limit)
 
-	rows, err := s.db.Query(query, args...)
+	rows, err := s.conn().Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -1317,7 +1239,7 @@ func (s *Storage) HasAppliedEvent(eventID string) (bool, error) {
 		return false, errors.New("empty event id")
 	}
 	var dummy int
-	err := s.db.QueryRow(`SELECT 1 FROM raft_applied WHERE event_id=?`, eventID).Scan(&dummy)
+	err := s.conn().QueryRow(`SELECT 1 FROM raft_applied WHERE event_id=?`, eventID).Scan(&dummy)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
@@ -1334,11 +1256,274 @@ func (s *Storage) RecordAppliedEvent(eventID string, idx int64) error {
 	if idx < 0 {
 		idx = 0
 	}
-	_, err := s.db.Exec(`INSERT OR IGNORE INTO raft_applied(event_id, idx, applied_at) VALUES(?,?,?)`,
+	_, err := s.conn().Exec(`INSERT OR IGNORE INTO raft_applied(event_id, idx, applied_at) VALUES(?,?,?)`,
 		eventID, idx, time.Now())
 	return err
 }
 
+// GetClientSession returns the last request applied for clientID, or nil if
+// the client has no session yet.
+func (s *Storage) GetClientSession(clientID string) (*ClientSession, error) {
+	var (
+		sess   = ClientSession{ClientID: clientID}
+		result string
+	)
+	err := s.conn().QueryRow(`SELECT seq, idx, result, error FROM raft_sessions WHERE client_id=?`, clientID).
+		Scan(&sess.Seq, &sess.Index, &result, &sess.Err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(result), &sess.Result); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// SaveClientSession records sess as the last request applied for its client.
+func (s *Storage) SaveClientSession(sess *ClientSession) error {
+	result, err := json.Marshal(sess.Result)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec(`INSERT INTO raft_sessions(client_id, seq, idx, result, error, updated_at) VALUES(?,?,?,?,?,?)
+        ON CONFLICT(client_id) DO UPDATE SET seq=excluded.seq, idx=excluded.idx, result=excluded.result, error=excluded.error, updated_at=excluded.updated_at`,
+		sess.ClientID, sess.Seq, sess.Index, string(result), sess.Err, time.Now())
+	return err
+}
+
+// ExpireClientSessions deletes the sessions whose last request was applied
+// below index.
+func (s *Storage) ExpireClientSessions(index int64) (int64, error) {
+	res, err := s.conn().Exec(`DELETE FROM raft_sessions WHERE idx < ?`, index)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ====================
+// Raft snapshots
+// ====================
+
+// stateMachineTables lists the tables mutated by SQLiteStateMachine. They form
+// the replicated state machine captured by snapshots; node-local tables such as
+// cluster_nodes, audit_logs and the raft_* bookkeeping are intentionally left out
+// (raft_applied and raft_sessions are included so that event-level idempotency
+// and client sessions survive a restore).
+var stateMachineTables = []string{
+	"users",
+	"groups",
+	"group_members",
+	"appointments",
+	"participants",
+	"notifications",
+	"events",
+	"raft_applied",
+	"raft_sessions",
+}
+
+// metaTables are the stateMachineTables owned by the meta group when the
+// agenda is sharded (see MultiRaft): the shards never write them, so the meta
+// group can snapshot and compare them on its own.
+var metaTables = []string{
+	"users",
+	"raft_applied",
+	"raft_sessions",
+}
+
+// SnapshotCell is a typed SQLite value. Keeping the type explicit lets DATETIME
+// and BLOB columns round-trip through JSON unchanged.
+type SnapshotCell struct {
+	I *int64     `json:"i,omitempty"`
+	F *float64   `json:"f,omitempty"`
+	S *string    `json:"s,omitempty"`
+	B []byte     `json:"b,omitempty"`
+	T *time.Time `json:"t,omitempty"`
+}
+
+// SnapshotTable holds every row of one state machine table.
+type SnapshotTable struct {
+	Name    string           `json:"name"`
+	Columns []string         `json:"columns"`
+	Rows    [][]SnapshotCell `json:"rows"`
+}
+
+// StateSnapshot is the serialized state machine at a given Raft index.
+type StateSnapshot struct {
+	Tables []SnapshotTable `json:"tables"`
+}
+
+func snapshotCellFrom(v any) SnapshotCell {
+	switch val := v.(type) {
+	case nil:
+		return SnapshotCell{}
+	case int64:
+		return SnapshotCell{I: &val}
+	case float64:
+		return SnapshotCell{F: &val}
+	case string:
+		return SnapshotCell{S: &val}
+	case []byte:
+		b := append([]byte(nil), val...)
+		return SnapshotCell{B: b}
+	case time.Time:
+		return SnapshotCell{T: &val}
+	case bool:
+		var i int64
+		if val {
+			i = 1
+		}
+		return SnapshotCell{I: &i}
+	default:
+		str := fmt.Sprint(val)
+		return SnapshotCell{S: &str}
+	}
+}
+
+func (c SnapshotCell) value() any {
+	switch {
+	case c.I != nil:
+		return *c.I
+	case c.F != nil:
+		return *c.F
+	case c.S != nil:
+		return *c.S
+	case c.B != nil:
+		return c.B
+	case c.T != nil:
+		return *c.T
+	default:
+		return nil
+	}
+}
+
+// DumpStateMachine reads every state machine table into a StateSnapshot.
+// Callers must prevent concurrent applies while dumping so that the result
+// corresponds to a single Raft index.
+func (s *Storage) DumpStateMachine() (*StateSnapshot, error) {
+	return s.dumpTables(stateMachineTables)
+}
+
+// dumpTables reads the given state machine tables into a StateSnapshot.
+func (s *Storage) dumpTables(tables []string) (*StateSnapshot, error) {
+	snap := &StateSnapshot{}
+	for _, table := range tables {
+		t, err := s.dumpTable(table)
+		if err != nil {
+			return nil, fmt.Errorf("dump %s: %w", table, err)
+		}
+		snap.Tables = append(snap.Tables, *t)
+	}
+	return snap, nil
+}
+
+func (s *Storage) dumpTable(table string) (*SnapshotTable, error) {
+	rows, err := s.conn().Query(`SELECT * FROM ` + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	t := &SnapshotTable{Name: table, Columns: cols}
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make([]SnapshotCell, len(cols))
+		for i, v := range vals {
+			row[i] = snapshotCellFrom(v)
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	return t, rows.Err()
+}
+
+// restoreStateMachineTx replaces the content of the given state machine
+// tables with the rows in snap. Tables missing from the snapshot are left
+// empty; the other tables are not touched. The snapshot comes from a peer, so
+// its column names are checked against the table before they go into SQL.
+func restoreStateMachineTx(tx *sql.Tx, snap *StateSnapshot, tables []string) error {
+	byName := make(map[string]SnapshotTable, len(snap.Tables))
+	for _, t := range snap.Tables {
+		byName[t.Name] = t
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("clear %s: %w", table, err)
+		}
+		t, ok := byName[table]
+		if !ok || len(t.Rows) == 0 {
+			continue
+		}
+		if err := checkSnapshotColumnsTx(tx, table, t.Columns); err != nil {
+			return err
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(t.Columns)), ",")
+		stmt, err := tx.Prepare(`INSERT INTO ` + table + `(` + strings.Join(t.Columns, ",") + `) VALUES(` + placeholders + `)`)
+		if err != nil {
+			return fmt.Errorf("prepare %s: %w", table, err)
+		}
+		for _, row := range t.Rows {
+			if len(row) != len(t.Columns) {
+				stmt.Close()
+				return fmt.Errorf("restore %s: row has %d values for %d columns", table, len(row), len(t.Columns))
+			}
+			args := make([]any, len(row))
+			for i, cell := range row {
+				args[i] = cell.value()
+			}
+			if _, err := stmt.Exec(args...); err != nil {
+				stmt.Close()
+				return fmt.Errorf("restore %s: %w", table, err)
+			}
+		}
+		stmt.Close()
+	}
+	return nil
+}
+
+// checkSnapshotColumnsTx fails unless cols are distinct columns of table.
+func checkSnapshotColumnsTx(tx *sql.Tx, table string, cols []string) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	known := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		known[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return fmt.Errorf("restore %s: snapshot has no columns", table)
+	}
+	seen := make(map[string]bool, len(cols))
+	for _, col := range cols {
+		if !known[col] || seen[col] {
+			return fmt.Errorf("restore %s: unknown or repeated column %q in snapshot", table, col)
+		}
+		seen[col] = true
+	}
+	return nil
+}
+
 // ====================
 // Cluster Nodes
 // ====================
@@ -1356,10 +1541,21 @@ func (s *Storage) UpsertClusterNode(node *ClusterNode) error {
 	if node.LastSeen.IsZero() {
 		node.LastSeen = time.Now()
 	}
-	_, err := s.db.Exec(`INSERT INTO cluster_nodes(node_id, address, source, last_seen)
-		VALUES(?,?,?,?)
+	// learner is only taken from new rows: most callers just refresh
+	// reachability and must not reset it (see SetClusterNodeLearner).
+	_, err := s.conn().Exec(`INSERT INTO cluster_nodes(node_id, address, source, last_seen, learner)
+		VALUES(?,?,?,?,?)
 		ON CONFLICT(node_id) DO UPDATE SET address=excluded.address, source=excluded.source, last_seen=excluded.last_seen`,
-		node.NodeID, node.Address, node.Source, node.LastSeen)
+		node.NodeID, node.Address, node.Source, node.LastSeen, node.Learner)
+	return err
+}
+
+// SetClusterNodeLearner marks a node as learner (non-voting) or voter.
+func (s *Storage) SetClusterNodeLearner(nodeID string, learner bool) error {
+	if nodeID == "" {
+		return errors.New("empty node id")
+	}
+	_, err := s.conn().Exec(`UPDATE cluster_nodes SET learner=? WHERE node_id=?`, learner, nodeID)
 	return err
 }
 
@@ -1367,12 +1563,12 @@ func (s *Storage) RemoveClusterNode(nodeID string) error {
 	if nodeID == "" {
 		return errors.New("empty node id")
 	}
-	_, err := s.db.Exec(`DELETE FROM cluster_nodes WHERE node_id=?`, nodeID)
+	_, err := s.conn().Exec(`DELETE FROM cluster_nodes WHERE node_id=?`, nodeID)
 	return err
 }
 
 func (s *Storage) ListClusterNodes() ([]ClusterNode, error) {
-	rows, err := s.db.Query(`SELECT node_id, address, source, last_seen FROM cluster_nodes`)
+	rows, err := s.conn().Query(`SELECT node_id, address, source, last_seen, learner FROM cluster_nodes`)
 	if err != nil {
 		return nil, err
 	}
@@ -1381,7 +1577,7 @@ func (s *Storage) ListClusterNodes() ([]ClusterNode, error) {
 	var nodes []ClusterNode
 	for rows.Next() {
 		var n ClusterNode
-		if err := rows.Scan(&n.NodeID, &n.Address, &n.Source, &n.LastSeen); err != nil {
+		if err := rows.Scan(&n.NodeID, &n.Address, &n.Source, &n.LastSeen, &n.Learner); err != nil {
 			return nil, err
 		}
 		nodes = append(nodes, n)
@@ -1404,7 +1600,7 @@ WHERE a.id = ? AND a.deleted = 0`
 
 	var a Appointment
 	var startTS, endTS int64
-	err := s.db.QueryRow(q, appointmentID).Scan(
+	err := s.conn().QueryRow(q, appointmentID).Scan(
 		&a.ID, &a.Title, &a.Description, &a.OwnerID, &a.GroupID,
 		&startTS, &endTS, &a.Privacy, &a.Status,
 		&a.CreatedAt, &a.UpdatedAt, &a.Version, &a.OriginNode, &a.Deleted)
@@ -1428,7 +1624,7 @@ JOIN users u ON u.id = p.user_id
 WHERE p.appointment_id = ?
 ORDER BY u.username ASC`
 
-	rows, err := s.db.Query(q, appointmentID)
+	rows, err := s.conn().Query(q, appointmentID)
 	if err != nil {
 		return nil, err
 	}